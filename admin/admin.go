@@ -0,0 +1,182 @@
+// Package admin serves the API operating the live connections of an edge node
+// over HTTP, the requests are authorized by a bearer token
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	eventbroker "github.com/micro-community/x-edge/broker"
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro/go-micro/v2/broker"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// MaxFrameSize of the frames sent by the API
+var MaxFrameSize int64 = 64 << 10
+
+// API of the node server, the routes are relative to its prefix:
+//
+//	GET    /conns              the live connections
+//	GET    /conns/{id}         a connection
+//	DELETE /conns/{id}         disconnects a connection
+//	POST   /conns/{id}/frames  sends the body as a frame, a protocol message of
+//	                           the content types of broker.Marshalers is encoded
+//	                           by the codec of device, the others are sent raw
+//	GET    /handlers           the handlers registered and their methods
+//
+// the id of connection is "local-remote" escaped as a path segment
+type API struct {
+	admin  nserver.Admin
+	token  string
+	prefix string
+}
+
+// NewHandler returns the API of a node server served under a prefix such as
+// "/admin", the requests without the token are unauthorized, so are all of
+// them if the token is empty
+func NewHandler(admin nserver.Admin, token, prefix string) *API {
+	return &API{admin: admin, token: token, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+// errorResponse of a request failed
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="edge"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.EscapedPath(), a.prefix)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "conns":
+		a.allow(w, r, http.MethodGet, a.listConns)
+	case len(parts) == 1 && parts[0] == "handlers":
+		a.allow(w, r, http.MethodGet, a.listHandlers)
+	case len(parts) == 2 && parts[0] == "conns":
+		id, err := url.PathUnescape(parts[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			a.getConn(w, id)
+		case http.MethodDelete:
+			a.disconnect(w, id)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case len(parts) == 3 && parts[0] == "conns" && parts[2] == "frames":
+		id, err := url.PathUnescape(parts[1])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		a.allow(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			a.sendFrame(w, r, id)
+		})
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorized tells if the request carries the token
+func (a *API) authorized(r *http.Request) bool {
+	if len(a.token) == 0 {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// allow a request of a method only
+func (a *API) allow(w http.ResponseWriter, r *http.Request, method string, fn http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	fn(w, r)
+}
+
+func (a *API) listConns(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.admin.LiveConns())
+}
+
+func (a *API) listHandlers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.admin.Handlers())
+}
+
+func (a *API) getConn(w http.ResponseWriter, id string) {
+	c, ok := a.admin.LiveConn(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, nserver.ErrNoConn.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (a *API) disconnect(w http.ResponseWriter, id string) {
+	if err := a.admin.Disconnect(id); err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+	log.Infof("connection %s disconnected by admin", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) sendFrame(w http.ResponseWriter, r *http.Request, id string) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxFrameSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if len(body) == 0 {
+		writeError(w, http.StatusBadRequest, "empty frame")
+		return
+	}
+	// the frame is encoded the way the commands of broker are
+	msg, err := eventbroker.Command(&broker.Message{
+		Header: map[string]string{"Content-Type": r.Header.Get("Content-Type")},
+		Body:   body,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.admin.SendTo(id, nil, msg); err != nil {
+		writeError(w, statusOf(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func statusOf(err error) int {
+	if err == nserver.ErrNoConn {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("unable to write admin response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
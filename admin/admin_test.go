@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	eventbroker "github.com/micro-community/x-edge/broker"
+	nserver "github.com/micro-community/x-edge/node/server"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+)
+
+const connID = "127.0.0.1:8000-127.0.0.1:9000"
+
+// fakeAdmin has a live connection of meter-42
+type fakeAdmin struct {
+	closed bool
+	sent   []interface{}
+}
+
+func (f *fakeAdmin) LiveConns() []nserver.ConnInfo {
+	if f.closed {
+		return nil
+	}
+	return []nserver.ConnInfo{{ID: connID, Remote: "127.0.0.1:9000", Transport: "tcp", Devices: []string{"meter-42"}, FramesIn: 3}}
+}
+
+func (f *fakeAdmin) LiveConn(id string) (nserver.ConnInfo, bool) {
+	for _, c := range f.LiveConns() {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return nserver.ConnInfo{}, false
+}
+
+func (f *fakeAdmin) Disconnect(id string) error {
+	if _, ok := f.LiveConn(id); !ok {
+		return nserver.ErrNoConn
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *fakeAdmin) SendTo(id string, hdr map[string]string, msg interface{}) error {
+	if _, ok := f.LiveConn(id); !ok {
+		return nserver.ErrNoConn
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeAdmin) Handlers() []nserver.HandlerInfo {
+	return []nserver.HandlerInfo{{Name: "PROTOCOLSERVER", Methods: []string{"Event"}}}
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path, contentType, body string) *http.Response {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rsp
+}
+
+func TestAPI(t *testing.T) {
+	fa := new(fakeAdmin)
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", NewHandler(fa, "secret", "/admin"))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// the token is required
+	rsp, err := http.Get(srv.URL + "/admin/conns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected unauthorized, got %d", rsp.StatusCode)
+	}
+
+	rsp = do(t, srv, http.MethodGet, "/admin/conns", "", "")
+	var conns []nserver.ConnInfo
+	if err := json.NewDecoder(rsp.Body).Decode(&conns); err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if len(conns) != 1 || conns[0].ID != connID || conns[0].Devices[0] != "meter-42" || conns[0].FramesIn != 3 {
+		t.Fatalf("Unexpected connections %+v", conns)
+	}
+
+	path := "/admin/conns/" + url.PathEscape(connID)
+	rsp = do(t, srv, http.MethodGet, path, "", "")
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected connection found, got %d", rsp.StatusCode)
+	}
+
+	// a frame is sent raw, a protocol message is encoded
+	rsp = do(t, srv, http.MethodPost, path+"/frames", "application/xml", "<PING/>")
+	rsp.Body.Close()
+	rsp = do(t, srv, http.MethodPost, path+"/frames", "application/json", `{"Ver":"1.0","Name":"meter-42","Type":"CMD"}`)
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusAccepted || len(fa.sent) != 2 {
+		t.Fatalf("Expected 2 frames sent, got %d: %v", rsp.StatusCode, fa.sent)
+	}
+	if f, ok := fa.sent[0].(*raw.Frame); !ok || string(f.Data) != "<PING/>" {
+		t.Fatalf("Expected raw frame, got %#v", fa.sent[0])
+	}
+	if c, ok := fa.sent[1].(*eventbroker.CommandPackage); !ok || c.Name != "meter-42" || c.Type != "CMD" {
+		t.Fatalf("Expected command package, got %#v", fa.sent[1])
+	}
+
+	rsp = do(t, srv, http.MethodGet, "/admin/handlers", "", "")
+	var handlers []nserver.HandlerInfo
+	if err := json.NewDecoder(rsp.Body).Decode(&handlers); err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if len(handlers) != 1 || handlers[0].Name != "PROTOCOLSERVER" {
+		t.Fatalf("Unexpected handlers %+v", handlers)
+	}
+
+	rsp = do(t, srv, http.MethodDelete, path, "", "")
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusNoContent || !fa.closed {
+		t.Fatalf("Expected connection disconnected, got %d", rsp.StatusCode)
+	}
+	rsp = do(t, srv, http.MethodDelete, path, "", "")
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected connection not found, got %d", rsp.StatusCode)
+	}
+	rsp = do(t, srv, http.MethodPut, "/admin/conns", "", "")
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected method not allowed, got %d", rsp.StatusCode)
+	}
+}
@@ -0,0 +1,169 @@
+package eventbroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	config "github.com/micro-community/x-edge/cmd"
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro/go-micro/v2/client"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	log "github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/metadata"
+	"github.com/micro/go-micro/v2/server"
+)
+
+// RawContentType is the content type of frames published as they are
+const RawContentType = "application/octet-stream"
+
+var topicVars = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// BridgeRule maps the frames of a packet type or of a handler endpoint
+// to a broker topic, the topic is a template of frame headers such
+// as "devices.{NAME}.{TYPE}"
+type BridgeRule struct {
+	// Type is the packet type of frames
+	Type string
+	// Endpoint is the handler "Service.Method" of frames
+	Endpoint string
+	// Topic template of the broker topic
+	Topic string
+	// Payload maps a decoded request to the message to publish,
+	// the raw frame is published if it is nil
+	Payload func(req server.Request) (interface{}, error)
+	// ContentType of published message, RawContentType for raw frames
+	// and the content type of client by default
+	ContentType string
+}
+
+// Bridge publishes the frames handled by edge router to go-micro broker topics
+type Bridge struct {
+	client client.Client
+	rules  []BridgeRule
+}
+
+// NewBridge creates a bridge publishing through a go-micro client
+func NewBridge(c client.Client, rules ...BridgeRule) *Bridge {
+	return &Bridge{
+		client: c,
+		rules:  rules,
+	}
+}
+
+// Match returns the rules matching a request
+func (b *Bridge) Match(req server.Request) []BridgeRule {
+	var rules []BridgeRule
+	for _, r := range b.rules {
+		if len(r.Type) > 0 && r.Type != req.Header()["TYPE"] {
+			continue
+		}
+		if len(r.Endpoint) > 0 && r.Endpoint != req.Endpoint() {
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// Publish publishes a request by the rules it matches
+func (b *Bridge) Publish(ctx context.Context, req server.Request) error {
+	var errs []string
+	for _, r := range b.Match(req) {
+		if err := b.publish(ctx, r, req); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("bridge publish error: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (b *Bridge) publish(ctx context.Context, r BridgeRule, req server.Request) error {
+	topic, err := Topic(r.Topic, req.Header())
+	if err != nil {
+		return err
+	}
+
+	var payload interface{}
+	contentType := r.ContentType
+
+	if r.Payload != nil {
+		if payload, err = r.Payload(req); err != nil {
+			return err
+		}
+	} else {
+		payload = &raw.Frame{Data: nserver.FrameOf(req)}
+		if len(contentType) == 0 {
+			contentType = RawContentType
+		}
+	}
+
+	var opts []client.MessageOption
+	if len(contentType) > 0 {
+		opts = append(opts, client.WithMessageContentType(contentType))
+	}
+
+	ctx = metadata.MergeContext(ctx, Headers(req.Header()), true)
+	return b.client.Publish(ctx, b.client.NewMessage(topic, payload, opts...))
+}
+
+// HandlerWrapper publishes the frames after handled by edge router successfully,
+// a publish error is logged only, it never fails the request of device
+func (b *Bridge) HandlerWrapper() server.HandlerWrapper {
+	return func(fn server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			if err := fn(ctx, req, rsp); err != nil {
+				return err
+			}
+			if err := b.Publish(ctx, req); err != nil {
+				log.Errorf("frame of %s: %v", req.Header()["Remote"], err)
+			}
+			return nil
+		}
+	}
+}
+
+// ErrToken is the error of a value filling a topic which is not a single token
+// of it, a device id of "*" would subscribe the commands of all devices
+var ErrToken = errors.New("not a token of topic")
+
+// tokenChars are the separators and wildcards of the topics of brokers
+const tokenChars = ".*>/#+ \t\r\n"
+
+// Topic fills a topic template with the frame headers, the values sent by
+// devices fill a single token each, without separators or wildcards
+func Topic(tmpl string, hdr map[string]string) (string, error) {
+	var missing, invalid []string
+	topic := topicVars.ReplaceAllStringFunc(tmpl, func(v string) string {
+		key := v[1 : len(v)-1]
+		val, ok := hdr[key]
+		if !ok || len(val) == 0 {
+			missing = append(missing, key)
+		} else if strings.ContainsAny(val, tokenChars) {
+			invalid = append(invalid, fmt.Sprintf("%s %q", key, val))
+		}
+		return val
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("topic %s: missing %s", tmpl, strings.Join(missing, ", "))
+	}
+	if len(invalid) > 0 {
+		return "", fmt.Errorf("topic %s: %s %v", tmpl, strings.Join(invalid, ", "), ErrToken)
+	}
+	return topic, nil
+}
+
+// Headers returns the metadata of a frame published to broker,
+// the keys of frame headers are prefixed by x-edge- and the client
+// capitalises them as X-Edge-Name
+func Headers(hdr map[string]string) map[string]string {
+	md := make(map[string]string, len(hdr))
+	for k, v := range hdr {
+		md[config.HeaderPrefix+strings.ToLower(k)] = v
+	}
+	return md
+}
@@ -0,0 +1,160 @@
+package eventbroker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2/broker"
+	"github.com/micro/go-micro/v2/broker/memory"
+	"github.com/micro/go-micro/v2/client"
+	"github.com/micro/go-micro/v2/codec"
+	"github.com/micro/go-micro/v2/server"
+)
+
+type testRequest struct {
+	endpoint string
+	header   map[string]string
+	body     []byte
+}
+
+func (r *testRequest) Service() string           { return "ProtocolServer" }
+func (r *testRequest) Method() string            { return r.endpoint }
+func (r *testRequest) Endpoint() string          { return r.endpoint }
+func (r *testRequest) ContentType() string       { return "application/xml" }
+func (r *testRequest) Header() map[string]string { return r.header }
+func (r *testRequest) Body() interface{}         { return &codec.Message{Body: r.body} }
+func (r *testRequest) Read() ([]byte, error)     { return r.body, nil }
+func (r *testRequest) Codec() codec.Reader       { return nil }
+func (r *testRequest) Stream() bool              { return false }
+
+func newEventRequest() *testRequest {
+	return &testRequest{
+		endpoint: "ProtocolServer.Event",
+		header: map[string]string{
+			"Remote": "10.0.0.8:5000",
+			"NAME":   "meter-42",
+			"TYPE":   "1",
+		},
+		body: []byte("<PROTOCOL><NAME>meter-42</NAME><TYPE>1</TYPE></PROTOCOL>"),
+	}
+}
+
+func newMemoryBroker(t *testing.T, topic string) (broker.Broker, chan *broker.Message) {
+	b := memory.NewBroker()
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	msgs := make(chan *broker.Message, 10)
+	if _, err := b.Subscribe(topic, func(e broker.Event) error {
+		msgs <- e.Message()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return b, msgs
+}
+
+func TestTopic(t *testing.T) {
+	topic, err := Topic("devices.{NAME}.{TYPE}", map[string]string{"NAME": "meter-42", "TYPE": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic != "devices.meter-42.1" {
+		t.Fatalf("Expected topic devices.meter-42.1, got %s", topic)
+	}
+
+	if _, err := Topic("devices.{NAME}", map[string]string{}); err == nil {
+		t.Fatal("Expected error of missing header")
+	}
+
+	// a device publishes to its own topics only
+	for _, name := range []string{"*", ">", "meter-42.alarms", "meter/#", "meter+"} {
+		if _, err := Topic("devices.{NAME}", map[string]string{"NAME": name}); err == nil || !strings.Contains(err.Error(), ErrToken.Error()) {
+			t.Fatalf("Expected NAME %q rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestBridgeHandlerWrapper(t *testing.T) {
+	b, msgs := newMemoryBroker(t, "devices.meter-42.1")
+	bridge := NewBridge(client.NewClient(client.Broker(b)),
+		BridgeRule{Type: "1", Topic: "devices.{NAME}.{TYPE}"},
+		BridgeRule{Type: "2", Topic: "alarms.{NAME}"},
+	)
+
+	req := newEventRequest()
+	fn := bridge.HandlerWrapper()(func(ctx context.Context, req server.Request, rsp interface{}) error {
+		return nil
+	})
+	if err := fn(context.Background(), req, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case m := <-msgs:
+		if string(m.Body) != string(req.body) {
+			t.Fatalf("Expected frame %s, got %s", req.body, m.Body)
+		}
+		if ct := m.Header["Content-Type"]; ct != RawContentType {
+			t.Fatalf("Expected content type %s, got %s", RawContentType, ct)
+		}
+		if r := m.Header["X-Edge-Remote"]; r != "10.0.0.8:5000" {
+			t.Fatalf("Expected remote header 10.0.0.8:5000, got %s", r)
+		}
+		if n := m.Header["X-Edge-Name"]; n != "meter-42" {
+			t.Fatalf("Expected name header meter-42, got %s", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frame was not published")
+	}
+}
+
+func TestBridgeEndpointRule(t *testing.T) {
+	b, msgs := newMemoryBroker(t, "events.meter-42")
+	bridge := NewBridge(client.NewClient(client.Broker(b)),
+		BridgeRule{
+			Endpoint:    "ProtocolServer.Event",
+			Topic:       "events.{NAME}",
+			ContentType: "application/json",
+			Payload: func(req server.Request) (interface{}, error) {
+				return map[string]string{"device": req.Header()["NAME"]}, nil
+			},
+		},
+	)
+
+	if err := bridge.Publish(context.Background(), newEventRequest()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case m := <-msgs:
+		if strings.TrimSpace(string(m.Body)) != `{"device":"meter-42"}` {
+			t.Fatalf("Expected mapped payload, got %s", m.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frame was not published")
+	}
+}
+
+func TestBridgeHandlerError(t *testing.T) {
+	b, msgs := newMemoryBroker(t, "devices.meter-42.1")
+	bridge := NewBridge(client.NewClient(client.Broker(b)),
+		BridgeRule{Type: "1", Topic: "devices.{NAME}.{TYPE}"},
+	)
+
+	fn := bridge.HandlerWrapper()(func(ctx context.Context, req server.Request, rsp interface{}) error {
+		return errors.New("denied")
+	})
+	if err := fn(context.Background(), newEventRequest(), nil); err == nil {
+		t.Fatal("Expected handler error")
+	}
+
+	select {
+	case m := <-msgs:
+		t.Fatalf("Unexpected frame published %s", m.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -2,16 +2,20 @@ package eventbroker
 
 import (
 	"context"
+	"errors"
 
 	config "github.com/micro-community/x-edge/cmd"
 	"github.com/micro-community/x-edge/proto/protocol"
 	"github.com/micro-community/x-edge/subscriber"
 	"github.com/micro/go-micro/v2"
+	"github.com/micro/go-micro/v2/broker"
 )
 
 // PubSubBroker is an status publisher for the go-micro broker
 type PubSubBroker struct {
 	publisher micro.Publisher
+	// broker the publisher publishes to
+	broker broker.Broker
 }
 
 var (
@@ -22,6 +26,7 @@ var (
 func RegisterMessagePublisher(service micro.Service) {
 	//eventWorker.publisher = micro.NewPublisher(config.EventPublisherName, service.Client())
 	eventWorker.publisher = micro.NewPublisher(config.EventSubscriberName, service.Client())
+	eventWorker.broker = service.Client().Options().Broker
 
 }
 
@@ -37,3 +42,27 @@ func RegisterMessageSubscriber(service micro.Service) {
 func PublishEventMessage(msg *protocol.Message) error {
 	return eventWorker.publisher.Publish(context.Background(), msg)
 }
+
+// ErrNoPublisher is the error of the event messages published before
+// RegisterMessagePublisher
+var ErrNoPublisher = errors.New("event publisher not registered")
+
+// ProbeTopic is the topic the checks of broker publish an empty message to
+var ProbeTopic = "edge.probe"
+
+// CheckPublisher tells whether the broker PublishEventMessage publishes to
+// takes a message, it fails until RegisterMessagePublisher
+func CheckPublisher(ctx context.Context) error {
+	if eventWorker.broker == nil {
+		return ErrNoPublisher
+	}
+	return CheckBroker(ctx, eventWorker.broker)
+}
+
+// CheckBroker tells whether a broker takes a message, it publishes an empty
+// message to ProbeTopic, a broker disconnected fails without being connected
+func CheckBroker(ctx context.Context, b broker.Broker) error {
+	return b.Publish(ProbeTopic, &broker.Message{
+		Header: map[string]string{"Content-Type": RawContentType},
+	})
+}
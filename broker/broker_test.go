@@ -0,0 +1,48 @@
+package eventbroker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2"
+	"github.com/micro/go-micro/v2/broker"
+	"github.com/micro/go-micro/v2/broker/memory"
+)
+
+func TestCheckPublisher(t *testing.T) {
+	defer func(w PubSubBroker) { eventWorker = w }(eventWorker)
+	eventWorker = PubSubBroker{}
+
+	if err := CheckPublisher(context.Background()); err != ErrNoPublisher {
+		t.Fatalf("Expected %v, got %v", ErrNoPublisher, err)
+	}
+
+	// the broker is probed by a message, not connected by the check
+	b := &uplinkBroker{Broker: memory.NewBroker(), down: true}
+	RegisterMessagePublisher(micro.NewService(micro.Broker(b)))
+	if err := CheckPublisher(context.Background()); err == nil {
+		t.Fatal("Expected publisher failing while the uplink is down")
+	}
+
+	b.down = false
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Disconnect()
+	probes := make(chan bool, 1)
+	if _, err := b.Subscribe(ProbeTopic, func(broker.Event) error {
+		probes <- true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPublisher(context.Background()); err != nil {
+		t.Fatalf("Expected publisher ready, got %v", err)
+	}
+	select {
+	case <-probes:
+	case <-time.After(time.Second):
+		t.Fatal("probe was not published")
+	}
+}
@@ -0,0 +1,188 @@
+package eventbroker
+
+import (
+	"context"
+	"encoding/xml"
+	"sync"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro-community/x-edge/proto/protocol"
+	"github.com/micro/go-micro/v2/broker"
+	"github.com/micro/go-micro/v2/client"
+	"github.com/micro/go-micro/v2/codec"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	jsonc "github.com/micro/go-micro/v2/codec/json"
+	protoc "github.com/micro/go-micro/v2/codec/proto"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// Marshalers of broker messages by content type, the commands of other
+// content types are delivered to devices as raw payload
+var Marshalers = map[string]codec.Marshaler{
+	"application/json":     jsonc.Marshaler{},
+	"application/protobuf": protoc.Marshaler{},
+	"application/proto":    protoc.Marshaler{},
+}
+
+// CommandPackage is the frame of a protocol.Message delivered to device
+type CommandPackage struct {
+	XMLName xml.Name `xml:"PROTOCOL"`
+	Version string   `xml:"VER"`
+	Name    string   `xml:"NAME"`
+	Gender  string   `xml:"GENDER"`
+	Type    string   `xml:"TYPE"`
+	Addr    string   `xml:"ADDR"`
+	Phone   string   `xml:"PHONE"`
+	Company string   `xml:"COMPANY"`
+	Time    string   `xml:"TIME"`
+}
+
+// Receipt is published back to broker once a command is delivered or failed
+type Receipt struct {
+	Device    string `json:"device"`
+	ID        string `json:"id,omitempty"`
+	Topic     string `json:"topic"`
+	Delivered bool   `json:"delivered"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Downstream delivers the commands of broker topics to the devices connected,
+// it subscribes the topic of a device such as "commands.{deviceId}" while
+// the device is connected to this edge node
+type Downstream struct {
+	client  client.Client
+	devices nserver.Server
+	// topic template of commands
+	topic string
+	// topic template of receipts, no receipt is published if it is empty
+	receipt string
+
+	sync.Mutex
+	subs map[string]broker.Subscriber
+}
+
+// NewDownstream creates a downstream bridge of commands to the devices of server
+func NewDownstream(c client.Client, srv nserver.Server, topic, receipt string) *Downstream {
+	return &Downstream{
+		client:  c,
+		devices: srv,
+		topic:   topic,
+		receipt: receipt,
+		subs:    make(map[string]broker.Subscriber),
+	}
+}
+
+// Watch subscribes the commands of a device connected and unsubscribes them
+// on disconnecting, it is a nserver.DeviceHook
+func (d *Downstream) Watch(device string, connected bool) {
+	if connected {
+		if err := d.subscribe(device); err != nil {
+			log.Errorf("unable to subscribe commands of %s: %v", device, err)
+		}
+		return
+	}
+	d.unsubscribe(device)
+}
+
+func (d *Downstream) subscribe(device string) error {
+	topic, err := Topic(d.topic, map[string]string{"deviceId": device})
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.subs[device]; ok {
+		return nil
+	}
+	sub, err := d.client.Options().Broker.Subscribe(topic, d.handler(device))
+	if err != nil {
+		return err
+	}
+	d.subs[device] = sub
+	return nil
+}
+
+func (d *Downstream) unsubscribe(device string) {
+	d.Lock()
+	sub, ok := d.subs[device]
+	delete(d.subs, device)
+	d.Unlock()
+
+	if ok {
+		if err := sub.Unsubscribe(); err != nil {
+			log.Errorf("unable to unsubscribe commands of %s: %v", device, err)
+		}
+	}
+}
+
+func (d *Downstream) handler(device string) broker.Handler {
+	return func(e broker.Event) error {
+		msg := e.Message()
+		payload, err := Command(msg)
+		if err == nil {
+			// the service publishing the command is authorized by the node server
+			hdr := map[string]string{nserver.ServiceHeader: msg.Header[nserver.ServiceHeader]}
+			// so is the packet type of a command typed
+			if p, ok := payload.(*CommandPackage); ok {
+				hdr["TYPE"] = p.Type
+			}
+			err = d.devices.Deliver(device, hdr, payload)
+		}
+		if err != nil {
+			log.Errorf("unable to deliver command to %s: %v", device, err)
+		}
+		d.publishReceipt(device, e.Topic(), msg, err)
+		return err
+	}
+}
+
+func (d *Downstream) publishReceipt(device, topic string, msg *broker.Message, err error) {
+	if len(d.receipt) == 0 {
+		return
+	}
+	rt, terr := Topic(d.receipt, map[string]string{"deviceId": device})
+	if terr != nil {
+		log.Errorf("unable to publish receipt of %s: %v", device, terr)
+		return
+	}
+
+	receipt := &Receipt{
+		Device:    device,
+		ID:        msg.Header["Micro-Id"],
+		Topic:     topic,
+		Delivered: err == nil,
+	}
+	if err != nil {
+		receipt.Error = err.Error()
+	}
+
+	m := d.client.NewMessage(rt, receipt, client.WithMessageContentType("application/json"))
+	if perr := d.client.Publish(context.Background(), m); perr != nil {
+		log.Errorf("unable to publish receipt of %s: %v", device, perr)
+	}
+}
+
+// Command returns the payload of a command delivered to device,
+// a protocol.Message is turned into a CommandPackage
+func Command(msg *broker.Message) (interface{}, error) {
+	m, ok := Marshalers[msg.Header["Content-Type"]]
+	if !ok {
+		return &raw.Frame{Data: msg.Body}, nil
+	}
+
+	var pm protocol.Message
+	if err := m.Unmarshal(msg.Body, &pm); err != nil {
+		return nil, err
+	}
+	return &CommandPackage{
+		Version: pm.Ver,
+		Name:    pm.Name,
+		Gender:  pm.Gender,
+		Type:    pm.Type,
+		Addr:    pm.Addr,
+		Phone:   pm.Phone,
+		Company: pm.Company,
+		Time:    pm.Time,
+	}, nil
+}
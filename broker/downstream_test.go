@@ -0,0 +1,156 @@
+package eventbroker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro-community/x-edge/proto/protocol"
+	"github.com/micro/go-micro/v2/broker"
+	"github.com/micro/go-micro/v2/client"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	"github.com/micro/go-micro/v2/server"
+)
+
+// testDevices is a node server recording the deliveries
+type testDevices struct {
+	server.Server
+	delivered chan interface{}
+	err       error
+	// header of the last delivery
+	header map[string]string
+}
+
+func (d *testDevices) Connections() []string {
+	return []string{"meter-42"}
+}
+
+func (d *testDevices) Deliver(device string, hdr map[string]string, msg interface{}) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.header = hdr
+	d.delivered <- msg
+	return nil
+}
+
+var _ nserver.Server = (*testDevices)(nil)
+
+func newDownstream(t *testing.T, devices *testDevices) (*Downstream, client.Client, chan *broker.Message) {
+	b, receipts := newMemoryBroker(t, "receipts.meter-42")
+	c := client.NewClient(client.Broker(b))
+	d := NewDownstream(c, devices, "commands.{deviceId}", "receipts.{deviceId}")
+	d.Watch("meter-42", true)
+	return d, c, receipts
+}
+
+func expectReceipt(t *testing.T, receipts chan *broker.Message) *Receipt {
+	select {
+	case m := <-receipts:
+		r := new(Receipt)
+		if err := json.Unmarshal(m.Body, r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("receipt was not published")
+	}
+	return nil
+}
+
+func TestDownstreamRawCommand(t *testing.T) {
+	devices := &testDevices{delivered: make(chan interface{}, 1)}
+	_, c, receipts := newDownstream(t, devices)
+
+	err := c.Options().Broker.Publish("commands.meter-42", &broker.Message{
+		Header: map[string]string{"Content-Type": RawContentType, "Micro-Id": "42"},
+		Body:   []byte("<PROTOCOL><TYPE>Reset</TYPE></PROTOCOL>"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-devices.delivered:
+		f, ok := msg.(*raw.Frame)
+		if !ok || string(f.Data) != "<PROTOCOL><TYPE>Reset</TYPE></PROTOCOL>" {
+			t.Fatalf("Expected raw command, got %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("command was not delivered")
+	}
+
+	r := expectReceipt(t, receipts)
+	if !r.Delivered || r.Device != "meter-42" || r.ID != "42" {
+		t.Fatalf("Unexpected receipt %+v", r)
+	}
+}
+
+func TestDownstreamProtocolMessage(t *testing.T) {
+	devices := &testDevices{delivered: make(chan interface{}, 1)}
+	_, c, receipts := newDownstream(t, devices)
+
+	msg := c.NewMessage("commands.meter-42", &protocol.Message{Name: "meter-42", Type: "Reset"})
+	if err := c.Publish(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-devices.delivered:
+		p, ok := msg.(*CommandPackage)
+		if !ok || p.Name != "meter-42" || p.Type != "Reset" {
+			t.Fatalf("Expected command package, got %v", msg)
+		}
+		if devices.header["TYPE"] != "Reset" {
+			t.Fatalf("Expected packet type of command in header, got %v", devices.header)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("command was not delivered")
+	}
+	expectReceipt(t, receipts)
+}
+
+func TestDownstreamFailure(t *testing.T) {
+	devices := &testDevices{err: nserver.ErrNotConnected}
+	d, c, receipts := newDownstream(t, devices)
+
+	c.Options().Broker.Publish("commands.meter-42", &broker.Message{Body: []byte("<PING/>")})
+
+	r := expectReceipt(t, receipts)
+	if r.Delivered || r.Error != nserver.ErrNotConnected.Error() {
+		t.Fatalf("Unexpected receipt %+v", r)
+	}
+
+	// no command is delivered after disconnecting,
+	// the memory broker unsubscribes asynchronously
+	d.Watch("meter-42", false)
+	time.Sleep(10 * time.Millisecond)
+	devices.err = errors.New("unexpected delivery")
+	c.Options().Broker.Publish("commands.meter-42", &broker.Message{Body: []byte("<PING/>")})
+
+	select {
+	case r := <-receipts:
+		t.Fatalf("Unexpected receipt %s", r.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDownstreamDeviceID(t *testing.T) {
+	devices := &testDevices{delivered: make(chan interface{}, 1)}
+	d, _, _ := newDownstream(t, devices)
+
+	for _, device := range []string{"*", ">", "meter.*", "meter/#", "meter 42"} {
+		if err := d.subscribe(device); err == nil || !strings.Contains(err.Error(), ErrToken.Error()) {
+			t.Fatalf("Expected device id %q rejected, got %v", device, err)
+		}
+	}
+	d.Lock()
+	defer d.Unlock()
+	if len(d.subs) != 1 {
+		t.Fatalf("Expected the commands of meter-42 subscribed only, got %v", d.subs)
+	}
+}
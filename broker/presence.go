@@ -0,0 +1,133 @@
+package eventbroker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro/go-micro/v2/client"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// PresenceMessage is published to broker on the lifecycle events of
+// device connections, so fleet online status can be tracked upstream
+type PresenceMessage struct {
+	Event       string    `json:"event"`
+	Node        string    `json:"node,omitempty"`
+	Conn        string    `json:"conn"`
+	Remote      string    `json:"remote"`
+	Devices     []string  `json:"devices,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+	ConnectedAt time.Time `json:"connected_at"`
+	// Duration of the connection in milliseconds
+	Duration int64  `json:"duration_ms"`
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+// DefaultPresenceQueue is the presence events waiting to be published,
+// the events are dropped once it is full
+var DefaultPresenceQueue = 1024
+
+// Presence publishes the presence events of a node server to a broker topic,
+// "{event}" in topic is the type of event, e.g. "presence.{event}", the events
+// are published in order by a goroutine so a broker outage never stalls the
+// connections of devices
+type Presence struct {
+	client client.Client
+	topic  string
+	node   string
+
+	queue chan presenceMessage
+	exit  chan bool
+	done  chan bool
+	once  sync.Once
+}
+
+// presenceMessage of a device connection to publish
+type presenceMessage struct {
+	remote string
+	msg    client.Message
+}
+
+// NewPresence creates a publisher of presence events, node names the edge
+// node in the messages, it publishes until Stop
+func NewPresence(c client.Client, topic, node string) *Presence {
+	p := &Presence{
+		client: c,
+		topic:  topic,
+		node:   node,
+		queue:  make(chan presenceMessage, DefaultPresenceQueue),
+		exit:   make(chan bool),
+		done:   make(chan bool),
+	}
+	go p.run()
+	return p
+}
+
+// Publish an event to broker, it is a nserver.PresenceHook queuing the event
+func (p *Presence) Publish(ev nserver.PresenceEvent) {
+	topic, err := Topic(p.topic, map[string]string{"event": string(ev.Type)})
+	if err != nil {
+		log.Errorf("unable to publish presence of %s: %v", ev.Remote, err)
+		return
+	}
+
+	msg := &PresenceMessage{
+		Event:       string(ev.Type),
+		Node:        p.node,
+		Conn:        ev.Conn,
+		Remote:      ev.Remote,
+		Devices:     ev.Devices,
+		Reason:      ev.Reason,
+		Error:       ev.Error,
+		Time:        ev.Time,
+		ConnectedAt: ev.ConnectedAt,
+		Duration:    int64(ev.Duration / time.Millisecond),
+		BytesIn:     ev.BytesIn,
+		BytesOut:    ev.BytesOut,
+	}
+	m := p.client.NewMessage(topic, msg, client.WithMessageContentType("application/json"))
+	select {
+	case p.queue <- presenceMessage{remote: ev.Remote, msg: m}:
+	default:
+		log.Warnf("presence queue full, dropped %s of %s", ev.Type, ev.Remote)
+	}
+}
+
+// Stop publishing once the events queued are published
+func (p *Presence) Stop() {
+	p.once.Do(func() {
+		close(p.exit)
+	})
+	<-p.done
+}
+
+func (p *Presence) run() {
+	defer close(p.done)
+	for {
+		select {
+		case m := <-p.queue:
+			p.publish(m)
+		case <-p.exit:
+			// the events of the connections closed on shutdown are published last
+			for {
+				select {
+				case m := <-p.queue:
+					p.publish(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Presence) publish(m presenceMessage) {
+	if err := p.client.Publish(context.Background(), m.msg); err != nil {
+		log.Errorf("unable to publish presence of %s: %v", m.remote, err)
+	}
+}
@@ -0,0 +1,101 @@
+package eventbroker
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro/go-micro/v2/broker"
+	"github.com/micro/go-micro/v2/broker/memory"
+	"github.com/micro/go-micro/v2/client"
+)
+
+func TestPresencePublish(t *testing.T) {
+	b, msgs := newMemoryBroker(t, "presence.closed")
+	p := NewPresence(client.NewClient(client.Broker(b)), "presence.{event}", "edge-1")
+
+	connectedAt := time.Now().Add(-90 * time.Second)
+	p.Publish(nserver.PresenceEvent{
+		Type:        nserver.PresenceClosed,
+		Conn:        "127.0.0.1:8000-127.0.0.1:9000",
+		Remote:      "127.0.0.1:9000",
+		Devices:     []string{"meter-42"},
+		Reason:      nserver.ReasonTimeout,
+		Error:       "i/o timeout",
+		Time:        connectedAt.Add(90 * time.Second),
+		ConnectedAt: connectedAt,
+		Duration:    90 * time.Second,
+		BytesIn:     512,
+		BytesOut:    128,
+	})
+
+	select {
+	case m := <-msgs:
+		var pm PresenceMessage
+		if err := json.Unmarshal(m.Body, &pm); err != nil {
+			t.Fatal(err)
+		}
+		if pm.Event != "closed" || pm.Node != "edge-1" || pm.Reason != "timeout" ||
+			len(pm.Devices) != 1 || pm.Devices[0] != "meter-42" {
+			t.Fatalf("Unexpected presence %+v", pm)
+		}
+		if pm.Duration != 90000 || pm.BytesIn != 512 || pm.BytesOut != 128 {
+			t.Fatalf("Unexpected counters of presence %+v", pm)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("presence was not published")
+	}
+}
+
+// stalledBroker never returns from a publish until it is released
+type stalledBroker struct {
+	broker.Broker
+	release chan bool
+}
+
+func (b *stalledBroker) Publish(topic string, m *broker.Message, opts ...broker.PublishOption) error {
+	<-b.release
+	return b.Broker.Publish(topic, m, opts...)
+}
+
+func TestPresenceNeverBlocks(t *testing.T) {
+	defer func(n int) { DefaultPresenceQueue = n }(DefaultPresenceQueue)
+	DefaultPresenceQueue = 4
+
+	mb := memory.NewBroker()
+	if err := mb.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	var received int32
+	if _, err := mb.Subscribe("presence.accepted", func(broker.Event) error {
+		atomic.AddInt32(&received, 1)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b := &stalledBroker{Broker: mb, release: make(chan bool)}
+	p := NewPresence(client.NewClient(client.Broker(b)), "presence.{event}", "edge-1")
+
+	// the hook returns while the broker is stalled, the events beyond the queue are dropped
+	published := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			p.Publish(nserver.PresenceEvent{Type: nserver.PresenceAccepted, Remote: "127.0.0.1:9000"})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Expected presence published without blocking")
+	}
+
+	close(b.release)
+	p.Stop()
+	// the queue and the event stalled in publish
+	if n := atomic.LoadInt32(&received); n < 4 || n > 5 {
+		t.Fatalf("Expected the events queued published on stop, got %d", n)
+	}
+}
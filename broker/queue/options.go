@@ -0,0 +1,39 @@
+package queue
+
+import "time"
+
+// Options of queue
+type Options struct {
+	// Dir keeps the records
+	Dir string
+	// MaxRecords bounds the number of records, 0 means no bound
+	MaxRecords int
+	// MaxSize bounds the size of records in bytes, 0 means no bound
+	MaxSize int64
+	// MaxAge drops the records older than it, 0 means they never expire
+	MaxAge time.Duration
+}
+
+// Option of queue
+type Option func(*Options)
+
+// MaxRecords bounds the number of records, the oldest ones are dropped first
+func MaxRecords(n int) Option {
+	return func(o *Options) {
+		o.MaxRecords = n
+	}
+}
+
+// MaxSize bounds the size of records in bytes, the oldest ones are dropped first
+func MaxSize(n int64) Option {
+	return func(o *Options) {
+		o.MaxSize = n
+	}
+}
+
+// MaxAge drops the records older than d
+func MaxAge(d time.Duration) Option {
+	return func(o *Options) {
+		o.MaxAge = d
+	}
+}
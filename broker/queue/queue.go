@@ -0,0 +1,240 @@
+// Package queue provides a disk-backed write-ahead queue, the messages
+// failed to publish are kept in it until the broker recovers
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+const recordExt = ".rec"
+
+// ErrTooLarge is returned when a record exceeds the size of queue
+var ErrTooLarge = errors.New("record exceeds the max size of queue")
+
+// Record is a message persisted in queue
+type Record struct {
+	Seq         uint64            `json:"-"`
+	Topic       string            `json:"topic"`
+	ContentType string            `json:"content_type"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body"`
+	Time        time.Time         `json:"time"`
+}
+
+type entry struct {
+	seq  uint64
+	size int64
+	time time.Time
+}
+
+// Queue keeps one file per record in its directory, named by the sequence,
+// so the records are replayed in order and survive restarts
+type Queue struct {
+	opts Options
+
+	sync.Mutex
+	// replaying is serialised, the records are removed once replayed
+	replay  sync.Mutex
+	entries []entry
+	size    int64
+	next    uint64
+	dropped uint64
+}
+
+// Open opens the queue in a directory, the records left by the last run are loaded
+func Open(dir string, opts ...Option) (*Queue, error) {
+	options := Options{
+		Dir: dir,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	q := &Queue{opts: options, next: 1}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *Queue) load() error {
+	files, err := ioutil.ReadDir(q.opts.Dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), recordExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), recordExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.entries = append(q.entries, entry{seq: seq, size: f.Size(), time: f.ModTime()})
+		q.size += f.Size()
+		if seq >= q.next {
+			q.next = seq + 1
+		}
+	}
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].seq < q.entries[j].seq })
+	q.trim()
+	return nil
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.opts.Dir, fmt.Sprintf("%020d%s", seq, recordExt))
+}
+
+// Push appends a record to the queue, the oldest records are
+// dropped when the queue exceeds its size
+func (q *Queue) Push(r *Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if q.opts.MaxSize > 0 && int64(len(b)) > q.opts.MaxSize {
+		return ErrTooLarge
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	seq := q.next
+	// write the record aside and rename it, so a crash never leaves a partial one
+	tmp := q.path(seq) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path(seq)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	r.Seq = seq
+	q.next++
+	q.entries = append(q.entries, entry{seq: seq, size: int64(len(b)), time: r.Time})
+	q.size += int64(len(b))
+	q.trim()
+	return nil
+}
+
+// trim drops the records too old or beyond the bounds of queue
+func (q *Queue) trim() {
+	var n int
+	for n < len(q.entries) {
+		e := q.entries[n]
+		switch {
+		case q.opts.MaxAge > 0 && time.Since(e.time) > q.opts.MaxAge:
+		case q.opts.MaxRecords > 0 && len(q.entries)-n > q.opts.MaxRecords:
+		case q.opts.MaxSize > 0 && q.size > q.opts.MaxSize:
+		default:
+			q.entries = q.entries[n:]
+			if n > 0 {
+				log.Warnf("queue %s dropped %d records", q.opts.Dir, n)
+			}
+			return
+		}
+		os.Remove(q.path(e.seq))
+		q.size -= e.size
+		q.dropped++
+		n++
+	}
+	q.entries = q.entries[:0]
+	if n > 0 {
+		log.Warnf("queue %s dropped %d records", q.opts.Dir, n)
+	}
+}
+
+// Replay hands the records over to fn in order, a record is removed once fn
+// succeeds, replaying stops at the first failure and the rest are kept
+func (q *Queue) Replay(fn func(*Record) error) (int, error) {
+	q.replay.Lock()
+	defer q.replay.Unlock()
+
+	var n int
+	for {
+		q.Lock()
+		q.trim()
+		if len(q.entries) == 0 {
+			q.Unlock()
+			return n, nil
+		}
+		e := q.entries[0]
+		q.Unlock()
+
+		r, err := q.read(e.seq)
+		if err != nil {
+			// a corrupted record can never be replayed
+			log.Errorf("queue %s dropped record %d: %v", q.opts.Dir, e.seq, err)
+		} else if err := fn(r); err != nil {
+			return n, err
+		} else {
+			n++
+		}
+
+		q.Lock()
+		if len(q.entries) > 0 && q.entries[0].seq == e.seq {
+			os.Remove(q.path(e.seq))
+			q.entries = q.entries[1:]
+			q.size -= e.size
+		}
+		q.Unlock()
+	}
+}
+
+func (q *Queue) read(seq uint64) (*Record, error) {
+	b, err := ioutil.ReadFile(q.path(seq))
+	if err != nil {
+		return nil, err
+	}
+	r := new(Record)
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	r.Seq = seq
+	return r, nil
+}
+
+// Depth returns the number of records in queue
+func (q *Queue) Depth() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.entries)
+}
+
+// Stats returns the depth, the size in bytes and the records dropped of queue
+func (q *Queue) Stats() Stats {
+	q.Lock()
+	defer q.Unlock()
+	return Stats{
+		Depth:   len(q.entries),
+		Size:    q.size,
+		Dropped: q.dropped,
+	}
+}
+
+// Stats of queue
+type Stats struct {
+	Depth   int    `json:"depth"`
+	Size    int64  `json:"size"`
+	Dropped uint64 `json:"dropped"`
+}
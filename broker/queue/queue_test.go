@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func tempQueue(t *testing.T, opts ...Option) (*Queue, string) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	q, err := Open(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q, dir
+}
+
+func push(t *testing.T, q *Queue, bodies ...string) {
+	for _, b := range bodies {
+		if err := q.Push(&Record{Topic: "devices", Body: []byte(b)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func replayed(t *testing.T, q *Queue) []string {
+	var bodies []string
+	if _, err := q.Replay(func(r *Record) error {
+		bodies = append(bodies, string(r.Body))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return bodies
+}
+
+func TestReplayInOrderAfterRestart(t *testing.T) {
+	q, dir := tempQueue(t)
+	push(t, q, "1", "2", "3")
+
+	// reopen the queue like a restarted process
+	q, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := q.Depth(); d != 3 {
+		t.Fatalf("Expected depth 3, got %d", d)
+	}
+	push(t, q, "4")
+
+	if b := fmt.Sprint(replayed(t, q)); b != "[1 2 3 4]" {
+		t.Fatalf("Expected records [1 2 3 4], got %s", b)
+	}
+	if d := q.Depth(); d != 0 {
+		t.Fatalf("Expected empty queue, got depth %d", d)
+	}
+}
+
+func TestReplayStopsAtFailure(t *testing.T) {
+	q, _ := tempQueue(t)
+	push(t, q, "1", "2", "3")
+
+	down := errors.New("broker down")
+	n, err := q.Replay(func(r *Record) error {
+		if string(r.Body) == "2" {
+			return down
+		}
+		return nil
+	})
+	if n != 1 || err != down {
+		t.Fatalf("Expected 1 replayed and %v, got %d and %v", down, n, err)
+	}
+	if b := fmt.Sprint(replayed(t, q)); b != "[2 3]" {
+		t.Fatalf("Expected records [2 3], got %s", b)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	q, _ := tempQueue(t, MaxRecords(2))
+	push(t, q, "1", "2", "3")
+	if b := fmt.Sprint(replayed(t, q)); b != "[2 3]" {
+		t.Fatalf("Expected records [2 3], got %s", b)
+	}
+	if s := q.Stats(); s.Dropped != 1 {
+		t.Fatalf("Expected 1 dropped, got %d", s.Dropped)
+	}
+
+	q, _ = tempQueue(t, MaxAge(time.Minute))
+	q.Push(&Record{Topic: "devices", Body: []byte("old"), Time: time.Now().Add(-time.Hour)})
+	push(t, q, "new")
+	if b := fmt.Sprint(replayed(t, q)); b != "[new]" {
+		t.Fatalf("Expected records [new], got %s", b)
+	}
+
+	q, _ = tempQueue(t, MaxSize(10))
+	if err := q.Push(&Record{Topic: "devices", Body: []byte("too large")}); err != ErrTooLarge {
+		t.Fatalf("Expected %v, got %v", ErrTooLarge, err)
+	}
+}
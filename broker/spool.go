@@ -0,0 +1,148 @@
+package eventbroker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/micro-community/x-edge/broker/queue"
+	"github.com/micro/go-micro/v2/client"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	log "github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/metadata"
+)
+
+// Spool keeps the messages failed to publish in a disk queue and
+// forwards them in order once the broker recovers
+type Spool struct {
+	queue *queue.Queue
+
+	sync.Mutex
+	// the client wrapped, messages are forwarded through it
+	client client.Client
+	exit   chan bool
+}
+
+type spoolClient struct {
+	client.Client
+	spool *Spool
+}
+
+// NewSpool creates a spool of the queue
+func NewSpool(q *queue.Queue) *Spool {
+	return &Spool{queue: q}
+}
+
+// Wrapper spools the publishes of a client
+func (s *Spool) Wrapper() client.Wrapper {
+	return func(c client.Client) client.Client {
+		s.Lock()
+		s.client = c
+		s.Unlock()
+		return &spoolClient{Client: c, spool: s}
+	}
+}
+
+// Publish keeps the order of messages, a message is queued behind
+// the spooled ones until they are forwarded
+func (c *spoolClient) Publish(ctx context.Context, msg client.Message, opts ...client.PublishOption) error {
+	if c.spool.queue.Depth() == 0 {
+		err := c.Client.Publish(ctx, msg, opts...)
+		if err == nil {
+			return nil
+		}
+		log.Warnf("spool message of %s: %v", msg.Topic(), err)
+	}
+	return c.spool.push(ctx, msg)
+}
+
+func (s *Spool) push(ctx context.Context, msg client.Message) error {
+	var body []byte
+	switch v := msg.Payload().(type) {
+	case *raw.Frame:
+		body = v.Data
+	default:
+		m, ok := Marshalers[msg.ContentType()]
+		if !ok {
+			return fmt.Errorf("unable to spool message of content type %s", msg.ContentType())
+		}
+		b, err := m.Marshal(v)
+		if err != nil {
+			return err
+		}
+		body = b
+	}
+
+	md, _ := metadata.FromContext(ctx)
+	return s.queue.Push(&queue.Record{
+		Topic:       msg.Topic(),
+		ContentType: msg.ContentType(),
+		Header:      md,
+		Body:        body,
+	})
+}
+
+// Flush forwards the spooled messages, it stops at the first failure
+func (s *Spool) Flush() (int, error) {
+	s.Lock()
+	c := s.client
+	s.Unlock()
+	if c == nil {
+		return 0, fmt.Errorf("spool wraps no client")
+	}
+
+	return s.queue.Replay(func(r *queue.Record) error {
+		ctx := metadata.NewContext(context.Background(), r.Header)
+		msg := c.NewMessage(r.Topic, &raw.Frame{Data: r.Body}, client.WithMessageContentType(r.ContentType))
+		return c.Publish(ctx, msg)
+	})
+}
+
+// Start forwards the spooled messages at every interval
+func (s *Spool) Start(interval time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	if s.exit != nil {
+		return
+	}
+	exit := make(chan bool)
+	s.exit = exit
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-exit:
+				return
+			case <-t.C:
+				if s.queue.Depth() == 0 {
+					continue
+				}
+				n, err := s.Flush()
+				if n > 0 {
+					log.Infof("spool forwarded %d messages", n)
+				}
+				if err != nil {
+					log.Warnf("spool forward: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop forwarding the spooled messages
+func (s *Spool) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.exit != nil {
+		close(s.exit)
+		s.exit = nil
+	}
+}
+
+// Stats of the spool queue, the depth is the number of messages not forwarded yet
+func (s *Spool) Stats() queue.Stats {
+	return s.queue.Stats()
+}
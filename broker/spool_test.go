@@ -0,0 +1,90 @@
+package eventbroker
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/micro-community/x-edge/broker/queue"
+	"github.com/micro-community/x-edge/proto/protocol"
+	"github.com/micro/go-micro/v2/broker"
+	"github.com/micro/go-micro/v2/broker/memory"
+	"github.com/micro/go-micro/v2/client"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	"github.com/micro/go-micro/v2/metadata"
+)
+
+// uplinkBroker fails to publish while the uplink is down
+type uplinkBroker struct {
+	broker.Broker
+	down bool
+}
+
+func (b *uplinkBroker) Publish(topic string, m *broker.Message, opts ...broker.PublishOption) error {
+	if b.down {
+		return errors.New("uplink down")
+	}
+	return b.Broker.Publish(topic, m, opts...)
+}
+
+func TestSpoolForwardsInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := queue.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool := NewSpool(q)
+
+	b := &uplinkBroker{Broker: memory.NewBroker(), down: true}
+	c := spool.Wrapper()(client.NewClient(client.Broker(b)))
+
+	ctx := metadata.NewContext(context.Background(), map[string]string{"x-edge-name": "meter-42"})
+	frame := c.NewMessage("devices", &raw.Frame{Data: []byte("<PROTOCOL/>")}, client.WithMessageContentType(RawContentType))
+	if err := c.Publish(ctx, frame); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Publish(ctx, c.NewMessage("devices", &protocol.Message{Name: "meter-42"})); err != nil {
+		t.Fatal(err)
+	}
+	if s := spool.Stats(); s.Depth != 2 {
+		t.Fatalf("Expected depth 2, got %d", s.Depth)
+	}
+
+	// the uplink recovers
+	b.down = false
+	msgs := make(chan *broker.Message, 4)
+	b.Subscribe("devices", func(e broker.Event) error {
+		msgs <- e.Message()
+		return nil
+	})
+
+	if n, err := spool.Flush(); n != 2 || err != nil {
+		t.Fatalf("Expected 2 forwarded, got %d and %v", n, err)
+	}
+
+	for i, ct := range []string{RawContentType, "application/protobuf"} {
+		select {
+		case m := <-msgs:
+			if m.Header["Content-Type"] != ct {
+				t.Fatalf("Expected message %d of %s, got %s", i, ct, m.Header["Content-Type"])
+			}
+			if m.Header["X-Edge-Name"] != "meter-42" {
+				t.Fatalf("Expected header of device, got %v", m.Header)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message was not forwarded")
+		}
+	}
+
+	if s := spool.Stats(); s.Depth != 0 {
+		t.Fatalf("Expected empty spool, got depth %d", s.Depth)
+	}
+}
@@ -45,11 +45,203 @@ type MicroSets struct {
 	RegisterInterval int    `toml:"microregisterinterval"`
 }
 
+//RoutingSets define the routing table of edge, it maps packet types
+//to handler endpoints "Service.Method" and to local topics
+type RoutingSets struct {
+	Routes   map[string]string   `toml:"routes"`
+	Fallback string              `toml:"fallback"`
+	Topics   map[string][]string `toml:"topics"`
+}
+
+//BridgeSets define a rule of bridge, it publishes the frames of a packet type
+//or of a handler endpoint to a broker topic such as "devices.{NAME}.{TYPE}"
+type BridgeSets struct {
+	Type        string `toml:"type"`
+	Endpoint    string `toml:"endpoint"`
+	Topic       string `toml:"topic"`
+	ContentType string `toml:"contenttype"`
+}
+
+//DownstreamSets define the broker topics of commands delivered to devices,
+//"{deviceId}" in topics is the id of a device connected
+type DownstreamSets struct {
+	Topic   string `toml:"topic"`
+	Receipt string `toml:"receipt"`
+}
+
+//SpoolSets define the disk queue of the messages failed to publish, they
+//are forwarded at every interval once the broker recovers
+type SpoolSets struct {
+	Dir        string `toml:"dir"`
+	MaxRecords int    `toml:"maxrecords"`
+	MaxSize    int64  `toml:"maxsize"`
+	MaxAge     string `toml:"maxage"`
+	Interval   string `toml:"interval"`
+}
+
+//ForwardSets define a packet type forwarded to the endpoint of a go-micro
+//service, the response is encoded back to the device as the reply frame
+type ForwardSets struct {
+	Type        string `toml:"type"`
+	Service     string `toml:"service"`
+	Endpoint    string `toml:"endpoint"`
+	Timeout     string `toml:"timeout"`
+	Retries     int    `toml:"retries"`
+	Fallback    string `toml:"fallback"`
+	ContentType string `toml:"contenttype"`
+}
+
+//RulesSets define the rule file evaluated before frames are dispatched,
+//it is reloaded at every interval once it changes
+type RulesSets struct {
+	File   string `toml:"file"`
+	Reload string `toml:"reload"`
+}
+
+//ACLSets define the policy file authorizing the frames of devices,
+//it is reloaded at every interval once it changes
+type ACLSets struct {
+	File   string `toml:"file"`
+	Reload string `toml:"reload"`
+}
+
+//CryptSets define the file of keys decrypting the payloads of devices,
+//it is reloaded at every interval once it changes, and the file the counters
+//of devices are saved to at every interval, so a restart accepts no replay
+type CryptSets struct {
+	File   string `toml:"file"`
+	Reload string `toml:"reload"`
+	State  string `toml:"state"`
+	Save   string `toml:"save"`
+}
+
+//LimitSets define the rate limits of devices, remote IPs and packet types,
+//the action of frames over them is drop, busy, delay or disconnect, and the
+//token buckets kept at most
+type LimitSets struct {
+	Action   string   `toml:"action"`
+	MaxDelay string   `toml:"maxdelay"`
+	Buckets  int      `toml:"buckets"`
+	Device   RateSets `toml:"device"`
+	Remote   RateSets `toml:"remote"`
+	// Types limit packet types across devices, "*" for the others
+	Types map[string]RateSets `toml:"types"`
+}
+
+//RateSets define a token bucket, the rate is the frames per second
+type RateSets struct {
+	Rate  float64 `toml:"rate"`
+	Burst int     `toml:"burst"`
+}
+
+//MetricsSets define the HTTP endpoint of metrics, the flag edge_web_address
+//overrides the address, no metrics are served without an address
+type MetricsSets struct {
+	Address string `toml:"address"`
+	Path    string `toml:"path"`
+}
+
+//AdminSets define the token of the admin API served with metrics under a prefix,
+//no admin API is served without a token
+type AdminSets struct {
+	Token  string `toml:"token"`
+	Prefix string `toml:"prefix"`
+}
+
+//DashboardSets define the frames kept by device for the dashboard served on
+//the web address, the devices whose frames are kept and the token of its API,
+//the token of admin API if empty, no dashboard is served without a token
+type DashboardSets struct {
+	Tail    int    `toml:"tail"`
+	Devices int    `toml:"devices"`
+	Token   string `toml:"token"`
+}
+
+//HealthSets define the HTTP paths of liveness and readiness served on the web
+//address, and the timeout of a check
+type HealthSets struct {
+	Live    string `toml:"live"`
+	Ready   string `toml:"ready"`
+	Timeout string `toml:"timeout"`
+}
+
+//TraceSets define the exporter of the spans of frames, log or memory
+//keeping the last size spans, no frames are traced without an exporter
+type TraceSets struct {
+	Exporter string `toml:"exporter"`
+	Size     int    `toml:"size"`
+}
+
+//ShadowSets define the store of device shadows, the fields map the keys
+//of reported state to the paths of frame elements such as "READING.VALUE"
+type ShadowSets struct {
+	Dir         string            `toml:"dir"`
+	Fields      map[string]string `toml:"fields"`
+	Types       []string          `toml:"types"`
+	CommandType string            `toml:"commandtype"`
+}
+
+//PresenceSets define the broker topic of presence events, "{event}" in
+//topic is accepted, identified or closed
+type PresenceSets struct {
+	Topic string `toml:"topic"`
+}
+
+//TLSSets define the certificate of edge and the CA bundle verifying the
+//client certificates of devices, mutual TLS is on once they are set
+type TLSSets struct {
+	Cert string `toml:"cert"`
+	Key  string `toml:"key"`
+	CA   string `toml:"ca"`
+}
+
+//DTLSSets define the DTLS of udp transport, by the certificate of edge and
+//the CA bundle verifying devices, or by the pre-shared keys of devices
+type DTLSSets struct {
+	Cert string `toml:"cert"`
+	Key  string `toml:"key"`
+	CA   string `toml:"ca"`
+	// Hint sent to devices to choose the identity of pre-shared key
+	Hint string `toml:"hint"`
+	// Keys pre-shared with devices by identity
+	Keys map[string]string `toml:"keys"`
+}
+
+//AuthSets define the authentication of devices, the method is token, hmac
+//or cert, the first frames of a session have to authenticate
+type AuthSets struct {
+	Method string `toml:"method"`
+	Frames int    `toml:"frames"`
+	// Field is the path of the element carrying token
+	Field string `toml:"field"`
+	// Secrets shared with devices by device id for hmac
+	Secrets map[string]string `toml:"secrets"`
+}
+
 //Config From files
 var (
-	DBConfig    Database
-	CacheConfig Cache
-	MicroConfig MicroSets
+	DBConfig         Database
+	CacheConfig      Cache
+	MicroConfig      MicroSets
+	RoutingConfig    RoutingSets
+	BridgeConfig     []BridgeSets
+	DownstreamConfig DownstreamSets
+	SpoolConfig      SpoolSets
+	ForwardConfig    []ForwardSets
+	RulesConfig      = RulesSets{File: "./rules.toml", Reload: "5s"}
+	ACLConfig        = ACLSets{File: "./acl.toml", Reload: "5s"}
+	CryptConfig      = CryptSets{File: "./keys.toml", Reload: "5s", State: "./counters.json", Save: "1s"}
+	ShadowConfig     ShadowSets
+	PresenceConfig   PresenceSets
+	AuthConfig       AuthSets
+	TLSConfig        TLSSets
+	LimitConfig      LimitSets
+	DTLSConfig       DTLSSets
+	MetricsConfig    = MetricsSets{Path: "/metrics"}
+	TraceConfig      TraceSets
+	AdminConfig      = AdminSets{Prefix: "/admin"}
+	DashboardConfig  = DashboardSets{Tail: 50, Devices: 1000}
+	HealthConfig     = HealthSets{Live: "/healthz", Ready: "/readyz", Timeout: "2s"}
 )
 
 func init() {
@@ -74,6 +266,101 @@ func init() {
 		fmt.Println(err)
 	}
 
+	// read the routing table of edge
+	if err := mconfig.Get("routing").Scan(&RoutingConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the bridge rules from device frames to broker topics
+	if err := mconfig.Get("bridge").Scan(&BridgeConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the topics of commands to devices
+	if err := mconfig.Get("downstream").Scan(&DownstreamConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the disk queue of messages failed to publish
+	if err := mconfig.Get("spool").Scan(&SpoolConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the packet types forwarded to go-micro services
+	if err := mconfig.Get("forward").Scan(&ForwardConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the rule file next to config.toml
+	if err := mconfig.Get("rules").Scan(&RulesConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the policy file next to config.toml
+	if err := mconfig.Get("acl").Scan(&ACLConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the store of device shadows
+	if err := mconfig.Get("shadow").Scan(&ShadowConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the topic of presence events
+	if err := mconfig.Get("presence").Scan(&PresenceConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the certificates of mutual TLS
+	if err := mconfig.Get("tls").Scan(&TLSConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the keys of payload encryption
+	if err := mconfig.Get("crypt").Scan(&CryptConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the rate limits of devices
+	if err := mconfig.Get("limit").Scan(&LimitConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the endpoint of metrics
+	if err := mconfig.Get("metrics").Scan(&MetricsConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the token of admin API
+	if err := mconfig.Get("admin").Scan(&AdminConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the frames kept by dashboard
+	if err := mconfig.Get("dashboard").Scan(&DashboardConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the paths of health
+	if err := mconfig.Get("health").Scan(&HealthConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the exporter of traces
+	if err := mconfig.Get("trace").Scan(&TraceConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the DTLS of udp transport
+	if err := mconfig.Get("dtls").Scan(&DTLSConfig); err != nil {
+		fmt.Println(err)
+	}
+
+	// read the authentication of devices
+	if err := mconfig.Get("auth").Scan(&AuthConfig); err != nil {
+		fmt.Println(err)
+	}
+
 	//	ServiceName = MicroConfig.ServeName
 
 }
@@ -0,0 +1,284 @@
+package dashboard
+
+// asset of dashboard compiled into the binary
+type asset struct {
+	contentType string
+	content     string
+}
+
+// assets of dashboard by name
+var assets = map[string]asset{
+	"index.html": {"text/html; charset=utf-8", indexHTML},
+	"style.css":  {"text/css; charset=utf-8", styleCSS},
+	"app.js":     {"application/javascript; charset=utf-8", appJS},
+}
+
+const indexHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>x-edge</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<header>
+  <h1>x-edge</h1>
+  <span id="updated"></span>
+</header>
+<main>
+  <section id="summary">
+    <div class="stat"><span id="connections">-</span><label>connections</label></div>
+    <div class="stat"><span id="devices">-</span><label>devices</label></div>
+    <div class="stat"><span id="transports">-</span><label>transports</label></div>
+  </section>
+  <section>
+    <h2>Connections</h2>
+    <table id="conns">
+      <thead><tr><th>id</th><th>transport</th><th>devices</th><th>frames in</th><th>frames out</th><th>bytes in</th><th>bytes out</th><th>connected</th></tr></thead>
+      <tbody></tbody>
+    </table>
+  </section>
+  <section>
+    <h2>Handlers</h2>
+    <table id="handlers">
+      <thead><tr><th>route</th><th>calls</th><th>errors</th><th>error rate</th><th>in flight</th></tr></thead>
+      <tbody></tbody>
+    </table>
+  </section>
+  <section>
+    <h2>Frames</h2>
+    <select id="device"><option value="">device</option></select>
+    <table id="frames">
+      <thead><tr><th>time</th><th>direction</th><th>type</th><th>frame</th><th>error</th></tr></thead>
+      <tbody></tbody>
+    </table>
+  </section>
+</main>
+<script src="app.js"></script>
+</body>
+</html>
+`
+
+const styleCSS = `body {
+  margin: 0;
+  font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif;
+  font-size: 14px;
+  color: #222;
+  background: #f6f7f9;
+}
+header {
+  display: flex;
+  align-items: baseline;
+  justify-content: space-between;
+  padding: 12px 24px;
+  color: #fff;
+  background: #24292e;
+}
+header h1 {
+  margin: 0;
+  font-size: 18px;
+}
+main {
+  padding: 12px 24px;
+}
+h2 {
+  font-size: 15px;
+  margin: 24px 0 8px;
+}
+#summary {
+  display: flex;
+  gap: 12px;
+}
+.stat {
+  flex: 1;
+  padding: 12px;
+  background: #fff;
+  border: 1px solid #e1e4e8;
+  border-radius: 4px;
+}
+.stat span {
+  display: block;
+  font-size: 24px;
+  font-weight: 600;
+}
+.stat label {
+  color: #586069;
+}
+table {
+  width: 100%;
+  border-collapse: collapse;
+  background: #fff;
+  border: 1px solid #e1e4e8;
+}
+th, td {
+  padding: 6px 8px;
+  text-align: left;
+  border-bottom: 1px solid #eaecef;
+  vertical-align: top;
+}
+th {
+  color: #586069;
+  font-weight: 600;
+  background: #fafbfc;
+}
+td.frame {
+  font-family: SFMono-Regular, Consolas, monospace;
+  white-space: pre-wrap;
+  word-break: break-all;
+}
+tr.error td, td.error {
+  color: #cb2431;
+}
+select {
+  margin-bottom: 8px;
+}
+`
+
+const appJS = `(function () {
+  "use strict";
+
+  var interval = 2000;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  // the token of API is given by #token={token} once, or asked for, and kept
+  // for the session
+  function token() {
+    var m = /(?:^#|&)token=([^&]*)/.exec(location.hash);
+    if (m) {
+      sessionStorage.setItem("token", decodeURIComponent(m[1]));
+      history.replaceState(null, "", location.pathname + location.search);
+    }
+    var t = sessionStorage.getItem("token");
+    if (!t) {
+      t = window.prompt("Token of the dashboard") || "";
+      sessionStorage.setItem("token", t);
+    }
+    return t;
+  }
+
+  var bearer = token();
+
+  function get(path) {
+    return fetch(path, {
+      cache: "no-store",
+      headers: { Authorization: "Bearer " + bearer }
+    }).then(function (rsp) {
+      if (rsp.status === 401) {
+        sessionStorage.removeItem("token");
+        throw new Error("unauthorized, reload to enter the token");
+      }
+      if (!rsp.ok) {
+        throw new Error(path + ": " + rsp.status);
+      }
+      return rsp.json();
+    });
+  }
+
+  function cell(tr, text, cls) {
+    var td = document.createElement("td");
+    td.textContent = text === undefined || text === null ? "" : String(text);
+    if (cls) {
+      td.className = cls;
+    }
+    tr.appendChild(td);
+  }
+
+  function rows(id, items, fn) {
+    var tbody = $(id).tBodies[0];
+    tbody.textContent = "";
+    items.forEach(function (item) {
+      var tr = document.createElement("tr");
+      fn(tr, item);
+      tbody.appendChild(tr);
+    });
+  }
+
+  function summary() {
+    return get("api/summary").then(function (s) {
+      $("connections").textContent = s.connections;
+      $("devices").textContent = s.devices;
+      $("transports").textContent = Object.keys(s.transports).map(function (t) {
+        return t + " " + s.transports[t];
+      }).join(", ") || "-";
+      rows("conns", s.conns || [], function (tr, c) {
+        cell(tr, c.id);
+        cell(tr, c.transport);
+        cell(tr, (c.devices || []).join(", "));
+        cell(tr, c.frames_in);
+        cell(tr, c.frames_out);
+        cell(tr, c.bytes_in);
+        cell(tr, c.bytes_out);
+        cell(tr, new Date(c.connected_at).toLocaleString());
+      });
+    });
+  }
+
+  function handlers() {
+    return get("api/handlers").then(function (hs) {
+      var methods = [];
+      hs.forEach(function (h) {
+        h.methods.forEach(function (m) {
+          methods.push({ route: h.name + "." + m.method, stats: m });
+        });
+      });
+      rows("handlers", methods, function (tr, m) {
+        if (m.stats.errors > 0) {
+          tr.className = "error";
+        }
+        cell(tr, m.route);
+        cell(tr, m.stats.calls);
+        cell(tr, m.stats.errors);
+        cell(tr, (m.stats.error_rate * 100).toFixed(1) + "%");
+        cell(tr, m.stats.in_flight);
+      });
+    });
+  }
+
+  function devices() {
+    return get("api/devices").then(function (ds) {
+      var select = $("device");
+      var selected = select.value;
+      while (select.options.length > 1) {
+        select.remove(1);
+      }
+      ds.forEach(function (d) {
+        select.add(new Option(d, d, false, d === selected));
+      });
+    });
+  }
+
+  function frames() {
+    var device = $("device").value;
+    if (!device) {
+      rows("frames", [], function () {});
+      return Promise.resolve();
+    }
+    return get("api/frames?device=" + encodeURIComponent(device)).then(function (fs) {
+      rows("frames", fs.reverse(), function (tr, f) {
+        cell(tr, new Date(f.time).toLocaleTimeString());
+        cell(tr, f.direction);
+        cell(tr, f.type);
+        cell(tr, f.frame + (f.truncated ? " …" : ""), "frame");
+        cell(tr, f.error, "error");
+      });
+    });
+  }
+
+  function refresh() {
+    Promise.all([summary(), handlers(), devices().then(frames)]).then(function () {
+      $("updated").textContent = "updated " + new Date().toLocaleTimeString();
+    }).catch(function (err) {
+      $("updated").textContent = err.message;
+    }).then(function () {
+      setTimeout(refresh, interval);
+    });
+  }
+
+  $("device").addEventListener("change", frames);
+  refresh();
+})();
+`
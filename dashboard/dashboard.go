@@ -0,0 +1,375 @@
+// Package dashboard serves the web dashboard of an edge node, the live
+// connections, the frames of devices, the handlers and their error rates,
+// the static assets are compiled into the binary, its API is authorized by a
+// bearer token
+package dashboard
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// Defaults of the frames logged
+var (
+	// DefaultTail is the frames kept by device
+	DefaultTail = 50
+	// DefaultDevices is the devices whose frames are kept, the device with the
+	// oldest frame is forgotten first
+	DefaultDevices = 1000
+	// DefaultFrameSize is the bytes of a frame kept, the rest is cut
+	DefaultFrameSize = 1024
+)
+
+// Options of dashboard
+type Options struct {
+	Tail      int
+	Devices   int
+	FrameSize int
+	// Token authorizes the requests of API, all of them are unauthorized
+	// if it is empty
+	Token string
+}
+
+// Option of dashboard
+type Option func(*Options)
+
+// Tail sets the frames kept by device
+func Tail(n int) Option {
+	return func(o *Options) {
+		o.Tail = n
+	}
+}
+
+// Devices sets the devices whose frames are kept
+func Devices(n int) Option {
+	return func(o *Options) {
+		o.Devices = n
+	}
+}
+
+// FrameSize sets the bytes of a frame kept
+func FrameSize(n int) Option {
+	return func(o *Options) {
+		o.FrameSize = n
+	}
+}
+
+// Token sets the bearer token authorizing the requests of API
+func Token(token string) Option {
+	return func(o *Options) {
+		o.Token = token
+	}
+}
+
+// Frame of the log of a device
+type Frame struct {
+	Time      time.Time         `json:"time"`
+	Direction nserver.Direction `json:"direction"`
+	Conn      string            `json:"conn"`
+	Type      string            `json:"type,omitempty"`
+	Frame     string            `json:"frame"`
+	Truncated bool              `json:"truncated,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// frameLog of a device, a ring of the last frames
+type frameLog struct {
+	frames []Frame
+	next   int
+	last   time.Time
+}
+
+func (l *frameLog) add(f Frame, tail int) {
+	l.last = f.Time
+	if len(l.frames) < tail {
+		l.frames = append(l.frames, f)
+		return
+	}
+	l.frames[l.next] = f
+	l.next = (l.next + 1) % tail
+}
+
+// tail returns the frames by the oldest first
+func (l *frameLog) tail() []Frame {
+	frames := make([]Frame, 0, len(l.frames))
+	frames = append(frames, l.frames[l.next:]...)
+	return append(frames, l.frames[:l.next]...)
+}
+
+// MethodStats of the calls of a handler method
+type MethodStats struct {
+	Method    string  `json:"method"`
+	Calls     uint64  `json:"calls"`
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	InFlight  int64   `json:"in_flight"`
+}
+
+// HandlerStats of a handler registered and its methods
+type HandlerStats struct {
+	Name    string        `json:"name"`
+	Methods []MethodStats `json:"methods"`
+}
+
+// Summary of the live connections
+type Summary struct {
+	Connections int                `json:"connections"`
+	Devices     int                `json:"devices"`
+	Transports  map[string]int     `json:"transports"`
+	Conns       []nserver.ConnInfo `json:"conns"`
+}
+
+// Dashboard of a node server, it is a nserver.Observer counting the calls
+// of handlers and a nserver.FrameHook logging the frames of devices
+type Dashboard struct {
+	admin nserver.Admin
+	opts  Options
+
+	mu     sync.Mutex
+	logs   map[string]*frameLog
+	calls  map[string]*MethodStats
+	assets map[string]asset
+}
+
+// New returns the dashboard of a node server
+func New(admin nserver.Admin, opts ...Option) *Dashboard {
+	options := Options{
+		Tail:      DefaultTail,
+		Devices:   DefaultDevices,
+		FrameSize: DefaultFrameSize,
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.Tail <= 0 {
+		options.Tail = DefaultTail
+	}
+	return &Dashboard{
+		admin:  admin,
+		opts:   options,
+		logs:   make(map[string]*frameLog),
+		calls:  make(map[string]*MethodStats),
+		assets: assets,
+	}
+}
+
+// Frame logs a frame of device, it is a nserver.FrameHook
+func (d *Dashboard) Frame(r nserver.FrameRecord) {
+	if len(r.Device) == 0 {
+		return
+	}
+	f := Frame{
+		Time:      r.Time,
+		Direction: r.Direction,
+		Conn:      r.Conn,
+		Type:      r.Type,
+		Error:     r.Error,
+	}
+	data := r.Frame
+	if d.opts.FrameSize > 0 && len(data) > d.opts.FrameSize {
+		data, f.Truncated = data[:d.opts.FrameSize], true
+	}
+	f.Frame = string(data)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l, ok := d.logs[r.Device]
+	if !ok {
+		if d.opts.Devices > 0 && len(d.logs) >= d.opts.Devices {
+			d.forget()
+		}
+		l = new(frameLog)
+		d.logs[r.Device] = l
+	}
+	l.add(f, d.opts.Tail)
+}
+
+// forget the device with the oldest frame, the caller must hold mu
+func (d *Dashboard) forget() {
+	var oldest string
+	var at time.Time
+	for device, l := range d.logs {
+		if len(oldest) == 0 || l.last.Before(at) {
+			oldest, at = device, l.last
+		}
+	}
+	delete(d.logs, oldest)
+}
+
+// Frames returns the frames logged of a device by the oldest first
+func (d *Dashboard) Frames(device string) []Frame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l, ok := d.logs[device]
+	if !ok {
+		return []Frame{}
+	}
+	return l.tail()
+}
+
+// Devices returns the devices whose frames are logged
+func (d *Dashboard) Devices() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	devices := make([]string, 0, len(d.logs))
+	for device := range d.logs {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// method returns the stats of a method, the caller must hold mu
+func (d *Dashboard) method(method string) *MethodStats {
+	m, ok := d.calls[method]
+	if !ok {
+		m = &MethodStats{Method: method}
+		d.calls[method] = m
+	}
+	return m
+}
+
+// FrameIn is not counted by dashboard
+func (d *Dashboard) FrameIn(bytes int) {}
+
+// FrameOut is not counted by dashboard
+func (d *Dashboard) FrameOut(bytes int) {}
+
+// ExtractError is not counted by dashboard
+func (d *Dashboard) ExtractError(err error) {}
+
+// DecodeError is not counted by dashboard
+func (d *Dashboard) DecodeError(err error) {}
+
+// RoutingMiss is not counted by dashboard
+func (d *Dashboard) RoutingMiss(packetType string) {}
+
+// HandlerStart of a handler method
+func (d *Dashboard) HandlerStart(method string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.method(method).InFlight++
+}
+
+// HandlerEnd of a handler method, counted as an error by err
+func (d *Dashboard) HandlerEnd(method string, took time.Duration, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := d.method(method)
+	m.InFlight--
+	m.Calls++
+	if err != nil {
+		m.Errors++
+	}
+}
+
+// ReplyError is not counted by dashboard
+func (d *Dashboard) ReplyError(method string, err error) {}
+
+// Handlers returns the handlers registered and the stats of their methods
+func (d *Dashboard) Handlers() []HandlerStats {
+	handlers := d.admin.Handlers()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := make([]HandlerStats, 0, len(handlers))
+	for _, h := range handlers {
+		hs := HandlerStats{Name: h.Name, Methods: make([]MethodStats, 0, len(h.Methods))}
+		for _, method := range h.Methods {
+			m := MethodStats{Method: method}
+			if c, ok := d.calls[h.Name+"."+method]; ok {
+				m = *c
+				m.Method = method
+			}
+			if m.Calls > 0 {
+				m.ErrorRate = float64(m.Errors) / float64(m.Calls)
+			}
+			hs.Methods = append(hs.Methods, m)
+		}
+		stats = append(stats, hs)
+	}
+	return stats
+}
+
+// Summary of the live connections
+func (d *Dashboard) Summary() Summary {
+	conns := d.admin.LiveConns()
+	s := Summary{
+		Connections: len(conns),
+		Transports:  make(map[string]int),
+		Conns:       conns,
+	}
+	for _, c := range conns {
+		s.Devices += len(c.Devices)
+		s.Transports[c.Transport]++
+	}
+	return s
+}
+
+// ServeHTTP serves the assets of dashboard and its API, the requests of API
+// without the token are unauthorized:
+//
+//	GET /api/summary                the live connections
+//	GET /api/devices                the devices whose frames are logged
+//	GET /api/frames?device={id}     the frames logged of a device
+//	GET /api/handlers               the handlers and the stats of their methods
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") && !d.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="edge"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/summary":
+		writeJSON(w, d.Summary())
+	case "/api/devices":
+		writeJSON(w, d.Devices())
+	case "/api/frames":
+		writeJSON(w, d.Frames(r.URL.Query().Get("device")))
+	case "/api/handlers":
+		writeJSON(w, d.Handlers())
+	default:
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if len(name) == 0 {
+			name = "index.html"
+		}
+		a, ok := d.assets[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", a.contentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(a.content))
+	}
+}
+
+func (d *Dashboard) authorized(r *http.Request) bool {
+	if len(d.opts.Token) == 0 {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(d.opts.Token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("unable to write dashboard response: %v", err)
+	}
+}
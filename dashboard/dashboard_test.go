@@ -0,0 +1,162 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+)
+
+// fakeAdmin has a live connection of meter-42
+type fakeAdmin struct{}
+
+func (fakeAdmin) LiveConns() []nserver.ConnInfo {
+	return []nserver.ConnInfo{
+		{ID: "127.0.0.1:8000-127.0.0.1:9000", Transport: "tcp", Devices: []string{"meter-42"}},
+		{ID: "127.0.0.1:8001-127.0.0.1:9001", Transport: "udp"},
+	}
+}
+
+func (fakeAdmin) LiveConn(id string) (nserver.ConnInfo, bool) { return nserver.ConnInfo{}, false }
+func (fakeAdmin) Disconnect(id string) error                  { return nserver.ErrNoConn }
+func (fakeAdmin) SendTo(id string, hdr map[string]string, msg interface{}) error {
+	return nserver.ErrNoConn
+}
+
+func (fakeAdmin) Handlers() []nserver.HandlerInfo {
+	return []nserver.HandlerInfo{{Name: "PROTOCOLSERVER", Methods: []string{"Event", "EventAck"}}}
+}
+
+const testToken = "secret"
+
+// get a path by the token
+func get(t *testing.T, srv *httptest.Server, path string, v interface{}) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(rsp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rsp
+}
+
+func TestFrames(t *testing.T) {
+	d := New(fakeAdmin{}, Tail(3), Devices(2), FrameSize(4))
+	at := time.Now()
+	for i := 0; i < 5; i++ {
+		d.Frame(nserver.FrameRecord{Time: at.Add(time.Duration(i)), Direction: nserver.Uplink, Device: "meter-42", Frame: []byte(fmt.Sprintf("<%d/>", i))})
+	}
+	frames := d.Frames("meter-42")
+	if len(frames) != 3 || frames[0].Frame != "<2/>" || frames[2].Frame != "<4/>" {
+		t.Fatalf("Expected the last 3 frames, got %+v", frames)
+	}
+
+	d.Frame(nserver.FrameRecord{Time: at.Add(10), Device: "meter-43", Frame: []byte("<PING/>")})
+	if f := d.Frames("meter-43"); len(f) != 1 || f[0].Frame != "<PIN" || !f[0].Truncated {
+		t.Fatalf("Expected frame truncated, got %+v", f)
+	}
+	// meter-42 has the oldest frame
+	d.Frame(nserver.FrameRecord{Time: at.Add(11), Device: "meter-44", Frame: []byte("<1/>")})
+	if devices := d.Devices(); strings.Join(devices, ",") != "meter-43,meter-44" {
+		t.Fatalf("Expected meter-42 forgotten, got %v", devices)
+	}
+	if f := d.Frames("meter-42"); len(f) != 0 {
+		t.Fatalf("Expected no frames, got %+v", f)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	d := New(fakeAdmin{}, Token(testToken))
+	for _, err := range []error{nil, nil, nil, errors.New("failed")} {
+		d.HandlerStart("PROTOCOLSERVER.Event")
+		d.HandlerEnd("PROTOCOLSERVER.Event", time.Millisecond, err)
+	}
+	d.HandlerStart("PROTOCOLSERVER.EventAck")
+	d.Frame(nserver.FrameRecord{Time: time.Now(), Direction: nserver.Uplink, Device: "meter-42", Type: "Event", Frame: []byte("<EVENT/>")})
+
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	var s Summary
+	get(t, srv, "/api/summary", &s)
+	if s.Connections != 2 || s.Devices != 1 || s.Transports["tcp"] != 1 || s.Transports["udp"] != 1 {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+
+	var handlers []HandlerStats
+	get(t, srv, "/api/handlers", &handlers)
+	if len(handlers) != 1 || len(handlers[0].Methods) != 2 {
+		t.Fatalf("Unexpected handlers %+v", handlers)
+	}
+	event, ack := handlers[0].Methods[0], handlers[0].Methods[1]
+	if event.Method != "Event" || event.Calls != 4 || event.Errors != 1 || event.ErrorRate != 0.25 || event.InFlight != 0 {
+		t.Fatalf("Unexpected stats of Event %+v", event)
+	}
+	if ack.Method != "EventAck" || ack.Calls != 0 || ack.InFlight != 1 {
+		t.Fatalf("Unexpected stats of EventAck %+v", ack)
+	}
+
+	var devices []string
+	get(t, srv, "/api/devices", &devices)
+	if len(devices) != 1 || devices[0] != "meter-42" {
+		t.Fatalf("Unexpected devices %v", devices)
+	}
+	var frames []Frame
+	get(t, srv, "/api/frames?device=meter-42", &frames)
+	if len(frames) != 1 || frames[0].Frame != "<EVENT/>" || frames[0].Type != "Event" {
+		t.Fatalf("Unexpected frames %+v", frames)
+	}
+
+	rsp := get(t, srv, "/", nil)
+	if rsp.StatusCode != http.StatusOK || !strings.HasPrefix(rsp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected index served, got %d %s", rsp.StatusCode, rsp.Header.Get("Content-Type"))
+	}
+	if rsp := get(t, srv, "/missing.js", nil); rsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected not found, got %d", rsp.StatusCode)
+	}
+	// the API is authorized by the token, the assets are not
+	for _, d := range []*Dashboard{d, New(fakeAdmin{})} {
+		srv := httptest.NewServer(d)
+		rsp, err := http.Get(srv.URL + "/api/summary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		srv.Close()
+		if rsp.StatusCode != http.StatusUnauthorized || len(rsp.Header.Get("WWW-Authenticate")) == 0 {
+			t.Fatalf("Expected unauthorized, got %d", rsp.StatusCode)
+		}
+	}
+	rsp, err := http.Get(srv.URL + "/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected asset served, got %d", rsp.StatusCode)
+	}
+
+	rsp, err = http.Post(srv.URL+"/api/summary", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected method not allowed, got %d", rsp.StatusCode)
+	}
+}
@@ -1,12 +1,42 @@
 package edge
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/micro-community/x-edge/admin"
+	eventbroker "github.com/micro-community/x-edge/broker"
+	"github.com/micro-community/x-edge/broker/queue"
+	config "github.com/micro-community/x-edge/cmd"
+	"github.com/micro-community/x-edge/dashboard"
 	nedge "github.com/micro-community/x-edge/edge"
+	"github.com/micro-community/x-edge/health"
+	"github.com/micro-community/x-edge/node/acl"
+	nauth "github.com/micro-community/x-edge/node/auth"
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	"github.com/micro-community/x-edge/node/codec/crypt"
+	"github.com/micro-community/x-edge/node/limit"
+	"github.com/micro-community/x-edge/node/metrics"
+	nrouter "github.com/micro-community/x-edge/node/router"
+	"github.com/micro-community/x-edge/node/rules"
+	nserver "github.com/micro-community/x-edge/node/server"
+	ntrace "github.com/micro-community/x-edge/node/trace"
+	"github.com/micro-community/x-edge/node/transport/udp"
+	"github.com/micro-community/x-edge/shadow"
 	"github.com/micro/cli/v2"
 	"github.com/micro/go-micro/v2"
+	"github.com/micro/go-micro/v2/client"
 	log "github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/server"
+	"github.com/micro/go-micro/v2/transport"
+	"github.com/micro/go-micro/v2/util/wrapper"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 //Edge config locate in x-edge/cmd
@@ -24,6 +54,27 @@ type Service interface {
 //edgeApp for edge process
 type edgeApp struct {
 	opts Options
+	// spool of the messages failed to publish
+	spool *eventbroker.Spool
+	// rules evaluated before frames are dispatched
+	rules *rules.Engine
+	// policy authorizing the frames of devices
+	acl *acl.Engine
+	// keys decrypting the payloads of devices, and the counters of them
+	keys  *crypt.FileKeys
+	crypt *crypt.Crypt
+	// limiter throttling the frames of devices
+	limiter *limit.Limiter
+	// presence publishing the devices coming and going
+	presence *eventbroker.Presence
+	// web serving the dashboard, metrics and admin API of edge
+	web *http.Server
+	// state of the web listener checked by readiness
+	webState *health.State
+	// checks of liveness and readiness
+	health *health.Checker
+	// tracer of the frames of devices and the calls upstream
+	tracer *ntrace.Tracer
 }
 
 //NewService return a edge service application
@@ -94,8 +145,28 @@ func (e *edgeApp) Init(opts ...Option) error {
 		edgeOptions = append(edgeOptions, nedge.WithExtractor(nedge.DefaultExtractor))
 	}
 
+	// verify the client certificates of devices
+	if tc := config.TLSConfig; len(tc.Cert) > 0 && len(tc.Key) > 0 && len(tc.CA) > 0 {
+		edgeOptions = append(edgeOptions, nedge.WithMutualTLS(tc.Cert, tc.Key, tc.CA))
+	}
+	// secure the datagrams of devices by DTLS
+	if opts := dtlsOptions(config.DTLSConfig); len(opts) > 0 {
+		edgeOptions = append(edgeOptions, nedge.WithDTLS(opts...))
+	}
+
 	e.opts.Edge.Init(edgeOptions...)
 
+	// route packet types to handlers as configured
+	if len(config.RoutingConfig.Routes) > 0 {
+		e.opts.Edge.Server().Init(nserver.Routes(config.RoutingConfig.Routes))
+	}
+	if len(config.RoutingConfig.Fallback) > 0 {
+		e.opts.Edge.Server().Init(nserver.Fallback(config.RoutingConfig.Fallback))
+	}
+	if len(config.RoutingConfig.Topics) > 0 {
+		e.opts.Edge.Server().Init(nserver.Topics(config.RoutingConfig.Topics))
+	}
+
 	serviceOpts = append(serviceOpts, micro.Action(func(ctx *cli.Context) error {
 		// execute edge service Action
 		if e.opts.Edge.Options().Action != nil {
@@ -110,9 +181,438 @@ func (e *edgeApp) Init(opts ...Option) error {
 
 	e.opts.MicroService.Init(serviceOpts...)
 
+	// trace the frames of devices through the calls and publications upstream
+	if len(config.TraceConfig.Exporter) > 0 {
+		if err := e.initTrace(); err != nil {
+			log.Errorf("unable to trace frames: %v", err)
+		}
+	}
+
+	// keep the messages failed to publish until the broker recovers
+	if len(config.SpoolConfig.Dir) > 0 {
+		if err := e.initSpool(); err != nil {
+			log.Errorf("unable to open spool %s: %v", config.SpoolConfig.Dir, err)
+		}
+	}
+
+	// bridge the device frames to broker topics through the client of micro service,
+	// the events are published to the topic of event subscribers unless configured
+	bridgeRules := []eventbroker.BridgeRule{nrouter.EventRule()}
+	if len(config.BridgeConfig) > 0 {
+		bridgeRules = make([]eventbroker.BridgeRule, 0, len(config.BridgeConfig))
+		for _, r := range config.BridgeConfig {
+			bridgeRules = append(bridgeRules, eventbroker.BridgeRule{
+				Type:        r.Type,
+				Endpoint:    r.Endpoint,
+				Topic:       r.Topic,
+				ContentType: r.ContentType,
+			})
+		}
+	}
+	bridge := eventbroker.NewBridge(e.opts.MicroService.Client(), bridgeRules...)
+	e.opts.Edge.Server().Init(server.WrapHandler(bridge.HandlerWrapper()))
+
+	// forward packet types to go-micro services without handler code
+	if len(config.ForwardConfig) > 0 {
+		if err := e.initForwarder(); err != nil {
+			log.Errorf("unable to forward packet types: %v", err)
+		}
+	}
+
+	// evaluate the rules of frames before dispatching them
+	if _, err := os.Stat(config.RulesConfig.File); err == nil {
+		if e.rules, err = rules.Load(config.RulesConfig.File); err != nil {
+			log.Errorf("unable to load rules: %v", err)
+		} else {
+			e.opts.Edge.Server().Init(nserver.FilterFrames(e.rules.Filter))
+		}
+	}
+
+	// authorize the packet types of devices and the commands to them
+	if _, err := os.Stat(config.ACLConfig.File); err == nil {
+		if e.acl, err = acl.Load(config.ACLConfig.File); err != nil {
+			log.Errorf("unable to load policy: %v", err)
+		} else {
+			e.opts.Edge.Server().Init(nserver.Authorize(e.acl.Authorize))
+		}
+	}
+
+	// decrypt the payloads of devices unable to do TLS by their keys
+	if _, err := os.Stat(config.CryptConfig.File); err == nil {
+		if e.keys, err = crypt.LoadKeys(config.CryptConfig.File); err != nil {
+			log.Errorf("unable to load keys: %v", err)
+		} else {
+			e.crypt = crypt.New(e.keys)
+			if len(config.CryptConfig.State) > 0 {
+				if err := e.crypt.Persist(config.CryptConfig.State); err != nil {
+					log.Errorf("unable to load counters: %v", err)
+				}
+			}
+			e.opts.Edge.Server().Init(server.Codec(xmlc.DefaultContentType, e.crypt.Wrap(xmlc.NewCodec)))
+		}
+	}
+
+	// throttle the devices over the rate limits
+	if lc := config.LimitConfig; lc.Device.Rate > 0 || lc.Remote.Rate > 0 || len(lc.Types) > 0 {
+		if err := e.initLimit(); err != nil {
+			log.Errorf("unable to limit rate: %v", err)
+		}
+	}
+
+	// check the listeners, the registration and the broker of events for readiness
+	if err := e.initHealth(); err != nil {
+		log.Errorf("unable to serve health: %v", err)
+	}
+
+	// serve the dashboard, health and metrics of the node server, transport and router,
+	// and the admin API of its connections besides once a token is set
+	if addr := e.webAddress(); len(addr) > 0 {
+		mux := http.NewServeMux()
+		e.webState = health.NewState()
+		e.health.Ready("web", e.webState.Check)
+		if path := config.HealthConfig.Live; len(path) > 0 {
+			mux.Handle(path, e.health.LiveHandler())
+		}
+		if path := config.HealthConfig.Ready; len(path) > 0 {
+			mux.Handle(path, e.health.ReadyHandler())
+		}
+		if len(e.dashboardToken()) > 0 {
+			if err := e.initDashboard(mux); err != nil {
+				log.Errorf("unable to serve dashboard: %v", err)
+			}
+		}
+		if err := e.initMetrics(mux); err != nil {
+			log.Errorf("unable to serve metrics: %v", err)
+		}
+		if len(config.AdminConfig.Token) > 0 {
+			if err := e.initAdmin(mux); err != nil {
+				log.Errorf("unable to serve admin API: %v", err)
+			}
+		}
+		e.web = &http.Server{Addr: addr, Handler: mux}
+	}
+
+	// keep the shadows of devices queryable through the micro service
+	if len(config.ShadowConfig.Dir) > 0 {
+		if err := e.initShadow(); err != nil {
+			log.Errorf("unable to open shadows %s: %v", config.ShadowConfig.Dir, err)
+		}
+	}
+
+	// deliver the commands of broker topics to the devices connected
+	if len(config.DownstreamConfig.Topic) > 0 {
+		if srv, ok := e.opts.Edge.Server().(nserver.Server); ok {
+			downstream := eventbroker.NewDownstream(e.opts.MicroService.Client(), srv,
+				config.DownstreamConfig.Topic, config.DownstreamConfig.Receipt)
+			srv.Init(nserver.WatchDevices(downstream.Watch))
+		} else {
+			log.Warnf("edge server %s cannot deliver commands to devices", e.opts.Edge.Server())
+		}
+	}
+
+	// authenticate the devices before their frames are dispatched
+	if len(config.AuthConfig.Method) > 0 {
+		if err := e.initAuth(); err != nil {
+			log.Errorf("unable to authenticate devices: %v", err)
+		}
+	}
+
+	// publish the devices coming and going for fleet online status
+	if len(config.PresenceConfig.Topic) > 0 {
+		sopts := e.opts.MicroService.Server().Options()
+		e.presence = eventbroker.NewPresence(e.opts.MicroService.Client(),
+			config.PresenceConfig.Topic, sopts.Name+"-"+sopts.Id)
+		e.opts.Edge.Server().Init(nserver.WatchPresence(e.presence.Publish))
+	}
+
+	return nil
+}
+
+func (e *edgeApp) initSpool() error {
+	var opts []queue.Option
+	if config.SpoolConfig.MaxRecords > 0 {
+		opts = append(opts, queue.MaxRecords(config.SpoolConfig.MaxRecords))
+	}
+	if config.SpoolConfig.MaxSize > 0 {
+		opts = append(opts, queue.MaxSize(config.SpoolConfig.MaxSize))
+	}
+	if len(config.SpoolConfig.MaxAge) > 0 {
+		age, err := time.ParseDuration(config.SpoolConfig.MaxAge)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, queue.MaxAge(age))
+	}
+
+	q, err := queue.Open(config.SpoolConfig.Dir, opts...)
+	if err != nil {
+		return err
+	}
+	e.spool = eventbroker.NewSpool(q)
+	// publishers and bridges created from now on publish through the spool
+	e.opts.MicroService.Init(micro.WrapClient(e.spool.Wrapper()))
+	return nil
+}
+
+func (e *edgeApp) initForwarder() error {
+	forwards := make([]nrouter.Forward, 0, len(config.ForwardConfig))
+	for _, f := range config.ForwardConfig {
+		fw := nrouter.Forward{
+			Type:        f.Type,
+			Service:     f.Service,
+			Endpoint:    f.Endpoint,
+			Retries:     f.Retries,
+			Fallback:    []byte(f.Fallback),
+			ContentType: f.ContentType,
+		}
+		if len(f.Timeout) > 0 {
+			timeout, err := time.ParseDuration(f.Timeout)
+			if err != nil {
+				return err
+			}
+			fw.Timeout = timeout
+		}
+		forwards = append(forwards, fw)
+	}
+
+	fwd := nrouter.NewForwarder(e.opts.MicroService.Client(), forwards...)
+	srv := e.opts.Edge.Server()
+	if err := srv.Handle(srv.NewHandler(fwd)); err != nil {
+		return err
+	}
+	return srv.Init(nserver.Routes(fwd.Routes()))
+}
+
+func (e *edgeApp) initShadow() error {
+	srv, ok := e.opts.Edge.Server().(nserver.Server)
+	if !ok {
+		return fmt.Errorf("edge server %s cannot deliver to devices", e.opts.Edge.Server())
+	}
+	store, err := shadow.Open(config.ShadowConfig.Dir)
+	if err != nil {
+		return err
+	}
+
+	m := shadow.NewManager(store, srv, shadow.Options{
+		Fields:      config.ShadowConfig.Fields,
+		Types:       config.ShadowConfig.Types,
+		CommandType: config.ShadowConfig.CommandType,
+	})
+	srv.Init(server.WrapHandler(m.HandlerWrapper()), nserver.WatchDevices(m.Watch))
+	return micro.RegisterHandler(e.opts.MicroService.Server(), shadow.NewHandler(m))
+}
+
+func (e *edgeApp) initAuth() error {
+	var authenticator nserver.Authenticator
+	switch config.AuthConfig.Method {
+	case "token":
+		a := e.opts.Edge.Options().Auth
+		if a == nil {
+			a = e.opts.MicroService.Options().Auth
+		}
+		field := config.AuthConfig.Field
+		if len(field) == 0 {
+			field = "TOKEN"
+		}
+		authenticator = nauth.Token(a, field)
+	case "hmac":
+		secrets := config.AuthConfig.Secrets
+		authenticator = nauth.HMAC(func(device string) ([]byte, error) {
+			secret, ok := secrets[device]
+			if !ok {
+				return nil, fmt.Errorf("no secret of %s", device)
+			}
+			return []byte(secret), nil
+		})
+	case "cert":
+		authenticator = nauth.Cert()
+	default:
+		return fmt.Errorf("unknown method %q", config.AuthConfig.Method)
+	}
+	return e.opts.Edge.Server().Init(nserver.Authenticate(authenticator, config.AuthConfig.Frames))
+}
+
+func (e *edgeApp) initLimit() error {
+	lc := config.LimitConfig
+	c := limit.Config{
+		Action:     nserver.LimitAction(lc.Action),
+		MaxBuckets: lc.Buckets,
+		Device:     limit.Rate{PerSecond: lc.Device.Rate, Burst: lc.Device.Burst},
+		Remote:     limit.Rate{PerSecond: lc.Remote.Rate, Burst: lc.Remote.Burst},
+		Types:      make(map[string]limit.Rate, len(lc.Types)),
+	}
+	if len(lc.MaxDelay) > 0 {
+		d, err := time.ParseDuration(lc.MaxDelay)
+		if err != nil {
+			return err
+		}
+		c.MaxDelay = d
+	}
+	for t, r := range lc.Types {
+		c.Types[t] = limit.Rate{PerSecond: r.Rate, Burst: r.Burst}
+	}
+
+	l, err := limit.New(c)
+	if err != nil {
+		return err
+	}
+	e.limiter = l
+	return e.opts.Edge.Server().Init(nserver.RateLimit(l.Limit))
+}
+
+func (e *edgeApp) initTrace() error {
+	var exporter ntrace.Exporter
+	switch config.TraceConfig.Exporter {
+	case "log":
+		exporter = ntrace.Log()
+	case "memory":
+		exporter = ntrace.NewMemory(config.TraceConfig.Size)
+	default:
+		return fmt.Errorf("unknown exporter %q", config.TraceConfig.Exporter)
+	}
+	e.tracer = ntrace.NewTracer(exporter)
+
+	// the calls of handlers upstream are spans of the frames
+	name := e.opts.MicroService.Server().Options().Name
+	e.opts.MicroService.Init(micro.WrapClient(func(c client.Client) client.Client {
+		return wrapper.TraceCall(name, e.tracer, c)
+	}))
+	return e.opts.Edge.Server().Init(server.Tracer(e.tracer))
+}
+
+//webAddress of dashboard, metrics and admin API, the flag edge_web_address overrides the config
+func (e *edgeApp) webAddress() string {
+	if addr := e.opts.Edge.Options().WebAddress; len(addr) > 0 {
+		return addr
+	}
+	return config.MetricsConfig.Address
+}
+
+func (e *edgeApp) initHealth() error {
+	e.health = health.DefaultChecker
+	if d, err := time.ParseDuration(config.HealthConfig.Timeout); err == nil && d > 0 {
+		e.health.Init(health.Timeout(d))
+	}
+
+	srv, ok := e.opts.Edge.Server().(interface {
+		Listening() error
+		Accepting() error
+	})
+	if !ok {
+		return fmt.Errorf("edge server %s cannot tell its listener", e.opts.Edge.Server())
+	}
+	e.health.Live("accept", func(context.Context) error { return srv.Accepting() })
+	e.health.Ready("listener", func(context.Context) error { return srv.Listening() })
+	e.health.Ready("registry", e.registered)
+	e.health.Ready("broker", func(ctx context.Context) error {
+		return eventbroker.CheckBroker(ctx, e.opts.MicroService.Client().Options().Broker)
+	})
+	return micro.RegisterHandler(e.opts.MicroService.Server(), health.NewHandler(e.health))
+}
+
+//registered tells whether the micro service is a node of its registry
+func (e *edgeApp) registered(ctx context.Context) error {
+	opts := e.opts.MicroService.Server().Options()
+	services, err := opts.Registry.GetService(opts.Name)
+	if err != nil {
+		return err
+	}
+	id := opts.Name + "-" + opts.Id
+	for _, s := range services {
+		for _, n := range s.Nodes {
+			if n.Id == id {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("service %s is not registered as %s", opts.Name, id)
+}
+
+func (e *edgeApp) initDashboard(mux *http.ServeMux) error {
+	srv, ok := e.opts.Edge.Server().(nserver.Admin)
+	if !ok {
+		return fmt.Errorf("edge server %s has no admin", e.opts.Edge.Server())
+	}
+	dc := config.DashboardConfig
+	d := dashboard.New(srv, dashboard.Tail(dc.Tail), dashboard.Devices(dc.Devices), dashboard.Token(e.dashboardToken()))
+	if err := e.opts.Edge.Server().Init(nserver.Observe(d), nserver.WatchFrames(d.Frame)); err != nil {
+		return err
+	}
+	mux.Handle("/", d)
 	return nil
 }
 
+//dashboardToken is the token of admin API unless the dashboard has its own
+func (e *edgeApp) dashboardToken() string {
+	if token := config.DashboardConfig.Token; len(token) > 0 {
+		return token
+	}
+	return config.AdminConfig.Token
+}
+
+func (e *edgeApp) initMetrics(mux *http.ServeMux) error {
+	reg := prometheus.NewRegistry()
+	m := metrics.New(e.opts.Edge.Options().Transport.String())
+	collectors := []prometheus.Collector{
+		m,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	}
+	if e.limiter != nil {
+		collectors = append(collectors, metrics.Limiter(e.limiter))
+	}
+	if e.spool != nil {
+		collectors = append(collectors, metrics.Spool(e.spool))
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	if err := e.opts.Edge.Server().Init(nserver.Observe(m), nserver.WatchPresence(m.Presence)); err != nil {
+		return err
+	}
+
+	path := config.MetricsConfig.Path
+	if len(path) == 0 {
+		path = "/metrics"
+	}
+	mux.Handle(path, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	return nil
+}
+
+func (e *edgeApp) initAdmin(mux *http.ServeMux) error {
+	srv, ok := e.opts.Edge.Server().(nserver.Admin)
+	if !ok {
+		return fmt.Errorf("edge server %s has no admin", e.opts.Edge.Server())
+	}
+	prefix := strings.TrimSuffix(config.AdminConfig.Prefix, "/")
+	if len(prefix) == 0 {
+		prefix = "/admin"
+	}
+	mux.Handle(prefix+"/", admin.NewHandler(srv, config.AdminConfig.Token, prefix))
+	return nil
+}
+
+//dtlsOptions of the udp transport, none for plaintext
+func dtlsOptions(dc config.DTLSSets) []transport.Option {
+	var opts []transport.Option
+	if len(dc.Cert) > 0 && len(dc.Key) > 0 {
+		opts = append(opts, udp.DTLSCertificate(dc.Cert, dc.Key, dc.CA))
+	}
+	if len(dc.Keys) > 0 {
+		keys := dc.Keys
+		opts = append(opts, udp.DTLSPSK(dc.Hint, func(identity string) ([]byte, error) {
+			key, ok := keys[identity]
+			if !ok {
+				return nil, fmt.Errorf("no pre-shared key of %s", identity)
+			}
+			return []byte(key), nil
+		}))
+	}
+	return opts
+}
+
 func (e *edgeApp) start() error {
 
 	return nil
@@ -132,6 +632,77 @@ func (e *edgeApp) Run() error {
 		log.Fatal(err)
 	}
 
+	// the presence of the devices closed by the edge stopped is published last
+	if e.presence != nil {
+		defer e.presence.Stop()
+	}
+
+	if e.spool != nil {
+		interval := 10 * time.Second
+		if d, err := time.ParseDuration(config.SpoolConfig.Interval); err == nil && d > 0 {
+			interval = d
+		}
+		e.spool.Start(interval)
+		defer e.spool.Stop()
+	}
+
+	if e.rules != nil {
+		interval := 5 * time.Second
+		if d, err := time.ParseDuration(config.RulesConfig.Reload); err == nil && d > 0 {
+			interval = d
+		}
+		e.rules.Watch(interval)
+		defer e.rules.Stop()
+	}
+
+	if e.acl != nil {
+		interval := 5 * time.Second
+		if d, err := time.ParseDuration(config.ACLConfig.Reload); err == nil && d > 0 {
+			interval = d
+		}
+		e.acl.Watch(interval)
+		defer e.acl.Stop()
+	}
+
+	if e.keys != nil {
+		interval := 5 * time.Second
+		if d, err := time.ParseDuration(config.CryptConfig.Reload); err == nil && d > 0 {
+			interval = d
+		}
+		e.keys.Watch(interval)
+		defer e.keys.Stop()
+
+		interval = time.Second
+		if d, err := time.ParseDuration(config.CryptConfig.Save); err == nil && d > 0 {
+			interval = d
+		}
+		e.crypt.Start(interval)
+		defer func() {
+			if err := e.crypt.Stop(); err != nil {
+				log.Errorf("unable to save counters: %v", err)
+			}
+		}()
+	}
+
+	if e.web != nil {
+		l, err := net.Listen("tcp", e.web.Addr)
+		if err != nil {
+			log.Errorf("unable to serve web: %v", err)
+			e.webState.Set(err)
+		} else {
+			log.Infof("Web listening on %s", l.Addr())
+			e.webState.Set(nil)
+			go func() {
+				err := e.web.Serve(l)
+				if err != http.ErrServerClosed {
+					log.Errorf("unable to serve web: %v", err)
+				}
+				e.webState.Set(err)
+			}()
+			defer e.web.Shutdown(context.Background())
+		}
+	}
+
 	// Run go-micro servier
 	if err := e.opts.MicroService.Run(); err != nil {
 		log.Fatal(err)
@@ -22,6 +22,8 @@ type Options struct {
 	Metadata  map[string]string
 	Address   string
 	Advertise string
+	//WebAddress serves the dashboard, metrics and admin API of edge over HTTP, such as 0.0.0.0:8082
+	WebAddress string
 
 	Auth      auth.Auth
 	Client    client.Client
@@ -107,6 +109,13 @@ func Address(a string) Option {
 	}
 }
 
+//WebAddress to serve the dashboard, metrics and admin API of edge over HTTP - host:port
+func WebAddress(a string) Option {
+	return func(o *Options) {
+		o.WebAddress = a
+	}
+}
+
 // Host to bind to - host:port
 func Host(a string) Option {
 	return func(o *Options) {
@@ -137,6 +146,13 @@ func Context(ctx context.Context) Option {
 // 	}
 // }
 
+// Auth sets the auth of the tokens devices authenticate by
+func Auth(a auth.Auth) Option {
+	return func(o *Options) {
+		o.Auth = a
+	}
+}
+
 // Server to use a customer Server
 func Server(srv server.Server) Option {
 	return func(o *Options) {
@@ -218,6 +234,22 @@ func WithExtractor(de nts.DataExtractor) Option {
 	}
 }
 
+//WithMutualTLS requires the client certificates of devices verified by the CA
+//bundle, the files are reloaded from disk once they change
+func WithMutualTLS(certFile, keyFile, caFile string) Option {
+	return func(o *Options) {
+		o.Transport.Init(nts.MutualTLS(certFile, keyFile, caFile))
+	}
+}
+
+//WithDTLS secures the udp transport of devices by the options of DTLS,
+//such as udp.DTLSCertificate and udp.DTLSPSK, plaintext is kept without
+func WithDTLS(opts ...transport.Option) Option {
+	return func(o *Options) {
+		o.Transport.Init(opts...)
+	}
+}
+
 // Transport sets the transport for the server
 // and the underlying components
 func Transport(t transport.Transport) Option {
@@ -63,8 +63,7 @@ func (s *service) Init(opts ...Option) error {
 	s.opts.Action = func(ctx *cli.Context) {
 
 		if len(ctx.String("edge_web_address")) > 0 {
-			s.opts.Address = ctx.String("edge_address")
-			//clientOpts = append(clientOpts, client.WithAddress(ctx.String("edge_address")))
+			s.opts.WebAddress = ctx.String("edge_web_address")
 		}
 		if len(ctx.String("edge_host")) > 0 {
 			s.opts.Host = ctx.String("edge_host")
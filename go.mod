@@ -9,5 +9,7 @@ require (
 	github.com/micro/cli/v2 v2.1.2
 	github.com/micro/go-micro/v2 v2.8.0
 	github.com/micro/micro/v2 v2.8.0
-
+	github.com/pion/dtls/v2 v2.0.9
+	github.com/pion/udp v0.1.4
+	github.com/prometheus/client_golang v1.7.1
 )
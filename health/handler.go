@@ -0,0 +1,31 @@
+package health
+
+import (
+	"context"
+)
+
+// CheckRequest of a report
+type CheckRequest struct{}
+
+// Health is the go-micro handler of the reports, "Health.Live" and
+// "Health.Ready", a failing report is no error of the call
+type Health struct {
+	checker *Checker
+}
+
+// NewHandler returns the go-micro handler of a checker
+func NewHandler(c *Checker) *Health {
+	return &Health{checker: c}
+}
+
+// Live returns the report of liveness
+func (h *Health) Live(ctx context.Context, req *CheckRequest, rsp *Report) error {
+	*rsp = h.checker.Liveness(ctx)
+	return nil
+}
+
+// Ready returns the report of readiness
+func (h *Health) Ready(ctx context.Context, req *CheckRequest, rsp *Report) error {
+	*rsp = h.checker.Readiness(ctx)
+	return nil
+}
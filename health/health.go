@@ -0,0 +1,268 @@
+// Package health checks whether an edge node is alive and ready to serve,
+// components register their named checks and the reports are served over
+// HTTP and by a go-micro handler
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// DefaultTimeout of a check
+var DefaultTimeout = 2 * time.Second
+
+// DefaultChecker the components register their checks to
+var DefaultChecker = NewChecker()
+
+// Check of a component, nil once it is healthy
+type Check func(ctx context.Context) error
+
+// Status of a check or a report
+type Status string
+
+const (
+	// StatusOK of the checks passed
+	StatusOK Status = "ok"
+	// StatusFailing of the checks failed or timed out
+	StatusFailing Status = "failing"
+)
+
+// Result of a check
+type Result struct {
+	Name     string        `json:"name"`
+	Status   Status        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report of the checks, failing once any of them fails
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// OK tells whether the checks passed
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Options of checker
+type Options struct {
+	// Timeout of a check, it fails once the timeout is over
+	Timeout time.Duration
+}
+
+// Option of checker
+type Option func(*Options)
+
+// Timeout sets the timeout of a check
+func Timeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = d
+	}
+}
+
+// Checker runs the checks of liveness and readiness registered by name
+type Checker struct {
+	opts Options
+
+	mu    sync.RWMutex
+	live  map[string]Check
+	ready map[string]Check
+}
+
+// NewChecker returns a checker without any check, alive and ready
+func NewChecker(opts ...Option) *Checker {
+	options := Options{Timeout: DefaultTimeout}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Checker{
+		opts:  options,
+		live:  make(map[string]Check),
+		ready: make(map[string]Check),
+	}
+}
+
+// Init the options of checker
+func (c *Checker) Init(opts ...Option) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, o := range opts {
+		o(&c.opts)
+	}
+	if c.opts.Timeout <= 0 {
+		c.opts.Timeout = DefaultTimeout
+	}
+}
+
+// Live registers a check of liveness, the one of the same name is replaced
+func (c *Checker) Live(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.live[name] = check
+}
+
+// Ready registers a check of readiness, the one of the same name is replaced
+func (c *Checker) Ready(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready[name] = check
+}
+
+// Liveness runs the checks of liveness
+func (c *Checker) Liveness(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := make(map[string]Check, len(c.live))
+	for name, check := range c.live {
+		checks[name] = check
+	}
+	timeout := c.opts.Timeout
+	c.mu.RUnlock()
+	return run(ctx, checks, timeout)
+}
+
+// Readiness runs the checks of readiness and liveness, a node not alive is
+// not ready either
+func (c *Checker) Readiness(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := make(map[string]Check, len(c.live)+len(c.ready))
+	for name, check := range c.live {
+		checks[name] = check
+	}
+	for name, check := range c.ready {
+		checks[name] = check
+	}
+	timeout := c.opts.Timeout
+	c.mu.RUnlock()
+	return run(ctx, checks, timeout)
+}
+
+// run the checks concurrently, each of them by the timeout
+func run(ctx context.Context, checks map[string]Check, timeout time.Duration) Report {
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	i := 0
+	for name, check := range checks {
+		wg.Add(1)
+		go func(r *Result, name string, check Check) {
+			defer wg.Done()
+			*r = runCheck(ctx, name, check, timeout)
+		}(&results[i], name, check)
+		i++
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	report := Report{Status: StatusOK, Checks: results}
+	for _, r := range results {
+		if r.Status != StatusOK {
+			report.Status = StatusFailing
+			break
+		}
+	}
+	return report
+}
+
+// runCheck returns once the check does or its timeout is over
+func runCheck(ctx context.Context, name string, check Check, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	r := Result{Name: name, Status: StatusOK, Duration: time.Since(start)}
+	if err != nil {
+		r.Status, r.Error = StatusFailing, err.Error()
+	}
+	return r
+}
+
+// LiveHandler serves the report of liveness, 503 once it fails
+func (c *Checker) LiveHandler() http.Handler {
+	return reportHandler(c.Liveness)
+}
+
+// ReadyHandler serves the report of readiness, 503 once it fails
+func (c *Checker) ReadyHandler() http.Handler {
+	return reportHandler(c.Readiness)
+}
+
+func reportHandler(fn func(context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		report := fn(r.Context())
+		status := http.StatusOK
+		if !report.OK() {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Errorf("unable to write health report: %v", err)
+		}
+	})
+}
+
+// Live registers a check of liveness to the default checker
+func Live(name string, check Check) {
+	DefaultChecker.Live(name, check)
+}
+
+// Ready registers a check of readiness to the default checker
+func Ready(name string, check Check) {
+	DefaultChecker.Ready(name, check)
+}
+
+// ErrNotServing is the error of a State not serving yet
+var ErrNotServing = errors.New("not serving")
+
+// State of a component, its check fails by the error it is set to
+type State struct {
+	mu  sync.RWMutex
+	err error
+}
+
+// NewState returns the state of a component not serving yet
+func NewState() *State {
+	return &State{err: ErrNotServing}
+}
+
+// Set the error of component, nil once it serves
+func (s *State) Set(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Check of the component, it is a Check
+func (s *State) Check(ctx context.Context) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err
+}
@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	c := NewChecker(Timeout(50 * time.Millisecond))
+	if r := c.Readiness(context.Background()); !r.OK() || len(r.Checks) != 0 {
+		t.Fatalf("Expected ready without checks, got %+v", r)
+	}
+
+	listener := NewState()
+	c.Live("process", func(context.Context) error { return nil })
+	c.Ready("listener", listener.Check)
+	c.Ready("broker", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	if r := c.Liveness(context.Background()); !r.OK() || len(r.Checks) != 1 {
+		t.Fatalf("Expected alive, got %+v", r)
+	}
+	r := c.Readiness(context.Background())
+	if r.OK() || len(r.Checks) != 3 {
+		t.Fatalf("Expected not ready, got %+v", r)
+	}
+	// sorted by name
+	broker, listen, process := r.Checks[0], r.Checks[1], r.Checks[2]
+	if broker.Name != "broker" || broker.Status != StatusFailing || broker.Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("Expected broker timed out, got %+v", broker)
+	}
+	if listen.Name != "listener" || listen.Error != ErrNotServing.Error() {
+		t.Fatalf("Expected listener not serving, got %+v", listen)
+	}
+	if process.Name != "process" || process.Status != StatusOK {
+		t.Fatalf("Expected process ok, got %+v", process)
+	}
+
+	listener.Set(nil)
+	c.Ready("broker", func(context.Context) error { return nil })
+	if r := c.Readiness(context.Background()); !r.OK() {
+		t.Fatalf("Expected ready, got %+v", r)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	c := NewChecker()
+	state := NewState()
+	c.Ready("listener", state.Check)
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", c.LiveHandler())
+	mux.Handle("/readyz", c.ReadyHandler())
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string) (int, Report) {
+		rsp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		var r Report
+		if err := json.NewDecoder(rsp.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return rsp.StatusCode, r
+	}
+
+	if code, r := get("/healthz"); code != http.StatusOK || !r.OK() {
+		t.Fatalf("Expected alive, got %d %+v", code, r)
+	}
+	state.Set(errors.New("accept failed"))
+	if code, r := get("/readyz"); code != http.StatusServiceUnavailable || r.Checks[0].Error != "accept failed" {
+		t.Fatalf("Expected not ready, got %d %+v", code, r)
+	}
+	state.Set(nil)
+	if code, r := get("/readyz"); code != http.StatusOK || r.Status != StatusOK {
+		t.Fatalf("Expected ready, got %d %+v", code, r)
+	}
+
+	var rsp Report
+	if err := NewHandler(c).Ready(context.Background(), &CheckRequest{}, &rsp); err != nil || !rsp.OK() {
+		t.Fatalf("Expected ready by handler, got %v %+v", err, rsp)
+	}
+}
@@ -0,0 +1,151 @@
+package acl
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+)
+
+const testPolicy = `
+default = "allow"
+identity = "NAME"
+
+[groups]
+  sensors = ["sensor-*"]
+  meters = ["meter-*"]
+
+[labels]
+  [labels."meter-4*"]
+    zone = "north"
+
+[[rules]]
+  name = "sensors-no-config"
+  effect = "deny"
+  groups = ["sensors"]
+  types = ["CONFIG"]
+  direction = "up"
+
+[[rules]]
+  name = "billing-meters"
+  effect = "allow"
+  direction = "down"
+  services = ["go.micro.srv.billing"]
+  groups = ["meters"]
+
+[[rules]]
+  name = "billing-others"
+  effect = "deny"
+  direction = "down"
+  services = ["go.micro.srv.billing"]
+
+[[rules]]
+  name = "north-no-reset"
+  effect = "deny"
+  labels = { zone = "north" }
+  types = ["RESET"]
+`
+
+func writePolicy(t *testing.T, policy string) string {
+	dir, err := ioutil.TempDir("", "acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "acl.toml")
+	if err := ioutil.WriteFile(path, []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthorize(t *testing.T) {
+	e, err := Load(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up := func(device, typ string) nserver.Access {
+		return nserver.Access{Direction: nserver.Uplink, Type: typ, Header: map[string]string{"NAME": device}}
+	}
+	down := func(service, device, typ string) nserver.Access {
+		return nserver.Access{Direction: nserver.Downlink, Type: typ, Device: device, Service: service}
+	}
+
+	testData := []struct {
+		access  nserver.Access
+		allowed bool
+	}{
+		{up("sensor-1", "CONFIG"), false},
+		{up("sensor-1", "Event"), true},
+		{up("meter-42", "CONFIG"), true},
+		{down("go.micro.srv.billing", "meter-42", "SET"), true},
+		{down("go.micro.srv.billing", "sensor-1", "SET"), false},
+		{down("go.micro.srv.other", "sensor-1", "SET"), true},
+		{down("", "meter-42", "RESET"), false},
+		{down("", "meter-7", "RESET"), true},
+	}
+	for _, d := range testData {
+		err := e.Authorize(context.Background(), d.access)
+		if (err == nil) != d.allowed {
+			t.Fatalf("Expected allowed %v of %+v, got %v", d.allowed, d.access, err)
+		}
+	}
+
+	s := e.Stats()
+	if s.Denied != 3 || s.Rules["sensors-no-config"] != 1 || s.Rules["billing-others"] != 1 || s.Rules["north-no-reset"] != 1 {
+		t.Fatalf("Unexpected stats %+v", s)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writePolicy(t, testPolicy)
+	e, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := nserver.Access{Direction: nserver.Uplink, Type: "Event", Remote: "10.0.0.9:4000"}
+	if err := e.Authorize(context.Background(), remote); err != nil {
+		t.Fatal(err)
+	}
+
+	// an invalid policy keeps the one in use
+	if err := ioutil.WriteFile(path, []byte(`default = "maybe"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if _, err := e.Reload(); err == nil {
+		t.Fatal("Expected error of invalid policy")
+	}
+
+	policy := `
+default = "deny"
+identity = "remote"
+[[rules]]
+  effect = "allow"
+  devices = ["10.0.0.*"]
+`
+	if err := ioutil.WriteFile(path, []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	os.Chtimes(path, future, future)
+	if reloaded, err := e.Reload(); err != nil || !reloaded {
+		t.Fatalf("Expected policy reloaded, got %v %v", reloaded, err)
+	}
+	if err := e.Authorize(context.Background(), remote); err != nil {
+		t.Fatal(err)
+	}
+	remote.Remote = "192.168.1.2:4000"
+	if err := e.Authorize(context.Background(), remote); err == nil {
+		t.Fatal("Expected denial by default")
+	}
+	if s := e.Stats(); s.Rules[DefaultRule] != 1 {
+		t.Fatalf("Unexpected stats %+v", s)
+	}
+}
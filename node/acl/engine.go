@@ -0,0 +1,207 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro/go-micro/v2/config/encoder/toml"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// DefaultRule counts the denials of the default effect
+const DefaultRule = "default"
+
+// Stats of denials
+type Stats struct {
+	Denied uint64
+	// Rules counts the denials by rule name
+	Rules map[string]uint64
+}
+
+// Engine authorizes frames by the policy of a file, the file is reloaded
+// once it changes
+type Engine struct {
+	path string
+
+	sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+
+	mu     sync.Mutex
+	denied uint64
+	rules  map[string]uint64
+
+	exit chan bool
+}
+
+// Load loads the policy of a file
+func Load(path string) (*Engine, error) {
+	e := &Engine{path: path, rules: make(map[string]uint64)}
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// New creates an engine of policy, it is not backed by a file
+func New(p *Policy) (*Engine, error) {
+	if err := compile(p); err != nil {
+		return nil, err
+	}
+	return &Engine{policy: p, rules: make(map[string]uint64)}, nil
+}
+
+func (e *Engine) load() error {
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	p := new(Policy)
+	if err := toml.NewEncoder().Decode(b, p); err != nil {
+		return fmt.Errorf("%s: %v", e.path, err)
+	}
+	if err := compile(p); err != nil {
+		return fmt.Errorf("%s: %v", e.path, err)
+	}
+
+	e.Lock()
+	e.policy = p
+	e.modTime = fi.ModTime()
+	e.Unlock()
+	return nil
+}
+
+// Reload reloads the file if it changed, the policy in use is kept
+// if the file is invalid
+func (e *Engine) Reload() (bool, error) {
+	if len(e.path) == 0 {
+		return false, nil
+	}
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+	e.RLock()
+	changed := !fi.ModTime().Equal(e.modTime)
+	e.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	if err := e.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Watch reloads the file at every interval
+func (e *Engine) Watch(interval time.Duration) {
+	e.Lock()
+	defer e.Unlock()
+	if e.exit != nil {
+		return
+	}
+	exit := make(chan bool)
+	e.exit = exit
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-exit:
+				return
+			case <-t.C:
+				reloaded, err := e.Reload()
+				if err != nil {
+					log.Errorf("unable to reload policy: %v", err)
+				} else if reloaded {
+					log.Infof("policy of %s reloaded", e.path)
+				}
+			}
+		}
+	}()
+}
+
+// Stop watching the file
+func (e *Engine) Stop() {
+	e.Lock()
+	defer e.Unlock()
+	if e.exit != nil {
+		close(e.exit)
+		e.exit = nil
+	}
+}
+
+// Stats returns the denials so far
+func (e *Engine) Stats() Stats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := Stats{Denied: e.denied, Rules: make(map[string]uint64, len(e.rules))}
+	for k, v := range e.rules {
+		s.Rules[k] = v
+	}
+	return s
+}
+
+// Authorize decides the access of a frame, it is a nserver.Authorizer
+func (e *Engine) Authorize(ctx context.Context, a nserver.Access) error {
+	e.RLock()
+	p := e.policy
+	e.RUnlock()
+
+	req := &request{
+		device:    a.Device,
+		typ:       a.Type,
+		direction: string(a.Direction),
+		service:   a.Service,
+	}
+	if a.Direction == nserver.Uplink {
+		req.device = identify(p.Identity, a)
+	}
+
+	effect, r := p.decide(req)
+	if effect == Allow {
+		return nil
+	}
+
+	name := DefaultRule
+	if r != nil {
+		name = r.Name
+	}
+	e.mu.Lock()
+	e.denied++
+	e.rules[name]++
+	e.mu.Unlock()
+
+	if a.Direction == nserver.Uplink {
+		log.Warnf("acl %s denied %s frame of %s from %s", name, req.typ, req.device, a.Remote)
+		return fmt.Errorf("%s frame of %s denied", req.typ, req.device)
+	}
+	log.Warnf("acl %s denied %s command of %s to %s", name, req.typ, req.service, req.device)
+	return fmt.Errorf("%s command to %s denied", req.typ, req.device)
+}
+
+// identify the device sending a frame
+func identify(identity string, a nserver.Access) string {
+	switch identity {
+	case IdentityRemote:
+		return a.Remote
+	case IdentityAccount:
+		if a.Account != nil {
+			return a.Account.ID
+		}
+		return ""
+	default:
+		return a.Header[identity]
+	}
+}
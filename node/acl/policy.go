@@ -0,0 +1,212 @@
+// Package acl provides the authorization of the packet types devices send
+// and the commands delivered to them, the policy is declared in a toml file
+// such as
+//
+//	default = "allow"
+//	identity = "NAME"
+//
+//	[groups]
+//	  sensors = ["sensor-*"]
+//
+//	[[rules]]
+//	  name = "sensors-no-config"
+//	  effect = "deny"
+//	  groups = ["sensors"]
+//	  types = ["CONFIG"]
+//	  direction = "up"
+//
+// The rules are evaluated in order and the first one matching decides.
+package acl
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The effects of rules
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// The identities of devices besides the headers of frames
+const (
+	// IdentityRemote identifies the device by the remote address of connection
+	IdentityRemote = "remote"
+	// IdentityAccount identifies the device by the account it authenticated
+	IdentityAccount = "account"
+)
+
+// Policy of devices
+type Policy struct {
+	// Default effect of the frames matching no rule, allow if it is empty
+	Default string `toml:"default"`
+	// Identity of the device sending a frame, a header such as "NAME",
+	// "remote" or "account", NAME if it is empty
+	Identity string `toml:"identity"`
+	// Groups of devices by the patterns of device ids
+	Groups map[string][]string `toml:"groups"`
+	// Labels of devices by the patterns of device ids
+	Labels map[string]map[string]string `toml:"labels"`
+	Rules  []Rule                       `toml:"rules"`
+}
+
+// Rule allows or denies the frames matching all of its conditions, a
+// condition left empty matches any frame
+type Rule struct {
+	Name   string `toml:"name"`
+	Effect string `toml:"effect"`
+	// Devices are the patterns of device ids, e.g. "meter-*"
+	Devices []string `toml:"devices"`
+	Groups  []string `toml:"groups"`
+	// Labels the device must have
+	Labels map[string]string `toml:"labels"`
+	// Types are the patterns of packet types
+	Types []string `toml:"types"`
+	// Direction is up for the frames of devices, down for the deliveries
+	Direction string `toml:"direction"`
+	// Services commanding the devices, a rule of services matches deliveries only.
+	// The service is the one the publisher of command claims, unauthenticated
+	// unless the broker restricts who publishes the commands
+	Services []string `toml:"services"`
+}
+
+func compile(p *Policy) error {
+	var errs []string
+	switch p.Default {
+	case "":
+		p.Default = Allow
+	case Allow, Deny:
+	default:
+		errs = append(errs, fmt.Sprintf("unknown default %q", p.Default))
+	}
+	if len(p.Identity) == 0 {
+		p.Identity = "NAME"
+	}
+
+	for _, patterns := range p.Groups {
+		errs = append(errs, checkPatterns(patterns)...)
+	}
+	for pattern := range p.Labels {
+		errs = append(errs, checkPatterns([]string{pattern})...)
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if len(r.Name) == 0 {
+			r.Name = "#" + strconv.Itoa(i+1)
+		}
+		if r.Effect != Allow && r.Effect != Deny {
+			errs = append(errs, fmt.Sprintf("rule %s: unknown effect %q", r.Name, r.Effect))
+		}
+		switch r.Direction {
+		case "", "up", "down":
+		default:
+			errs = append(errs, fmt.Sprintf("rule %s: unknown direction %q", r.Name, r.Direction))
+		}
+		for _, g := range r.Groups {
+			if _, ok := p.Groups[g]; !ok {
+				errs = append(errs, fmt.Sprintf("rule %s: unknown group %q", r.Name, g))
+			}
+		}
+		for _, e := range checkPatterns(append(append([]string(nil), r.Devices...), r.Types...)) {
+			errs = append(errs, fmt.Sprintf("rule %s: %s", r.Name, e))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid policy: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func checkPatterns(patterns []string) []string {
+	var errs []string
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("bad pattern %q", p))
+		}
+	}
+	return errs
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// request is a frame evaluated by the rules
+type request struct {
+	device    string
+	typ       string
+	direction string
+	service   string
+}
+
+func (p *Policy) inGroup(group, device string) bool {
+	return matchAny(p.Groups[group], device)
+}
+
+// labels of a device from the patterns matching it
+func (p *Policy) labels(device string) map[string]string {
+	labels := make(map[string]string)
+	for pattern, l := range p.Labels {
+		if ok, _ := path.Match(pattern, device); ok {
+			for k, v := range l {
+				labels[k] = v
+			}
+		}
+	}
+	return labels
+}
+
+func (p *Policy) matches(r *Rule, req *request) bool {
+	if len(r.Direction) > 0 && r.Direction != req.direction {
+		return false
+	}
+	if len(r.Services) > 0 && (len(req.service) == 0 || !matchAny(r.Services, req.service)) {
+		return false
+	}
+	if len(r.Types) > 0 && !matchAny(r.Types, req.typ) {
+		return false
+	}
+	if len(r.Devices) > 0 && !matchAny(r.Devices, req.device) {
+		return false
+	}
+	if len(r.Groups) > 0 {
+		in := false
+		for _, g := range r.Groups {
+			if p.inGroup(g, req.device) {
+				in = true
+				break
+			}
+		}
+		if !in {
+			return false
+		}
+	}
+	if len(r.Labels) > 0 {
+		labels := p.labels(req.device)
+		for k, v := range r.Labels {
+			if labels[k] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// decide returns the effect on a request and the rule deciding it,
+// nil for the default
+func (p *Policy) decide(req *request) (string, *Rule) {
+	for i := range p.Rules {
+		if p.matches(&p.Rules[i], req) {
+			return p.Rules[i].Effect, &p.Rules[i]
+		}
+	}
+	return p.Default, nil
+}
@@ -0,0 +1,203 @@
+// Package auth provides the authenticators of device connections for the
+// handshake stage of node server: a token of go-micro auth carried in the
+// frames, a challenge answered by the HMAC of a shared secret, and the
+// client certificate of a TLS connection
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	nserver "github.com/micro-community/x-edge/node/server"
+	nts "github.com/micro-community/x-edge/node/transport"
+	mauth "github.com/micro/go-micro/v2/auth"
+	"github.com/micro/go-micro/v2/codec"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+// The packet types of the challenge handshake
+const (
+	// ChallengeType of the frame challenging the device once connected
+	ChallengeType = "CHALLENGE"
+	// AuthType of the frame answering the challenge and of its reply
+	AuthType = "AUTH"
+)
+
+// AccountType of the accounts of devices
+const AccountType = "device"
+
+// Token authenticates the device by a token of go-micro auth in the element
+// of frame at path, e.g. "TOKEN", the frame is dispatched once it passes,
+// the ID of account is the NAME the frames of session must carry
+func Token(a mauth.Auth, path string) nserver.Authenticator {
+	return func(sock transport.Socket) nserver.Handshake {
+		return &tokenHandshake{auth: a, path: path}
+	}
+}
+
+type tokenHandshake struct {
+	auth mauth.Auth
+	path string
+}
+
+func (t *tokenHandshake) Challenge() []byte {
+	return nil
+}
+
+func (t *tokenHandshake) Verify(ctx context.Context, msg *codec.Message) (nserver.AuthStep, error) {
+	elems, err := xmlc.ToMap(msg.Body)
+	if err != nil {
+		return nserver.AuthStep{}, err
+	}
+	token, ok := lookup(elems, t.path)
+	if !ok || len(token) == 0 {
+		return nserver.AuthStep{}, fmt.Errorf("no token in %s", t.path)
+	}
+	acc, err := t.auth.Inspect(token)
+	if err != nil {
+		return nserver.AuthStep{}, err
+	}
+	return nserver.AuthStep{Account: acc}, nil
+}
+
+// Secrets returns the secret shared with a device
+type Secrets func(device string) ([]byte, error)
+
+// HMAC challenges the device with a nonce once connected, the device answers
+// a frame of AuthType with its NAME and the DIGEST in hex of HMAC-SHA256 of
+// the nonce keyed by the secret shared
+func HMAC(secrets Secrets) nserver.Authenticator {
+	return func(sock transport.Socket) nserver.Handshake {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			// the handshake is never passed without a nonce
+			return &hmacHandshake{err: err}
+		}
+		return &hmacHandshake{secrets: secrets, nonce: hex.EncodeToString(nonce)}
+	}
+}
+
+type hmacHandshake struct {
+	secrets Secrets
+	nonce   string
+	err     error
+}
+
+func (h *hmacHandshake) Challenge() []byte {
+	if h.err != nil {
+		return nil
+	}
+	frame, _ := xmlc.FromMap("PROTOCOL", map[string]interface{}{
+		"TYPE":  ChallengeType,
+		"NONCE": h.nonce,
+	})
+	return frame
+}
+
+func (h *hmacHandshake) Verify(ctx context.Context, msg *codec.Message) (nserver.AuthStep, error) {
+	if h.err != nil {
+		return nserver.AuthStep{}, h.err
+	}
+	// the other frames wait for the challenge answered
+	if msg.Method != AuthType {
+		return nserver.AuthStep{}, nil
+	}
+
+	step := nserver.AuthStep{Consumed: true}
+	elems, err := xmlc.ToMap(msg.Body)
+	if err != nil {
+		return step, err
+	}
+	device, _ := lookup(elems, "NAME")
+	digest, _ := lookup(elems, "DIGEST")
+	step.Reply = reply(device, "DENIED")
+	if len(device) == 0 || len(digest) == 0 {
+		return step, errors.New("challenge answered without NAME or DIGEST")
+	}
+
+	secret, err := h.secrets(device)
+	if err != nil {
+		return step, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(h.nonce))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(digest))) {
+		return step, fmt.Errorf("digest of %s mismatched", device)
+	}
+
+	step.Account = &mauth.Account{
+		ID:       device,
+		Type:     AccountType,
+		Metadata: map[string]string{"auth": "hmac"},
+	}
+	step.Reply = reply(device, "OK")
+	return step, nil
+}
+
+func reply(device, result string) []byte {
+	frame, _ := xmlc.FromMap("PROTOCOL", map[string]interface{}{
+		"NAME":   device,
+		"TYPE":   AuthType,
+		"RESULT": result,
+	})
+	return frame
+}
+
+// Cert authenticates the device by the common name of its client certificate
+// verified by the TLS listener, the frames are dispatched once it passes
+func Cert() nserver.Authenticator {
+	return func(sock transport.Socket) nserver.Handshake {
+		return &certHandshake{sock: sock}
+	}
+}
+
+type certHandshake struct {
+	sock transport.Socket
+}
+
+func (c *certHandshake) Challenge() []byte {
+	return nil
+}
+
+func (c *certHandshake) Verify(ctx context.Context, msg *codec.Message) (nserver.AuthStep, error) {
+	id, err := nts.PeerOf(c.sock)
+	if err != nil {
+		return nserver.AuthStep{}, err
+	}
+	if len(id.CommonName) == 0 {
+		return nserver.AuthStep{}, errors.New("client certificate without common name")
+	}
+	return nserver.AuthStep{Account: &mauth.Account{
+		ID:   id.CommonName,
+		Type: AccountType,
+		Metadata: map[string]string{
+			"auth":        "cert",
+			"serial":      id.Serial,
+			"fingerprint": id.Fingerprint,
+		},
+	}}, nil
+}
+
+// lookup the text of an element by path
+func lookup(elems map[string]interface{}, path string) (string, bool) {
+	var v interface{} = elems
+	for _, p := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[p]; !ok {
+			return "", false
+		}
+	}
+	s, ok := v.(string)
+	return s, ok
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	mauth "github.com/micro/go-micro/v2/auth"
+	"github.com/micro/go-micro/v2/codec"
+)
+
+// testAuth knows the token of a device
+type testAuth struct {
+	mauth.Auth
+}
+
+func (testAuth) Inspect(token string) (*mauth.Account, error) {
+	if token != "secret-token" {
+		return nil, errors.New("invalid token")
+	}
+	return &mauth.Account{ID: "meter-42", Type: AccountType}, nil
+}
+
+func frame(packetType, body string) *codec.Message {
+	return &codec.Message{
+		Method: packetType,
+		Body:   []byte("<PROTOCOL><NAME>meter-42</NAME><TYPE>" + packetType + "</TYPE>" + body + "</PROTOCOL>"),
+	}
+}
+
+func TestToken(t *testing.T) {
+	h := Token(testAuth{}, "AUTH.TOKEN")(nil)
+	if c := h.Challenge(); c != nil {
+		t.Fatalf("Unexpected challenge %s", c)
+	}
+
+	if _, err := h.Verify(context.Background(), frame("Event", "")); err == nil {
+		t.Fatal("Expected error of frame without token")
+	}
+	if _, err := h.Verify(context.Background(), frame("Event", "<AUTH><TOKEN>guess</TOKEN></AUTH>")); err == nil {
+		t.Fatal("Expected error of invalid token")
+	}
+	step, err := h.Verify(context.Background(), frame("Event", "<AUTH><TOKEN>secret-token</TOKEN></AUTH>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Account == nil || step.Account.ID != "meter-42" || step.Consumed {
+		t.Fatalf("Unexpected step %+v", step)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	secrets := func(device string) ([]byte, error) {
+		if device != "meter-42" {
+			return nil, errors.New("unknown device")
+		}
+		return []byte("shared"), nil
+	}
+	h := HMAC(secrets)(nil)
+
+	challenge, err := xmlc.ToMap(h.Challenge())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, _ := challenge["NONCE"].(string)
+	if challenge["TYPE"] != ChallengeType || len(nonce) != 32 {
+		t.Fatalf("Unexpected challenge %v", challenge)
+	}
+
+	// the frames before the answer wait for it
+	step, err := h.Verify(context.Background(), frame("Event", ""))
+	if err != nil || step.Account != nil || step.Consumed {
+		t.Fatalf("Unexpected step %+v %v", step, err)
+	}
+
+	step, err = h.Verify(context.Background(), frame(AuthType, "<DIGEST>00</DIGEST>"))
+	if err == nil || !step.Consumed || !strings.Contains(string(step.Reply), "DENIED") {
+		t.Fatalf("Expected digest denied, got %+v %v", step, err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("shared"))
+	mac.Write([]byte(nonce))
+	digest := hex.EncodeToString(mac.Sum(nil))
+	step, err = h.Verify(context.Background(), frame(AuthType, "<DIGEST>"+digest+"</DIGEST>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Account == nil || step.Account.ID != "meter-42" || !strings.Contains(string(step.Reply), "<RESULT>OK</RESULT>") {
+		t.Fatalf("Unexpected step %+v", step)
+	}
+}
+
+func TestCertWithoutTLS(t *testing.T) {
+	h := Cert()(nil)
+	if _, err := h.Verify(context.Background(), frame("Event", "")); err == nil {
+		t.Fatal("Expected error of connection without TLS")
+	}
+}
@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ToMap converts a frame into a map of its elements, the text of an element
+// is a string, the nested elements are maps and repeated ones are slices
+func ToMap(frame []byte) (map[string]interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(frame))
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	// find the root element
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := tok.(xml.StartElement); ok {
+			v, err := elementOf(dec)
+			if err != nil {
+				return nil, err
+			}
+			if m, ok := v.(map[string]interface{}); ok {
+				return m, nil
+			}
+			return map[string]interface{}{}, nil
+		}
+	}
+}
+
+// elementOf reads an element after its start, it returns the text of
+// element or a map of the elements nested
+func elementOf(dec *xml.Decoder) (interface{}, error) {
+	var text strings.Builder
+	var m map[string]interface{}
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			v, err := elementOf(dec)
+			if err != nil {
+				return nil, err
+			}
+			if m == nil {
+				m = make(map[string]interface{})
+			}
+			name := t.Name.Local
+			switch prev := m[name].(type) {
+			case nil:
+				m[name] = v
+			case []interface{}:
+				m[name] = append(prev, v)
+			default:
+				m[name] = []interface{}{prev, v}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if m != nil {
+				return m, nil
+			}
+			return strings.TrimSpace(text.String()), nil
+		}
+	}
+}
+
+// FromMap converts a map into a frame of root element, the keys are
+// the names of elements in upper case and sorted
+func FromMap(root string, m map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := writeElement(&buf, root, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeElement(buf *bytes.Buffer, name string, v interface{}) error {
+	switch val := v.(type) {
+	case []interface{}:
+		for _, e := range val {
+			if err := writeElement(buf, name, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		fmt.Fprintf(buf, "<%s/>", name)
+		return nil
+	}
+
+	fmt.Fprintf(buf, "<%s>", name)
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return strings.ToUpper(keys[i]) < strings.ToUpper(keys[j]) })
+		for _, k := range keys {
+			if err := writeElement(buf, strings.ToUpper(k), val[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := xml.EscapeText(buf, []byte(fmt.Sprint(val))); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(buf, "</%s>", name)
+	return nil
+}
@@ -0,0 +1,488 @@
+// Package crypt encrypts the payloads of devices unable to do TLS, it wraps
+// the codec of device and decrypts the frames by the key of each device
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	"github.com/micro/go-micro/v2/codec"
+)
+
+// Ciphers of payload
+const (
+	// GCM is AES/GCM, the data is the nonce, the ciphertext and the tag
+	GCM = "gcm"
+	// CBC is AES/CBC with PKCS#7 padding, the data is the IV and the ciphertext,
+	// followed by the HMAC-SHA256 of them if the key has a MAC key
+	CBC = "cbc"
+)
+
+// DefaultWindow is the number of counters a frame may be late by
+const DefaultWindow = 64
+
+var (
+	// ErrNoKey is returned by a key store for a device sending plaintext
+	ErrNoKey = errors.New("no key of device")
+	// ErrReplay is returned for a frame of counter seen or too old
+	ErrReplay = errors.New("frame replayed")
+	// ErrPlaintext is returned for a plain frame of device which has a key
+	ErrPlaintext = errors.New("frame of device with key is not encrypted")
+	// ErrDecrypt is returned for a frame failed to decrypt or authenticate
+	ErrDecrypt = errors.New("frame failed to decrypt")
+	// ErrMismatch is returned for a frame decrypted naming a device other than its envelope
+	ErrMismatch = errors.New("frame names a device other than its key")
+)
+
+// Key of a device
+type Key struct {
+	// Cipher is GCM or CBC, GCM if empty
+	Cipher string
+	// Secret is the AES key of 16, 24 or 32 bytes
+	Secret []byte
+	// MAC is the HMAC-SHA256 key authenticating the frames of CBC, without it
+	// the frames of CBC are not authenticated and only replayed as-is are detected
+	MAC []byte
+}
+
+// KeyStore looks up the keys of devices, it returns ErrNoKey
+// for the devices sending plaintext
+type KeyStore interface {
+	Key(device string) (*Key, error)
+}
+
+// Envelope is the frame of an encrypted payload, the device and the counter
+// are in clear, the payload is the frame of the codec wrapped
+type Envelope struct {
+	XMLName xml.Name `xml:"PROTOCOL"`
+	Name    string   `xml:"NAME"`
+	Cipher  string   `xml:"ENC,omitempty"`
+	Seq     uint64   `xml:"SEQ,omitempty"`
+	// Data is the base64 of IV or nonce, ciphertext and tag
+	Data string `xml:"DATA,omitempty"`
+}
+
+// Crypt keeps the replay windows of devices and the counters of replies,
+// the codecs of a Crypt share them. They are kept in memory only unless
+// persisted to a file
+type Crypt struct {
+	keys   KeyStore
+	window uint64
+
+	sync.Mutex
+	windows map[string]*window
+	seqs    map[string]uint64
+	// state is the file the counters are persisted to, leases the counters
+	// of replies reserved in it and dirty whether the windows changed since
+	state  string
+	leases map[string]uint64
+	dirty  bool
+	exit   chan bool
+}
+
+// New returns the Crypt of a key store, the replay window is DefaultWindow
+func New(keys KeyStore) *Crypt {
+	return &Crypt{
+		keys:    keys,
+		window:  DefaultWindow,
+		windows: make(map[string]*window),
+		seqs:    make(map[string]uint64),
+		leases:  make(map[string]uint64),
+	}
+}
+
+// Window sets the number of counters a frame may be late by, at most 64
+func (c *Crypt) Window(n uint64) *Crypt {
+	if n > 0 && n <= 64 {
+		c.window = n
+	}
+	return c
+}
+
+// Wrap returns the codec decrypting the frames for the codec wrapped
+func (c *Crypt) Wrap(nc codec.NewCodec) codec.NewCodec {
+	return func(rwc io.ReadWriteCloser) codec.Codec {
+		cc := &cryptCodec{crypt: c, conn: rwc}
+		cc.plain = &plainConn{parent: rwc, r: rwc}
+		cc.codec = nc(cc.plain)
+		return cc
+	}
+}
+
+// Wrap a codec by the keys, the replay window is DefaultWindow
+func Wrap(nc codec.NewCodec, keys KeyStore) codec.NewCodec {
+	return New(keys).Wrap(nc)
+}
+
+// key returns the key of device, nil for a device sending plaintext
+func (c *Crypt) key(device string) (*Key, error) {
+	if len(device) == 0 {
+		return nil, nil
+	}
+	k, err := c.keys.Key(device)
+	if err == ErrNoKey {
+		return nil, nil
+	}
+	return k, err
+}
+
+// Open decrypts the payload of an envelope, the frame is nil for a plain frame
+// of a device without key
+func (c *Crypt) Open(env *Envelope) ([]byte, error) {
+	k, err := c.key(env.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Cipher) == 0 {
+		if k != nil {
+			return nil, ErrPlaintext
+		}
+		return nil, nil
+	}
+	if k == nil {
+		return nil, fmt.Errorf("%v %s", ErrNoKey, env.Name)
+	}
+	if cipherOf(k) != env.Cipher {
+		return nil, fmt.Errorf("cipher %q of %s is not %q", env.Cipher, env.Name, cipherOf(k))
+	}
+	data, err := base64.StdEncoding.DecodeString(env.Data)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	// the counter is checked once the frame is authentic
+	if !c.check(env.Name, env.Seq, false) {
+		return nil, ErrReplay
+	}
+	plain, err := open(k, additional(env.Name, env.Seq), data)
+	if err != nil {
+		return nil, err
+	}
+	if !c.check(env.Name, env.Seq, true) {
+		return nil, ErrReplay
+	}
+	return plain, nil
+}
+
+// Seal encrypts a frame to a device, the envelope is nil for a device without key
+func (c *Crypt) Seal(device string, frame []byte) (*Envelope, error) {
+	k, err := c.key(device)
+	if err != nil || k == nil {
+		return nil, err
+	}
+
+	c.Lock()
+	c.seqs[device]++
+	seq := c.seqs[device]
+	err = c.reserve(device, seq)
+	c.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("unable to reserve counter of %s: %v", device, err)
+	}
+
+	data, err := seal(k, additional(device, seq), frame)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		Name:   device,
+		Cipher: cipherOf(k),
+		Seq:    seq,
+		Data:   base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// check the counter of a device by its window, it is marked as seen by commit
+func (c *Crypt) check(device string, seq uint64, commit bool) bool {
+	c.Lock()
+	defer c.Unlock()
+	w, ok := c.windows[device]
+	if !ok {
+		w = &window{}
+		c.windows[device] = w
+	}
+	top := w.top
+	ok = w.accept(seq, c.window, commit)
+	c.dirty = c.dirty || w.top != top
+	return ok
+}
+
+// window of the counters seen, the bit i of seen is the counter top-i
+type window struct {
+	top  uint64
+	seen uint64
+}
+
+func (w *window) accept(seq, size uint64, commit bool) bool {
+	if seq == 0 {
+		return false
+	}
+	if seq > w.top {
+		if commit {
+			if shift := seq - w.top; shift < 64 {
+				w.seen = w.seen<<shift | 1
+			} else {
+				w.seen = 1
+			}
+			w.top = seq
+		}
+		return true
+	}
+	back := w.top - seq
+	if back >= size || w.seen&(1<<back) != 0 {
+		return false
+	}
+	if commit {
+		w.seen |= 1 << back
+	}
+	return true
+}
+
+func cipherOf(k *Key) string {
+	if len(k.Cipher) == 0 {
+		return GCM
+	}
+	return k.Cipher
+}
+
+// additional data authenticated along, the device and counter in clear
+func additional(device string, seq uint64) []byte {
+	return []byte(device + ":" + strconv.FormatUint(seq, 10))
+}
+
+func seal(k *Key, ad, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(k.Secret)
+	if err != nil {
+		return nil, err
+	}
+	switch cipherOf(k) {
+	case GCM:
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		return aead.Seal(nonce, nonce, plain, ad), nil
+	case CBC:
+		pad := aes.BlockSize - len(plain)%aes.BlockSize
+		padded := append(append([]byte(nil), plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+		data := make([]byte, aes.BlockSize+len(padded))
+		if _, err := rand.Read(data[:aes.BlockSize]); err != nil {
+			return nil, err
+		}
+		cipher.NewCBCEncrypter(block, data[:aes.BlockSize]).CryptBlocks(data[aes.BlockSize:], padded)
+		if len(k.MAC) > 0 {
+			data = append(data, mac(k.MAC, ad, data)...)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("unknown cipher %q", k.Cipher)
+}
+
+func open(k *Key, ad, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(k.Secret)
+	if err != nil {
+		return nil, err
+	}
+	switch cipherOf(k) {
+	case GCM:
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < aead.NonceSize() {
+			return nil, ErrDecrypt
+		}
+		plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], ad)
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+		return plain, nil
+	case CBC:
+		if len(k.MAC) > 0 {
+			if len(data) < sha256.Size {
+				return nil, ErrDecrypt
+			}
+			sum := data[len(data)-sha256.Size:]
+			data = data[:len(data)-sha256.Size]
+			if !hmac.Equal(sum, mac(k.MAC, ad, data)) {
+				return nil, ErrDecrypt
+			}
+		}
+		if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+			return nil, ErrDecrypt
+		}
+		plain := make([]byte, len(data)-aes.BlockSize)
+		cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plain, data[aes.BlockSize:])
+		pad := int(plain[len(plain)-1])
+		if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+			return nil, ErrDecrypt
+		}
+		return plain[:len(plain)-pad], nil
+	}
+	return nil, fmt.Errorf("unknown cipher %q", k.Cipher)
+}
+
+func mac(key, ad, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(ad)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// parse the envelope of a frame, a frame of other root is not one
+func parse(frame []byte) (*Envelope, bool) {
+	dec := xml.NewDecoder(bytes.NewReader(frame))
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	env := new(Envelope)
+	if err := dec.Decode(env); err != nil {
+		return nil, false
+	}
+	return env, true
+}
+
+// cryptCodec decrypts the frames for the codec wrapped, which reads and
+// writes the plaintext by plainConn
+type cryptCodec struct {
+	crypt *Crypt
+	conn  io.ReadWriteCloser
+	plain *plainConn
+	codec codec.Codec
+	// device and frame last read
+	device string
+	frame  []byte
+}
+
+var _ xmlc.FrameCodec = (*cryptCodec)(nil)
+
+func (c *cryptCodec) ReadHeader(m *codec.Message, t codec.MessageType) error {
+	if m == nil || m.Body == nil {
+		return c.codec.ReadHeader(m, t)
+	}
+
+	c.frame = m.Body
+	c.plain.r = c.conn
+	var plain []byte
+	env, ok := parse(m.Body)
+	if ok {
+		var err error
+		if plain, err = c.crypt.Open(env); err != nil {
+			return fmt.Errorf("frame of %s: %v", env.Name, err)
+		}
+		c.device = env.Name
+		if plain != nil {
+			c.frame = plain
+			c.plain.r = bytes.NewReader(plain)
+			m.Body = plain
+		}
+	}
+	if err := c.codec.ReadHeader(m, t); err != nil {
+		return err
+	}
+
+	name := m.Header["NAME"]
+	if plain == nil {
+		// a device with key has to encrypt, whatever the root of its frames
+		k, err := c.crypt.key(name)
+		if err == nil && k != nil {
+			err = ErrPlaintext
+		}
+		if err != nil {
+			return fmt.Errorf("frame of %s: %v", name, err)
+		}
+		return nil
+	}
+	// the device of key is the one sending the frame
+	if name != env.Name {
+		return fmt.Errorf("frame of %s: %v %q", env.Name, ErrMismatch, name)
+	}
+	return nil
+}
+
+func (c *cryptCodec) ReadBody(b interface{}) error {
+	return c.codec.ReadBody(b)
+}
+
+func (c *cryptCodec) Write(m *codec.Message, b interface{}) error {
+	c.plain.w.Reset()
+	if err := c.codec.Write(m, b); err != nil {
+		return err
+	}
+	var hdr map[string]string
+	if m != nil {
+		hdr = m.Header
+	}
+	frame, err := c.Seal(hdr, c.plain.w.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(frame)
+	return err
+}
+
+// Frame returns the frame last read, decrypted
+func (c *cryptCodec) Frame() []byte {
+	return c.frame
+}
+
+// Seal encrypts a frame to the device of header or to the one last read
+func (c *cryptCodec) Seal(hdr map[string]string, frame []byte) ([]byte, error) {
+	device := hdr["NAME"]
+	if len(device) == 0 {
+		device = c.device
+	}
+	env, err := c.crypt.Seal(device, frame)
+	if err != nil || env == nil {
+		return frame, err
+	}
+	out, err := xml.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+func (c *cryptCodec) Close() error {
+	return c.codec.Close()
+}
+
+func (c *cryptCodec) String() string {
+	return "crypt-" + c.codec.String()
+}
+
+// plainConn is the conn of codec wrapped, it reads the frame decrypted
+// and keeps the frame written to encrypt
+type plainConn struct {
+	parent io.ReadWriteCloser
+	r      io.Reader
+	w      bytes.Buffer
+}
+
+func (p *plainConn) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
+
+func (p *plainConn) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+func (p *plainConn) Close() error {
+	return p.parent.Close()
+}
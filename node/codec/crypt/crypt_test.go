@@ -0,0 +1,254 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	"github.com/micro/go-micro/v2/codec"
+)
+
+const testKeys = `
+[keys.meter-42]
+cipher = "gcm"
+secret = "000102030405060708090a0b0c0d0e0f"
+
+[keys.meter-43]
+cipher = "cbc"
+secret = "0f0e0d0c0b0a09080706050403020100"
+mac = "a0a1a2a3a4a5a6a7a8a9aaabacadaeaf"
+`
+
+var testFrame = []byte(`<?xml version="1.0" encoding="gb2312"?>
+<PROTOCOL><VER>1.0</VER><NAME>meter-42</NAME><TYPE>1</TYPE><ADDR>Road.1</ADDR></PROTOCOL>`)
+
+type testConn struct {
+	bytes.Buffer
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+type testPackage struct {
+	XMLName xml.Name `xml:"PROTOCOL"`
+	Name    string   `xml:"NAME"`
+	Type    string   `xml:"TYPE"`
+	Addr    string   `xml:"ADDR"`
+}
+
+func loadTestKeys(t *testing.T) *FileKeys {
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "keys.toml")
+	if err := ioutil.WriteFile(path, []byte(testKeys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// sealed returns the envelope of a frame sent by a device
+func sealed(t *testing.T, device *Crypt, name string, frame []byte) []byte {
+	env, err := device.Seal(name, frame)
+	if err != nil || env == nil {
+		t.Fatalf("Unable to seal frame of %s: %v", name, err)
+	}
+	b, err := xml.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// read a frame by a codec as the node server does
+func read(cc codec.Codec, conn *testConn, frame []byte) (*codec.Message, error) {
+	conn.Reset()
+	conn.Write(frame)
+	m := &codec.Message{Header: map[string]string{}, Body: frame}
+	return m, cc.ReadHeader(m, codec.Request)
+}
+
+func TestCodec(t *testing.T) {
+	keys := loadTestKeys(t)
+	device := New(keys)
+	conn := new(testConn)
+	cc := Wrap(xmlc.NewCodec, keys)(conn)
+
+	frame := sealed(t, device, "meter-42", testFrame)
+	m, err := read(cc, conn, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header["NAME"] != "meter-42" || m.Header["TYPE"] != "1" {
+		t.Fatalf("Unexpected header %v", m.Header)
+	}
+	if !bytes.Equal(cc.(xmlc.FrameCodec).Frame(), testFrame) {
+		t.Fatalf("Expected frame decrypted, got %s", cc.(xmlc.FrameCodec).Frame())
+	}
+	pkg := testPackage{}
+	if err := cc.ReadBody(&pkg); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Addr != "Road.1" {
+		t.Fatalf("Unexpected body %+v", pkg)
+	}
+
+	// the replies are encrypted to the device
+	conn.Reset()
+	if err := cc.Write(&codec.Message{Header: map[string]string{}}, &testPackage{Name: "meter-42", Type: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	env, ok := parse(conn.Bytes())
+	if !ok || env.Cipher != GCM || env.Seq != 1 {
+		t.Fatalf("Expected reply encrypted, got %s", conn.Bytes())
+	}
+	plain, err := device.Open(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(plain), "<TYPE>2</TYPE>") {
+		t.Fatalf("Unexpected reply %s", plain)
+	}
+
+	// a frame replayed is rejected
+	if _, err := read(cc, conn, frame); err == nil || !strings.Contains(err.Error(), ErrReplay.Error()) {
+		t.Fatalf("Expected replay rejected, got %v", err)
+	}
+
+	// a device sends the frames of its own only
+	spoofed := bytes.Replace(testFrame, []byte("meter-42"), []byte("meter-43"), 1)
+	if _, err := read(cc, conn, sealed(t, device, "meter-42", spoofed)); err == nil || !strings.Contains(err.Error(), ErrMismatch.Error()) {
+		t.Fatalf("Expected frame of other device rejected, got %v", err)
+	}
+
+	// a device with key has to encrypt, the others send plaintext
+	if _, err := read(cc, conn, testFrame); err == nil || !strings.Contains(err.Error(), ErrPlaintext.Error()) {
+		t.Fatalf("Expected plaintext rejected, got %v", err)
+	}
+	other := []byte(`<X><NAME>meter-42</NAME><TYPE>CONFIG</TYPE></X>`)
+	if _, err := read(cc, conn, other); err == nil || !strings.Contains(err.Error(), ErrPlaintext.Error()) {
+		t.Fatalf("Expected plaintext of other root rejected, got %v", err)
+	}
+	plainFrame := bytes.Replace(testFrame, []byte("meter-42"), []byte("meter-1"), 1)
+	m, err = read(cc, conn, plainFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header["NAME"] != "meter-1" || !bytes.Equal(cc.(xmlc.FrameCodec).Frame(), plainFrame) {
+		t.Fatalf("Expected plaintext passed, got %v", m.Header)
+	}
+	if b, err := cc.(xmlc.FrameCodec).Seal(nil, plainFrame); err != nil || !bytes.Equal(b, plainFrame) {
+		t.Fatalf("Expected plaintext reply, got %s %v", b, err)
+	}
+}
+
+func TestCBC(t *testing.T) {
+	keys := loadTestKeys(t)
+	device := New(keys)
+	conn := new(testConn)
+	cc := Wrap(xmlc.NewCodec, keys)(conn)
+
+	frame := bytes.Replace(testFrame, []byte("meter-42"), []byte("meter-43"), 1)
+	env, err := device.Seal("meter-43", frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Cipher != CBC {
+		t.Fatalf("Expected CBC, got %s", env.Cipher)
+	}
+
+	// the counter is authenticated
+	env.Seq++
+	b, _ := xml.Marshal(env)
+	if _, err := read(cc, conn, b); err == nil || !strings.Contains(err.Error(), ErrDecrypt.Error()) {
+		t.Fatalf("Expected tampered frame rejected, got %v", err)
+	}
+	env.Seq--
+	b, _ = xml.Marshal(env)
+	m, err := read(cc, conn, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header["NAME"] != "meter-43" {
+		t.Fatalf("Unexpected header %v", m.Header)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := &window{}
+	for _, c := range []struct {
+		seq    uint64
+		accept bool
+	}{
+		{0, false},
+		{5, true},
+		{3, true},
+		{3, false},
+		{70, true},
+		{6, false},
+		{7, true},
+		{70, false},
+		{200, true},
+		{136, false},
+		{137, true},
+	} {
+		if ok := w.accept(c.seq, DefaultWindow, true); ok != c.accept {
+			t.Fatalf("Expected counter %d accepted %v, got %v", c.seq, c.accept, ok)
+		}
+	}
+}
+
+func TestPersist(t *testing.T) {
+	keys := loadTestKeys(t)
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "counters.json")
+
+	edge := New(keys)
+	if err := edge.Persist(path); err != nil {
+		t.Fatal(err)
+	}
+	device := New(keys)
+	conn := new(testConn)
+	frame := sealed(t, device, "meter-42", testFrame)
+	if _, err := read(edge.Wrap(xmlc.NewCodec)(conn), conn, frame); err != nil {
+		t.Fatal(err)
+	}
+	if env, err := edge.Seal("meter-42", testFrame); err != nil || env.Seq != 1 {
+		t.Fatalf("Expected reply of counter 1, got %+v %v", env, err)
+	}
+	if err := edge.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart accepts no frame seen and reuses no counter of replies
+	restarted := New(keys)
+	if err := restarted.Persist(path); err != nil {
+		t.Fatal(err)
+	}
+	cc := restarted.Wrap(xmlc.NewCodec)(conn)
+	if _, err := read(cc, conn, frame); err == nil || !strings.Contains(err.Error(), ErrReplay.Error()) {
+		t.Fatalf("Expected frame replayed after restart rejected, got %v", err)
+	}
+	if _, err := read(cc, conn, sealed(t, device, "meter-42", testFrame)); err != nil {
+		t.Fatalf("Expected the next frame accepted, got %v", err)
+	}
+	if env, err := restarted.Seal("meter-42", testFrame); err != nil || env.Seq != SeqLease+1 {
+		t.Fatalf("Expected reply past the counters reserved, got %+v %v", env, err)
+	}
+}
@@ -0,0 +1,177 @@
+package crypt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/micro/go-micro/v2/config/encoder/toml"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// KeyFile is the file of FileKeys, the keys are hex by device id:
+//
+//	[keys.meter-42]
+//	cipher = "gcm"
+//	secret = "000102030405060708090a0b0c0d0e0f"
+type KeyFile struct {
+	Keys map[string]KeyEntry `toml:"keys"`
+}
+
+// KeyEntry of a device in KeyFile
+type KeyEntry struct {
+	Cipher string `toml:"cipher"`
+	Secret string `toml:"secret"`
+	MAC    string `toml:"mac"`
+}
+
+// Keys is a KeyStore in memory
+type Keys map[string]*Key
+
+// Key of a device
+func (k Keys) Key(device string) (*Key, error) {
+	if key, ok := k[device]; ok {
+		return key, nil
+	}
+	return nil, ErrNoKey
+}
+
+// FileKeys is the KeyStore of a file, the file is reloaded once it changes
+type FileKeys struct {
+	path string
+
+	sync.RWMutex
+	keys    Keys
+	modTime time.Time
+
+	exit chan bool
+}
+
+// LoadKeys loads the keys of a file
+func LoadKeys(path string) (*FileKeys, error) {
+	f := &FileKeys{path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Key of a device
+func (f *FileKeys) Key(device string) (*Key, error) {
+	f.RLock()
+	defer f.RUnlock()
+	return f.keys.Key(device)
+}
+
+// Reload the file if it changed, the keys loaded are kept if it is invalid
+func (f *FileKeys) Reload() (bool, error) {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	f.RLock()
+	changed := !fi.ModTime().Equal(f.modTime)
+	f.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	if err := f.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Watch reloads the file at every interval
+func (f *FileKeys) Watch(interval time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+	if f.exit != nil {
+		return
+	}
+	exit := make(chan bool)
+	f.exit = exit
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-exit:
+				return
+			case <-t.C:
+				reloaded, err := f.Reload()
+				if err != nil {
+					log.Errorf("unable to reload keys: %v", err)
+				} else if reloaded {
+					log.Infof("keys of %s reloaded", f.path)
+				}
+			}
+		}
+	}()
+}
+
+// Stop watching the file
+func (f *FileKeys) Stop() {
+	f.Lock()
+	defer f.Unlock()
+	if f.exit != nil {
+		close(f.exit)
+		f.exit = nil
+	}
+}
+
+func (f *FileKeys) load() error {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	kf := new(KeyFile)
+	if err := toml.NewEncoder().Decode(b, kf); err != nil {
+		return fmt.Errorf("%s: %v", f.path, err)
+	}
+	keys, err := kf.keys()
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.path, err)
+	}
+
+	f.Lock()
+	f.keys = keys
+	f.modTime = fi.ModTime()
+	f.Unlock()
+	return nil
+}
+
+func (kf *KeyFile) keys() (Keys, error) {
+	keys := make(Keys, len(kf.Keys))
+	for device, e := range kf.Keys {
+		k := &Key{Cipher: e.Cipher}
+		if c := cipherOf(k); c != GCM && c != CBC {
+			return nil, fmt.Errorf("unknown cipher %q of %s", e.Cipher, device)
+		}
+		secret, err := hex.DecodeString(e.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("secret of %s: %v", device, err)
+		}
+		switch len(secret) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("secret of %s is %d bytes, not 16, 24 or 32", device, len(secret))
+		}
+		k.Secret = secret
+		if len(e.MAC) > 0 {
+			if k.MAC, err = hex.DecodeString(e.MAC); err != nil {
+				return nil, fmt.Errorf("mac of %s: %v", device, err)
+			}
+		}
+		keys[device] = k
+	}
+	return keys, nil
+}
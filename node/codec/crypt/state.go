@@ -0,0 +1,148 @@
+package crypt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// SeqLease is the counters of replies reserved ahead in the state file, the
+// replies after a restart are sealed by the counters past the ones reserved
+const SeqLease = 1024
+
+// state of the counters saved in a file
+type state struct {
+	// Windows is the last counter seen of each device
+	Windows map[string]uint64 `json:"windows"`
+	// Seqs is the counter of replies reserved to each device
+	Seqs map[string]uint64 `json:"seqs"`
+}
+
+// Persist the counters to a file, they are loaded from it first if it exists.
+// The frames of devices by a counter not later than the one saved are replays
+// once loaded, and the counters of replies are reserved in the file before
+// they seal any reply, so a restart reuses none of them
+func (c *Crypt) Persist(path string) error {
+	st := state{}
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(b, &st); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.state = path
+	for device, top := range st.Windows {
+		if w, ok := c.windows[device]; !ok || w.top < top {
+			c.windows[device] = &window{top: top, seen: ^uint64(0)}
+		}
+	}
+	for device, seq := range st.Seqs {
+		if seq > c.seqs[device] {
+			c.seqs[device] = seq
+		}
+		c.leases[device] = c.seqs[device]
+	}
+	return nil
+}
+
+// Save the counters to the file persisted to
+func (c *Crypt) Save() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.save()
+}
+
+// save the counters, the caller must hold the lock
+func (c *Crypt) save() error {
+	if len(c.state) == 0 {
+		return nil
+	}
+	st := state{
+		Windows: make(map[string]uint64, len(c.windows)),
+		Seqs:    c.leases,
+	}
+	for device, w := range c.windows {
+		st.Windows[device] = w.top
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := c.state + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.state); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// reserve the counters of replies to a device up to the one sealing a reply,
+// the caller must hold the lock
+func (c *Crypt) reserve(device string, seq uint64) error {
+	if len(c.state) == 0 || seq <= c.leases[device] {
+		return nil
+	}
+	lease := c.leases[device]
+	c.leases[device] = seq + SeqLease - 1
+	if err := c.save(); err != nil {
+		c.leases[device] = lease
+		return err
+	}
+	return nil
+}
+
+// Start saving the counters at every interval once they change, the frames
+// seen since the last save are accepted again after a crash
+func (c *Crypt) Start(interval time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	if c.exit != nil {
+		return
+	}
+	exit := make(chan bool)
+	c.exit = exit
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-exit:
+				return
+			case <-t.C:
+				c.Lock()
+				var err error
+				if c.dirty {
+					err = c.save()
+				}
+				c.Unlock()
+				if err != nil {
+					log.Errorf("unable to save counters: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop saving at every interval, the counters are saved once more
+func (c *Crypt) Stop() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.exit != nil {
+		close(c.exit)
+		c.exit = nil
+	}
+	return c.save()
+}
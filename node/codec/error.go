@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"encoding/xml"
+
+	"github.com/micro/go-micro/v2/errors"
+)
+
+//ErrorPackage is the frame answering a failed request, the <ERR>
+//element carries the code and detail of error
+type ErrorPackage struct {
+	XMLName xml.Name    `xml:"PROTOCOL"`
+	Version string      `xml:"VER,omitempty"`
+	Name    string      `xml:"NAME,omitempty"`
+	Type    string      `xml:"TYPE"`
+	Err     ErrorDetail `xml:"ERR"`
+}
+
+//ErrorDetail of ErrorPackage
+type ErrorDetail struct {
+	Code   int32  `xml:"CODE"`
+	Detail string `xml:"DETAIL"`
+}
+
+//EncodeError encodes an error into a xml <ERR> frame, the packet type
+//of request is echoed so that a device can match the answer
+func EncodeError(hdr map[string]string, err error) ([]byte, error) {
+	merr, ok := err.(*errors.Error)
+	if !ok {
+		merr = errors.Parse(err.Error())
+	}
+
+	pkg := ErrorPackage{
+		Version: hdr["VER"],
+		Name:    hdr["NAME"],
+		Type:    hdr["TYPE"],
+		Err: ErrorDetail{
+			Code:   merr.Code,
+			Detail: merr.Detail,
+		},
+	}
+
+	out, err := Marshaler{}.Marshal(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
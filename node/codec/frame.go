@@ -0,0 +1,16 @@
+package codec
+
+import (
+	"github.com/micro/go-micro/v2/codec"
+)
+
+//FrameCodec is a codec transforming the frames of the codec it wraps, such as
+//decrypting and encrypting them. The frames the node server reads and writes
+//raw, and its error frames, go through it as well
+type FrameCodec interface {
+	codec.Codec
+	// Frame returns the frame last read by ReadHeader, transformed back
+	Frame() []byte
+	// Seal transforms a frame to write, hdr is the one of message written
+	Seal(hdr map[string]string, frame []byte) ([]byte, error)
+}
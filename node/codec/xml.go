@@ -37,7 +37,7 @@ type XMLBasicPackge struct {
 	Version string `xml:"VER"`
 	Name    string `xml:"NAME"`
 	Gender  string `xml:"GENDER"`
-	Type    string `xml:"Type"`
+	Type    string `xml:"TYPE"`
 }
 
 //Codec for xml
@@ -89,6 +89,7 @@ func (c *Codec) ReadHeader(m *codec.Message, t codec.MessageType) error {
 	m.Header["VER"] = basicInfo.Version
 	m.Header["NAME"] = basicInfo.Name
 	m.Header["GENDER"] = basicInfo.Gender
+	m.Header["TYPE"] = basicInfo.Type
 
 	m.Target = "ProtocolServer"
 	m.Endpoint = "protocol/" + basicInfo.Type
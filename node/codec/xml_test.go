@@ -1,9 +1,11 @@
 package codec
 
 import (
+	"encoding/xml"
 	"testing"
 
 	"github.com/micro/go-micro/v2/codec"
+	"github.com/micro/go-micro/v2/errors"
 )
 
 var srcbytes = []byte(`<?xml version="1.0" encoding="gb2312"?>
@@ -32,3 +34,40 @@ func TestReadHeader(t *testing.T) {
 	t.Log("Done")
 
 }
+
+func TestEncodeError(t *testing.T) {
+	hdr := map[string]string{"VER": "1.0", "NAME": "danny", "TYPE": "9"}
+
+	b, err := EncodeError(hdr, errors.NotFound("node.router", "unknown packet type %q", "9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := ErrorPackage{}
+	if err := (Marshaler{}).Unmarshal(b, &pkg); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Type != "9" || pkg.Name != "danny" || pkg.Err.Code != 404 || pkg.Err.Detail != `unknown packet type "9"` {
+		t.Fatalf("Unexpected error frame %s", b)
+	}
+}
+
+func TestConvertMap(t *testing.T) {
+	m, err := ToMap([]byte(`<?xml version="1.0" encoding="gb2312"?>
+<PROTOCOL><NAME>meter-42</NAME><ITEM>1</ITEM><ITEM>2</ITEM><LOC><HALL>3</HALL></LOC></PROTOCOL>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["NAME"] != "meter-42" || len(m["ITEM"].([]interface{})) != 2 || m["LOC"].(map[string]interface{})["HALL"] != "3" {
+		t.Fatalf("Unexpected map %v", m)
+	}
+
+	b, err := FromMap("PROTOCOL", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := xml.Header + "<PROTOCOL><ITEM>1</ITEM><ITEM>2</ITEM><LOC><HALL>3</HALL></LOC><NAME>meter-42</NAME></PROTOCOL>"
+	if string(b) != expected {
+		t.Fatalf("Expected %s, got %s", expected, b)
+	}
+}
@@ -45,6 +45,11 @@ func (rwc *ReadWriteCloser) Reset() error {
 	return nil
 }
 
+//ResetWbuf only reset WriterBuffer
+func (rwc *ReadWriteCloser) ResetWbuf() {
+	rwc.wbuf.Reset()
+}
+
 //String ...
 func (rwc *ReadWriteCloser) String() string {
 	return "ReadWriteCloser"
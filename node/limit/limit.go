@@ -0,0 +1,271 @@
+// Package limit throttles the frames of devices by token buckets per device,
+// per remote IP and per packet type
+package limit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+)
+
+// The limits a frame is checked by
+const (
+	LimitDevice = "device"
+	LimitRemote = "remote"
+	LimitType   = "type"
+)
+
+// AnyType is the key of Types limiting every packet type without a rate of its own
+const AnyType = "*"
+
+// DefaultIdle is the time a bucket is kept unused
+var DefaultIdle = 10 * time.Minute
+
+// DefaultMaxBuckets is the buckets kept at most
+var DefaultMaxBuckets = 100000
+
+// Rate of a token bucket, a zero Rate is unlimited
+type Rate struct {
+	// PerSecond is the frames refilled per second
+	PerSecond float64
+	// Burst is the frames allowed at once, at least 1
+	Burst int
+}
+
+// Config of limiter
+type Config struct {
+	// Action of the frames over the limit
+	Action nserver.LimitAction
+	// MaxDelay of LimitDelay, the frames to delay longer are dropped
+	MaxDelay time.Duration
+	// Idle is the time a bucket is kept unused, DefaultIdle if zero
+	Idle time.Duration
+	// MaxBuckets is the buckets kept at most, DefaultMaxBuckets if zero, the
+	// frames needing one more are throttled until the idle ones expire
+	MaxBuckets int
+
+	// Device limits the frames of each device, by the account of connection
+	// authenticated, otherwise by the remote address the frames come from,
+	// a device could claim any id to dodge its limit
+	Device Rate
+	// Remote limits the frames of each remote IP
+	Remote Rate
+	// Types limit the frames of each packet type across devices
+	Types map[string]Rate
+}
+
+// Stats of the frames throttled
+type Stats struct {
+	// Throttled by limit
+	Throttled map[string]uint64
+	// Keys are the frames throttled by "limit:key" such as "device:meter-42",
+	// the keys of buckets expired are gone
+	Keys map[string]uint64
+	// Buckets in use
+	Buckets int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// throttled frames
+	throttled uint64
+}
+
+// Limiter keeps the buckets of devices, remote IPs and packet types
+type Limiter struct {
+	config Config
+	now    func() time.Time
+
+	sync.Mutex
+	buckets   map[string]*bucket
+	throttled map[string]uint64
+	sweptAt   time.Time
+}
+
+// New returns the limiter of a config
+func New(c Config) (*Limiter, error) {
+	switch c.Action {
+	case "":
+		c.Action = nserver.LimitDrop
+	case nserver.LimitDrop, nserver.LimitBusy, nserver.LimitDelay, nserver.LimitDisconnect:
+	default:
+		return nil, fmt.Errorf("unknown action %q", c.Action)
+	}
+	if c.Idle <= 0 {
+		c.Idle = DefaultIdle
+	}
+	if c.MaxBuckets <= 0 {
+		c.MaxBuckets = DefaultMaxBuckets
+	}
+	for _, r := range append([]Rate{c.Device, c.Remote}, ratesOf(c.Types)...) {
+		if r.PerSecond < 0 || r.Burst < 0 {
+			return nil, fmt.Errorf("negative rate %+v", r)
+		}
+	}
+	return &Limiter{
+		config:    c,
+		now:       time.Now,
+		buckets:   make(map[string]*bucket),
+		throttled: make(map[string]uint64),
+	}, nil
+}
+
+func ratesOf(m map[string]Rate) []Rate {
+	rates := make([]Rate, 0, len(m))
+	for _, r := range m {
+		rates = append(rates, r)
+	}
+	return rates
+}
+
+// check of a bucket for a frame
+type check struct {
+	limit, key string
+	rate       Rate
+}
+
+// Limit decides the throttle of a frame, it is a nserver.Limiter
+func (l *Limiter) Limit(ctx context.Context, a nserver.Access) *nserver.Throttle {
+	checks := make([]check, 0, 3)
+	switch {
+	case a.Account != nil && len(a.Account.ID) > 0:
+		checks = append(checks, check{LimitDevice, a.Account.ID, l.config.Device})
+	case len(a.Remote) > 0:
+		checks = append(checks, check{LimitDevice, a.Remote, l.config.Device})
+	case len(a.Header["NAME"]) > 0:
+		checks = append(checks, check{LimitDevice, a.Header["NAME"], l.config.Device})
+	}
+	if len(a.Remote) > 0 {
+		ip := a.Remote
+		if host, _, err := net.SplitHostPort(a.Remote); err == nil {
+			ip = host
+		}
+		checks = append(checks, check{LimitRemote, ip, l.config.Remote})
+	}
+	if rate, ok := l.config.Types[a.Type]; ok {
+		checks = append(checks, check{LimitType, a.Type, rate})
+	} else if rate, ok := l.config.Types[AnyType]; ok && len(a.Type) > 0 {
+		checks = append(checks, check{LimitType, a.Type, rate})
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	// the frame takes a token of every bucket, or none of them
+	var exceeded *check
+	var exceededBucket *bucket
+	var wait time.Duration
+	buckets := make([]*bucket, len(checks))
+	for i := range checks {
+		c := &checks[i]
+		if c.rate.PerSecond <= 0 {
+			continue
+		}
+		b := l.refill(c, now)
+		if b == nil {
+			// no bucket is left for the key, the frame is not delayed either
+			l.throttled[c.limit]++
+			t := &nserver.Throttle{Action: l.config.Action, Limit: c.limit}
+			if t.Action == nserver.LimitDelay {
+				t.Action = nserver.LimitDrop
+			}
+			return t
+		}
+		buckets[i] = b
+		if b.tokens >= 1 {
+			continue
+		}
+		if w := time.Duration((1 - b.tokens) / c.rate.PerSecond * float64(time.Second)); exceeded == nil || w > wait {
+			exceeded, exceededBucket, wait = c, b, w
+		}
+	}
+
+	if exceeded != nil && (l.config.Action != nserver.LimitDelay || wait > l.config.MaxDelay) {
+		exceededBucket.throttled++
+		l.throttled[exceeded.limit]++
+		t := &nserver.Throttle{Action: l.config.Action, Limit: exceeded.limit}
+		// a frame not delayed long enough is dropped
+		if t.Action == nserver.LimitDelay {
+			t.Action = nserver.LimitDrop
+		}
+		return t
+	}
+
+	// the frame delayed reserves its tokens ahead
+	for _, b := range buckets {
+		if b != nil {
+			b.tokens--
+		}
+	}
+	if exceeded != nil {
+		exceededBucket.throttled++
+		l.throttled[exceeded.limit]++
+		return &nserver.Throttle{Action: nserver.LimitDelay, Delay: wait, Limit: exceeded.limit}
+	}
+	return nil
+}
+
+// refill the bucket of a check by the time passed, nil once the buckets are
+// all in use
+func (l *Limiter) refill(c *check, now time.Time) *bucket {
+	burst := float64(c.rate.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	key := c.limit + ":" + c.key
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.config.MaxBuckets {
+			return nil
+		}
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed.Seconds()*c.rate.PerSecond)
+		b.last = now
+	}
+	return b
+}
+
+// sweep the buckets unused for the idle time, once per idle time at most
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < l.config.Idle {
+		return
+	}
+	l.sweptAt = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.config.Idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Stats returns the frames throttled so far
+func (l *Limiter) Stats() Stats {
+	l.Lock()
+	defer l.Unlock()
+	s := Stats{
+		Throttled: make(map[string]uint64, len(l.throttled)),
+		Keys:      make(map[string]uint64),
+		Buckets:   len(l.buckets),
+	}
+	for k, v := range l.throttled {
+		s.Throttled[k] = v
+	}
+	for k, b := range l.buckets {
+		if b.throttled > 0 {
+			s.Keys[k] = b.throttled
+		}
+	}
+	return s
+}
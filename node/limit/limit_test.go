@@ -0,0 +1,169 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro/go-micro/v2/auth"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestLimiter(t *testing.T, c Config) (*Limiter, *clock) {
+	l, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := &clock{t: time.Unix(1600000000, 0)}
+	l.now = clk.now
+	return l, clk
+}
+
+func access(device, remote, packetType string) nserver.Access {
+	return nserver.Access{
+		Direction: nserver.Uplink,
+		Type:      packetType,
+		Header:    map[string]string{"NAME": device},
+		Remote:    remote,
+	}
+}
+
+func TestLimitDevice(t *testing.T) {
+	l, clk := newTestLimiter(t, Config{
+		Action: nserver.LimitBusy,
+		Device: Rate{PerSecond: 1, Burst: 2},
+	})
+
+	for i := 0; i < 2; i++ {
+		if th := l.Limit(context.TODO(), access("meter-42", "10.0.0.1:4000", "1")); th != nil {
+			t.Fatalf("Expected frame %d within burst, got %+v", i, th)
+		}
+	}
+	th := l.Limit(context.TODO(), access("meter-42", "10.0.0.1:4000", "1"))
+	if th == nil || th.Action != nserver.LimitBusy || th.Limit != LimitDevice {
+		t.Fatalf("Expected busy by device limit, got %+v", th)
+	}
+	// the devices not authenticated are limited by their connections
+	if th := l.Limit(context.TODO(), access("meter-43", "10.0.0.1:4000", "1")); th == nil {
+		t.Fatal("Expected meter-43 of the same connection over limit")
+	}
+	if th := l.Limit(context.TODO(), access("meter-43", "10.0.0.2:4000", "1")); th != nil {
+		t.Fatalf("Expected meter-43 within limit, got %+v", th)
+	}
+
+	clk.t = clk.t.Add(time.Second)
+	if th := l.Limit(context.TODO(), access("meter-42", "10.0.0.1:4000", "1")); th != nil {
+		t.Fatalf("Expected token refilled, got %+v", th)
+	}
+
+	s := l.Stats()
+	if s.Throttled[LimitDevice] != 2 || s.Keys["device:10.0.0.1:4000"] != 2 || s.Buckets != 2 {
+		t.Fatalf("Unexpected stats %+v", s)
+	}
+
+	// the buckets unused are expired
+	clk.t = clk.t.Add(DefaultIdle)
+	l.Limit(context.TODO(), access("meter-44", "", ""))
+	if s := l.Stats(); s.Buckets != 1 || len(s.Keys) != 0 {
+		t.Fatalf("Expected buckets expired, got %+v", s)
+	}
+}
+
+func TestLimitRemoteAndType(t *testing.T) {
+	l, _ := newTestLimiter(t, Config{
+		Remote: Rate{PerSecond: 10, Burst: 2},
+		Types: map[string]Rate{
+			"EVENT": {PerSecond: 1, Burst: 1},
+			AnyType: {PerSecond: 100, Burst: 100},
+		},
+	})
+
+	// the devices behind an IP share its bucket
+	l.Limit(context.TODO(), access("meter-1", "10.0.0.1:4000", "1"))
+	l.Limit(context.TODO(), access("meter-2", "10.0.0.1:4001", "1"))
+	th := l.Limit(context.TODO(), access("meter-3", "10.0.0.1:4002", "1"))
+	if th == nil || th.Action != nserver.LimitDrop || th.Limit != LimitRemote {
+		t.Fatalf("Expected drop by remote limit, got %+v", th)
+	}
+
+	// a packet type is limited across devices
+	if th := l.Limit(context.TODO(), access("meter-1", "10.0.0.2:4000", "EVENT")); th != nil {
+		t.Fatalf("Expected EVENT within limit, got %+v", th)
+	}
+	th = l.Limit(context.TODO(), access("meter-2", "10.0.0.3:4000", "EVENT"))
+	if th == nil || th.Limit != LimitType {
+		t.Fatalf("Expected drop by type limit, got %+v", th)
+	}
+	// the frame throttled takes no token of the other buckets
+	if th := l.Limit(context.TODO(), access("meter-2", "10.0.0.3:4000", "2")); th != nil {
+		t.Fatalf("Expected frame of other type within limit, got %+v", th)
+	}
+}
+
+func TestLimitDelay(t *testing.T) {
+	l, _ := newTestLimiter(t, Config{
+		Action:   nserver.LimitDelay,
+		MaxDelay: time.Second,
+		Device:   Rate{PerSecond: 2, Burst: 1},
+	})
+
+	l.Limit(context.TODO(), access("meter-42", "", ""))
+	th := l.Limit(context.TODO(), access("meter-42", "", ""))
+	if th == nil || th.Action != nserver.LimitDelay || th.Delay != 500*time.Millisecond {
+		t.Fatalf("Expected delay of 500ms, got %+v", th)
+	}
+	// the token is reserved by the frame delayed
+	th = l.Limit(context.TODO(), access("meter-42", "", ""))
+	if th == nil || th.Action != nserver.LimitDelay || th.Delay != time.Second {
+		t.Fatalf("Expected delay of 1s, got %+v", th)
+	}
+	// the frames to delay over MaxDelay are dropped
+	th = l.Limit(context.TODO(), access("meter-42", "", ""))
+	if th == nil || th.Action != nserver.LimitDrop {
+		t.Fatalf("Expected drop over max delay, got %+v", th)
+	}
+
+	if _, err := New(Config{Action: "queue"}); err == nil {
+		t.Fatal("Expected unknown action refused")
+	}
+}
+
+func TestLimitAccountAndMaxBuckets(t *testing.T) {
+	l, _ := newTestLimiter(t, Config{
+		Action:     nserver.LimitDelay,
+		MaxDelay:   time.Second,
+		MaxBuckets: 2,
+		Device:     Rate{PerSecond: 1, Burst: 1},
+	})
+
+	// a device authenticated is limited by its account across connections
+	a := access("meter-42", "10.0.0.1:4000", "1")
+	a.Account = &auth.Account{ID: "meter-42"}
+	if th := l.Limit(context.TODO(), a); th != nil {
+		t.Fatalf("Expected frame within limit, got %+v", th)
+	}
+	a.Remote = "10.0.0.2:4000"
+	if th := l.Limit(context.TODO(), a); th == nil || th.Action != nserver.LimitDelay {
+		t.Fatalf("Expected frame of meter-42 delayed, got %+v", th)
+	}
+
+	// the frames needing a bucket over the max are dropped
+	if th := l.Limit(context.TODO(), access("meter-43", "10.0.0.3:4000", "1")); th != nil {
+		t.Fatalf("Expected frame within limit, got %+v", th)
+	}
+	th := l.Limit(context.TODO(), access("meter-44", "10.0.0.4:4000", "1"))
+	if th == nil || th.Action != nserver.LimitDrop || th.Limit != LimitDevice {
+		t.Fatalf("Expected frame dropped without bucket, got %+v", th)
+	}
+	if s := l.Stats(); s.Buckets != 2 {
+		t.Fatalf("Expected 2 buckets at most, got %+v", s)
+	}
+}
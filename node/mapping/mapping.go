@@ -0,0 +1,228 @@
+// Package mapping converts the structs decoded from device frames into
+// proto messages by the field tags of device structs or by a mapping file
+package mapping
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	mconfig "github.com/micro/go-micro/v2/config"
+	"github.com/micro/go-micro/v2/config/source/file"
+)
+
+// TagName is the tag of device struct fields, e.g. `map:"Time,trim,timestamp=20060102150405"`
+// maps the field to Time of proto message, "-" leaves a field unmapped on purpose
+const TagName = "map"
+
+// Rule maps a field of device struct to a field of proto message
+type Rule struct {
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Transforms []string `json:"transforms"`
+}
+
+// Mapping is a validated set of rules between a device struct and a proto message
+type Mapping struct {
+	src    reflect.Type
+	dst    reflect.Type
+	fields []field
+	// fields mapped by none of the rules
+	unmappedSrc []string
+	unmappedDst []string
+}
+
+type field struct {
+	rule       Rule
+	from       int
+	to         int
+	transforms []transform
+}
+
+// New validates the rules between the types of src and dst
+func New(src, dst interface{}, rules ...Rule) (*Mapping, error) {
+	return compile(src, dst, rules, nil)
+}
+
+// FromTags builds the rules from the map tags of src fields
+func FromTags(src, dst interface{}) (*Mapping, error) {
+	st := structType(src)
+	if st == nil {
+		return nil, fmt.Errorf("mapping source %T is not a struct", src)
+	}
+
+	var rules []Rule
+	ignored := make(map[string]bool)
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		tag, ok := f.Tag.Lookup(TagName)
+		if !ok {
+			continue
+		}
+		if tag == "-" {
+			ignored[f.Name] = true
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		rule := Rule{From: f.Name, To: parts[0], Transforms: parts[1:]}
+		if len(rule.To) == 0 {
+			rule.To = f.Name
+		}
+		rules = append(rules, rule)
+	}
+	return compile(src, dst, rules, ignored)
+}
+
+// Load reads the rules from a mapping file, such as a toml file of
+//
+//	[[fields]]
+//	  from = "Time"
+//	  to = "Time"
+//	  transforms = ["trim", "timestamp=20060102150405"]
+func Load(path string, src, dst interface{}) (*Mapping, error) {
+	conf, err := mconfig.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := conf.Load(file.NewSource(file.WithPath(path))); err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := conf.Get("fields").Scan(&rules); err != nil {
+		return nil, err
+	}
+	return New(src, dst, rules...)
+}
+
+// Must panics if a mapping is invalid, so wiring mistakes fail at startup
+func Must(m *Mapping, err error) *Mapping {
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func structType(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+func compile(src, dst interface{}, rules []Rule, ignored map[string]bool) (*Mapping, error) {
+	st, dt := structType(src), structType(dst)
+	if st == nil {
+		return nil, fmt.Errorf("mapping source %T is not a struct", src)
+	}
+	if dt == nil {
+		return nil, fmt.Errorf("mapping target %T is not a struct", dst)
+	}
+
+	m := &Mapping{src: st, dst: dt}
+	var errs []string
+	mappedSrc := make(map[string]bool)
+	mappedDst := make(map[string]bool)
+
+	for _, r := range rules {
+		sf, ok := st.FieldByName(r.From)
+		if !ok || len(sf.Index) != 1 || sf.PkgPath != "" {
+			errs = append(errs, fmt.Sprintf("%s has no field %s", st, r.From))
+			continue
+		}
+		df, ok := dt.FieldByName(r.To)
+		if !ok || len(df.Index) != 1 || df.PkgPath != "" {
+			errs = append(errs, fmt.Sprintf("%s has no field %s", dt, r.To))
+			continue
+		}
+		if mappedDst[r.To] {
+			errs = append(errs, fmt.Sprintf("%s.%s is mapped twice", dt, r.To))
+			continue
+		}
+
+		f := field{rule: r, from: sf.Index[0], to: df.Index[0]}
+		typ := sf.Type
+		var err error
+		for _, name := range r.Transforms {
+			var t transform
+			if t, typ, err = newTransform(name, typ); err != nil {
+				break
+			}
+			f.transforms = append(f.transforms, t)
+		}
+		if err == nil {
+			err = checkAssign(typ, df.Type)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s -> %s: %v", r.From, r.To, err))
+			continue
+		}
+
+		mappedSrc[r.From] = true
+		mappedDst[r.To] = true
+		m.fields = append(m.fields, f)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid mapping %s -> %s: %s", st, dt, strings.Join(errs, "; "))
+	}
+
+	m.unmappedSrc = unmapped(st, mappedSrc, ignored)
+	m.unmappedDst = unmapped(dt, mappedDst, nil)
+	return m, nil
+}
+
+// unmapped returns the exported fields mapped by no rule, the XXX_ fields
+// of proto messages are not counted
+func unmapped(t reflect.Type, mapped, ignored map[string]bool) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Anonymous || strings.HasPrefix(f.Name, "XXX_") {
+			continue
+		}
+		if f.Type == reflect.TypeOf(struct{}{}) || f.Name == "XMLName" {
+			continue
+		}
+		if !mapped[f.Name] && !ignored[f.Name] {
+			names = append(names, f.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Unmapped reports the fields of device struct and of proto message mapped by no rule
+func (m *Mapping) Unmapped() (src []string, dst []string) {
+	return m.unmappedSrc, m.unmappedDst
+}
+
+// Map converts src into dst by the rules
+func (m *Mapping) Map(src, dst interface{}) error {
+	sv := reflect.Indirect(reflect.ValueOf(src))
+	if sv.Type() != m.src {
+		return fmt.Errorf("mapping of %s cannot map %T", m.src, src)
+	}
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Type() != m.dst {
+		return fmt.Errorf("mapping to *%s cannot map to %T", m.dst, dst)
+	}
+	dv = dv.Elem()
+
+	for _, f := range m.fields {
+		v := sv.Field(f.from)
+		var err error
+		for _, t := range f.transforms {
+			if v, err = t(v); err != nil {
+				return fmt.Errorf("%s -> %s: %v", f.rule.From, f.rule.To, err)
+			}
+		}
+		if err := assign(dv.Field(f.to), v); err != nil {
+			return fmt.Errorf("%s -> %s: %v", f.rule.From, f.rule.To, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package mapping
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/micro-community/x-edge/proto/protocol"
+)
+
+type meterPackage struct {
+	Version string `xml:"VER" map:"Ver,trim"`
+	Name    string `xml:"NAME" map:"Name,trim,upper"`
+	Type    string `xml:"TYPE" map:"Type"`
+	Addr    string `xml:"ADDR" map:"Addr"`
+	Time    string `xml:"TIME" map:"Time,timestamp=20060102150405"`
+	Debug   string `xml:"DEBUG" map:"-"`
+	Serial  string `xml:"SERIAL"`
+}
+
+type reading struct {
+	Meter string
+	Kwh   float64
+	Count uint32
+	At    int64
+}
+
+func TestFromTags(t *testing.T) {
+	m, err := FromTags(meterPackage{}, protocol.Message{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg protocol.Message
+	pkg := meterPackage{Version: " 1.0 ", Name: "meter-42", Type: "1", Addr: "hall", Time: "20200601120000"}
+	if err := m.Map(&pkg, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Ver != "1.0" || msg.Name != "METER-42" || msg.Type != "1" || msg.Addr != "hall" {
+		t.Fatalf("Unexpected message %+v", msg)
+	}
+	if msg.Time != "2020-06-01T12:00:00Z" {
+		t.Fatalf("Expected time 2020-06-01T12:00:00Z, got %s", msg.Time)
+	}
+
+	src, dst := m.Unmapped()
+	if !reflect.DeepEqual(src, []string{"Serial"}) {
+		t.Fatalf("Expected unmapped source [Serial], got %v", src)
+	}
+	if !reflect.DeepEqual(dst, []string{"Company", "Gender", "Phone"}) {
+		t.Fatalf("Expected unmapped target [Company Gender Phone], got %v", dst)
+	}
+
+	if err := m.Map(&meterPackage{Time: "yesterday"}, &msg); err == nil {
+		t.Fatal("Expected error of bad timestamp")
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	m, err := New(meterPackage{}, reading{},
+		Rule{From: "Name", To: "Meter"},
+		Rule{From: "Addr", To: "Kwh", Transforms: []string{"number"}},
+		Rule{From: "Type", To: "Count", Transforms: []string{"trim", "number"}},
+		Rule{From: "Time", To: "At", Transforms: []string{"timestamp=20060102150405"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r reading
+	if err := m.Map(meterPackage{Name: "meter-42", Addr: "12.5", Type: " 3 ", Time: "20200601120000"}, &r); err != nil {
+		t.Fatal(err)
+	}
+	expected := reading{Meter: "meter-42", Kwh: 12.5, Count: 3, At: 1591012800}
+	if r != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, r)
+	}
+
+	if err := m.Map(meterPackage{Addr: "1", Type: "-3"}, &r); err == nil {
+		t.Fatal("Expected error of negative count")
+	}
+}
+
+func TestInvalidMapping(t *testing.T) {
+	tests := []struct {
+		rule   Rule
+		reason string
+	}{
+		{Rule{From: "Nmae", To: "Name"}, "has no field Nmae"},
+		{Rule{From: "Name", To: "Nmae"}, "has no field Nmae"},
+		{Rule{From: "Name", To: "Name", Transforms: []string{"reverse"}}, "unknown transform"},
+		{Rule{From: "Name", To: "Name", Transforms: []string{"number", "trim"}}, "trim takes a string"},
+		{Rule{From: "Name", To: "Kwh"}, "cannot assign string to float64"},
+	}
+
+	for _, tt := range tests {
+		dst := interface{}(protocol.Message{})
+		if tt.rule.To == "Kwh" {
+			dst = reading{}
+		}
+		_, err := New(meterPackage{}, dst, tt.rule)
+		if err == nil || !strings.Contains(err.Error(), tt.reason) {
+			t.Fatalf("Expected error of %s, got %v", tt.reason, err)
+		}
+	}
+
+	if _, err := New(meterPackage{}, protocol.Message{},
+		Rule{From: "Name", To: "Name"}, Rule{From: "Addr", To: "Name"}); err == nil {
+		t.Fatal("Expected error of field mapped twice")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mapping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mapping.toml")
+	ioutil.WriteFile(path, []byte(`
+[[fields]]
+  from = "Name"
+  to = "Name"
+  transforms = ["trim"]
+[[fields]]
+  from = "Addr"
+  to = "Phone"
+`), 0644)
+
+	m, err := Load(path, meterPackage{}, protocol.Message{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg protocol.Message
+	if err := m.Map(&meterPackage{Name: " meter-42 ", Addr: "555"}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Name != "meter-42" || msg.Phone != "555" {
+		t.Fatalf("Unexpected message %+v", msg)
+	}
+}
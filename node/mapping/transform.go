@@ -0,0 +1,164 @@
+package mapping
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transform turns the value of a field before it is assigned
+type transform func(v reflect.Value) (reflect.Value, error)
+
+var (
+	stringType = reflect.TypeOf("")
+	floatType  = reflect.TypeOf(float64(0))
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+// newTransform returns a transform by name and the type it turns a value of in into:
+//
+//	trim               trims the spaces of a string
+//	upper, lower       changes the case of a string
+//	number             parses a string as a number
+//	timestamp=layout   parses a string as a time of layout
+//	format=layout      formats a time as a string of layout
+func newTransform(name string, in reflect.Type) (transform, reflect.Type, error) {
+	arg := ""
+	if i := strings.Index(name, "="); i >= 0 {
+		name, arg = name[:i], name[i+1:]
+	}
+
+	switch name {
+	case "trim", "upper", "lower":
+		if in.Kind() != reflect.String {
+			return nil, nil, fmt.Errorf("%s takes a string, not %s", name, in)
+		}
+		fn := map[string]func(string) string{
+			"trim":  strings.TrimSpace,
+			"upper": strings.ToUpper,
+			"lower": strings.ToLower,
+		}[name]
+		return func(v reflect.Value) (reflect.Value, error) {
+			return reflect.ValueOf(fn(v.String())), nil
+		}, stringType, nil
+	case "number":
+		if in.Kind() != reflect.String {
+			return nil, nil, fmt.Errorf("number takes a string, not %s", in)
+		}
+		return func(v reflect.Value) (reflect.Value, error) {
+			n, err := strconv.ParseFloat(strings.TrimSpace(v.String()), 64)
+			if err != nil {
+				return v, fmt.Errorf("not a number %q", v.String())
+			}
+			return reflect.ValueOf(n), nil
+		}, floatType, nil
+	case "timestamp":
+		if in.Kind() != reflect.String {
+			return nil, nil, fmt.Errorf("timestamp takes a string, not %s", in)
+		}
+		if len(arg) == 0 {
+			arg = time.RFC3339
+		}
+		return func(v reflect.Value) (reflect.Value, error) {
+			t, err := time.Parse(arg, strings.TrimSpace(v.String()))
+			if err != nil {
+				return v, fmt.Errorf("not a timestamp of %s %q", arg, v.String())
+			}
+			return reflect.ValueOf(t), nil
+		}, timeType, nil
+	case "format":
+		if in != timeType {
+			return nil, nil, fmt.Errorf("format takes a time, not %s", in)
+		}
+		if len(arg) == 0 {
+			arg = time.RFC3339
+		}
+		return func(v reflect.Value) (reflect.Value, error) {
+			return reflect.ValueOf(v.Interface().(time.Time).Format(arg)), nil
+		}, stringType, nil
+	}
+	return nil, nil, fmt.Errorf("unknown transform %q", name)
+}
+
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// checkAssign reports whether a value of from can be assigned to a field of to
+func checkAssign(from, to reflect.Type) error {
+	switch {
+	case from.AssignableTo(to):
+	case from.ConvertibleTo(to) && from.Kind() == to.Kind():
+	case isNumber(from) && (isNumber(to) || to.Kind() == reflect.String):
+	case from == timeType && (to.Kind() == reflect.String || to.Kind() == reflect.Int64):
+	default:
+		return fmt.Errorf("cannot assign %s to %s", from, to)
+	}
+	return nil
+}
+
+// assign v to the field, numbers are converted with range checks
+// and a time is assigned as RFC3339 or as unix seconds
+func assign(f reflect.Value, v reflect.Value) error {
+	t := f.Type()
+	switch {
+	case v.Type().AssignableTo(t):
+		f.Set(v)
+	case v.Type() == timeType:
+		tm := v.Interface().(time.Time)
+		if t.Kind() == reflect.String {
+			f.SetString(tm.Format(time.RFC3339))
+		} else {
+			f.SetInt(tm.Unix())
+		}
+	case v.Type().ConvertibleTo(t) && v.Kind() == t.Kind():
+		f.Set(v.Convert(t))
+	default:
+		return assignNumber(f, v)
+	}
+	return nil
+}
+
+func assignNumber(f reflect.Value, v reflect.Value) error {
+	var n float64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	default:
+		n = v.Float()
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(strconv.FormatFloat(n, 'f', -1, 64))
+	case reflect.Float32, reflect.Float64:
+		if f.OverflowFloat(n) {
+			return fmt.Errorf("%v overflows %s", n, f.Type())
+		}
+		f.SetFloat(n)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n != math.Trunc(n) || f.OverflowInt(int64(n)) {
+			return fmt.Errorf("%v is not a %s", n, f.Type())
+		}
+		f.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || n != math.Trunc(n) || f.OverflowUint(uint64(n)) {
+			return fmt.Errorf("%v is not a %s", n, f.Type())
+		}
+		f.SetUint(uint64(n))
+	default:
+		return fmt.Errorf("cannot assign %s to %s", v.Type(), f.Type())
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"github.com/micro-community/x-edge/node/limit"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	throttledDesc = prometheus.NewDesc(Namespace+"_limit_throttled_total",
+		"Frames throttled by limit.", []string{"limit"}, nil)
+	bucketsDesc = prometheus.NewDesc(Namespace+"_limit_buckets",
+		"Token buckets in use.", nil, nil)
+)
+
+// limiterCollector collects the stats of a limiter
+type limiterCollector struct {
+	l *limit.Limiter
+}
+
+// Limiter returns the collector of the frames throttled by a limiter by
+// limit, the devices, remote IPs and packet types of its buckets are no
+// labels, the devices could make countless ones
+func Limiter(l *limit.Limiter) prometheus.Collector {
+	return &limiterCollector{l: l}
+}
+
+func (c *limiterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- throttledDesc
+	ch <- bucketsDesc
+}
+
+func (c *limiterCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.l.Stats()
+	for name, n := range s.Throttled {
+		ch <- prometheus.MustNewConstMetric(throttledDesc, prometheus.CounterValue, float64(n), name)
+	}
+	ch <- prometheus.MustNewConstMetric(bucketsDesc, prometheus.GaugeValue, float64(s.Buckets))
+}
@@ -0,0 +1,159 @@
+// Package metrics counts what the node server, its transport and router do
+// as Prometheus metrics
+package metrics
+
+import (
+	"time"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace of the metrics
+const Namespace = "edge"
+
+// Metrics of the node server of a transport, it is a nserver.Observer
+type Metrics struct {
+	connsAccepted prometheus.Counter
+	connsClosed   *prometheus.CounterVec
+	connsOpen     prometheus.Gauge
+
+	framesIn  prometheus.Counter
+	framesOut prometheus.Counter
+	bytesIn   prometheus.Counter
+	bytesOut  prometheus.Counter
+
+	extractErrors prometheus.Counter
+	decodeErrors  prometheus.Counter
+	routingMisses prometheus.Counter
+	replyErrors   *prometheus.CounterVec
+
+	handlerSeconds   *prometheus.HistogramVec
+	handlerErrors    *prometheus.CounterVec
+	handlersInFlight *prometheus.GaugeVec
+}
+
+// New returns the metrics of the node server of a transport such as "tcp",
+// they are labeled by the transport
+func New(transport string) *Metrics {
+	labels := prometheus.Labels{"transport": transport}
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace, Name: name, Help: help, ConstLabels: labels,
+		})
+	}
+	counterVec := func(name, help string, names ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace, Name: name, Help: help, ConstLabels: labels,
+		}, names)
+	}
+
+	return &Metrics{
+		connsAccepted: counter("connections_accepted_total", "Connections of devices accepted."),
+		connsClosed:   counterVec("connections_closed_total", "Connections of devices closed by reason.", "reason"),
+		connsOpen: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace, Name: "connections_open", Help: "Connections of devices open.", ConstLabels: labels,
+		}),
+
+		framesIn:  counter("frames_received_total", "Frames received from devices."),
+		framesOut: counter("frames_sent_total", "Frames sent to devices."),
+		bytesIn:   counter("received_bytes_total", "Bytes of frames received from devices."),
+		bytesOut:  counter("sent_bytes_total", "Bytes of frames sent to devices."),
+
+		extractErrors: counter("extract_errors_total", "Errors of the extractor splitting the frames of connections."),
+		decodeErrors:  counter("decode_errors_total", "Frames the codec failed to decode."),
+		routingMisses: counter("routing_misses_total", "Frames of packet types neither routed nor handled."),
+		replyErrors:   counterVec("reply_errors_total", "Replies and error frames failed to write by handler.", "method"),
+
+		handlerSeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace, Name: "handler_duration_seconds", Help: "Latency of handlers by method.", ConstLabels: labels,
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		handlerErrors: counterVec("handler_errors_total", "Handlers returned an error by method.", "method"),
+		handlersInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace, Name: "handlers_in_flight", Help: "Handlers running by method.", ConstLabels: labels,
+		}, []string{"method"}),
+	}
+}
+
+// Describe the metrics, it is a prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect the metrics, it is a prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.connsAccepted, m.connsClosed, m.connsOpen,
+		m.framesIn, m.framesOut, m.bytesIn, m.bytesOut,
+		m.extractErrors, m.decodeErrors, m.routingMisses, m.replyErrors,
+		m.handlerSeconds, m.handlerErrors, m.handlersInFlight,
+	}
+}
+
+// Presence counts the connections accepted and closed, it is a nserver.PresenceHook
+func (m *Metrics) Presence(ev nserver.PresenceEvent) {
+	switch ev.Type {
+	case nserver.PresenceAccepted:
+		m.connsAccepted.Inc()
+		m.connsOpen.Inc()
+	case nserver.PresenceClosed:
+		m.connsClosed.WithLabelValues(ev.Reason).Inc()
+		m.connsOpen.Dec()
+	}
+}
+
+// FrameIn of bytes received from a device
+func (m *Metrics) FrameIn(bytes int) {
+	m.framesIn.Inc()
+	m.bytesIn.Add(float64(bytes))
+}
+
+// FrameOut of bytes sent to a device
+func (m *Metrics) FrameOut(bytes int) {
+	m.framesOut.Inc()
+	m.bytesOut.Add(float64(bytes))
+}
+
+// ExtractError of the extractor unable to split the frames of a connection
+func (m *Metrics) ExtractError(err error) {
+	m.extractErrors.Inc()
+}
+
+// DecodeError of a frame the codec failed to decode
+func (m *Metrics) DecodeError(err error) {
+	m.decodeErrors.Inc()
+}
+
+// RoutingMiss of a packet type neither routed nor handled, the packet types
+// sent by devices are no label of it, any device could send countless ones
+func (m *Metrics) RoutingMiss(packetType string) {
+	m.routingMisses.Inc()
+}
+
+// HandlerStart of the handler of a frame
+func (m *Metrics) HandlerStart(method string) {
+	m.handlersInFlight.WithLabelValues(method).Inc()
+}
+
+// HandlerEnd of the handler of a frame
+func (m *Metrics) HandlerEnd(method string, took time.Duration, err error) {
+	m.handlersInFlight.WithLabelValues(method).Dec()
+	m.handlerSeconds.WithLabelValues(method).Observe(took.Seconds())
+	if err != nil {
+		m.handlerErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// ReplyError of a reply or error frame failed to write
+func (m *Metrics) ReplyError(method string, err error) {
+	m.replyErrors.WithLabelValues(method).Inc()
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro-community/x-edge/broker/queue"
+	"github.com/micro-community/x-edge/node/limit"
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New("tcp")
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatal(err)
+	}
+
+	var obs nserver.Observer = m
+	m.Presence(nserver.PresenceEvent{Type: nserver.PresenceAccepted})
+	m.Presence(nserver.PresenceEvent{Type: nserver.PresenceAccepted})
+	m.Presence(nserver.PresenceEvent{Type: nserver.PresenceClosed, Reason: nserver.ReasonEOF})
+	obs.FrameIn(100)
+	obs.FrameIn(50)
+	obs.FrameOut(20)
+	obs.DecodeError(errors.New("bad frame"))
+	obs.RoutingMiss("9")
+	obs.HandlerStart("PROTOCOLSERVER.Event")
+	obs.HandlerEnd("PROTOCOLSERVER.Event", 20*time.Millisecond, errors.New("failed"))
+	obs.ReplyError("PROTOCOLSERVER.Event", errors.New("broken pipe"))
+
+	for _, c := range []struct {
+		c    prometheus.Collector
+		want float64
+	}{
+		{m.connsAccepted, 2},
+		{m.connsClosed.WithLabelValues(nserver.ReasonEOF), 1},
+		{m.connsOpen, 1},
+		{m.framesIn, 2},
+		{m.bytesIn, 150},
+		{m.bytesOut, 20},
+		{m.decodeErrors, 1},
+		{m.routingMisses, 1},
+		{m.handlerErrors.WithLabelValues("PROTOCOLSERVER.Event"), 1},
+		{m.handlersInFlight.WithLabelValues("PROTOCOLSERVER.Event"), 0},
+		{m.replyErrors.WithLabelValues("PROTOCOLSERVER.Event"), 1},
+	} {
+		if got := testutil.ToFloat64(c.c); got != c.want {
+			t.Errorf("Expected %v, got %v of %v", c.want, got, c.c)
+		}
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "edge_handler_duration_seconds" {
+			continue
+		}
+		h := mf.GetMetric()[0].GetHistogram()
+		if h.GetSampleCount() != 1 {
+			t.Fatalf("Expected a handler latency, got %v", h)
+		}
+		return
+	}
+	t.Fatal("Expected handler latency gathered")
+}
+
+func TestLimiter(t *testing.T) {
+	l, err := limit.New(limit.Config{Device: limit.Rate{PerSecond: 1, Burst: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		l.Limit(context.TODO(), nserver.Access{Header: map[string]string{"NAME": "meter-42"}})
+	}
+
+	expected := `
+# HELP edge_limit_throttled_total Frames throttled by limit.
+# TYPE edge_limit_throttled_total counter
+edge_limit_throttled_total{limit="device"} 2
+`
+	if err := testutil.CollectAndCompare(Limiter(l), strings.NewReader(expected),
+		"edge_limit_throttled_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// spoolStats of a spool with messages not forwarded
+type spoolStats queue.Stats
+
+func (s spoolStats) Stats() queue.Stats { return queue.Stats(s) }
+
+func TestSpool(t *testing.T) {
+	expected := `
+# HELP edge_spool_depth Messages spooled and not forwarded yet.
+# TYPE edge_spool_depth gauge
+edge_spool_depth 3
+# HELP edge_spool_dropped_total Messages dropped by the bounds of spool.
+# TYPE edge_spool_dropped_total counter
+edge_spool_dropped_total 1
+# HELP edge_spool_size_bytes Bytes of the messages spooled.
+# TYPE edge_spool_size_bytes gauge
+edge_spool_size_bytes 512
+`
+	if err := testutil.CollectAndCompare(Spool(spoolStats{Depth: 3, Size: 512, Dropped: 1}), strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"github.com/micro-community/x-edge/broker/queue"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	spoolDepthDesc = prometheus.NewDesc(Namespace+"_spool_depth",
+		"Messages spooled and not forwarded yet.", nil, nil)
+	spoolSizeDesc = prometheus.NewDesc(Namespace+"_spool_size_bytes",
+		"Bytes of the messages spooled.", nil, nil)
+	spoolDroppedDesc = prometheus.NewDesc(Namespace+"_spool_dropped_total",
+		"Messages dropped by the bounds of spool.", nil, nil)
+)
+
+// SpoolStats is a spool of the messages failed to publish, such as eventbroker.Spool
+type SpoolStats interface {
+	Stats() queue.Stats
+}
+
+// spoolCollector collects the stats of a spool
+type spoolCollector struct {
+	s SpoolStats
+}
+
+// Spool returns the collector of the depth, size and drops of a spool
+func Spool(s SpoolStats) prometheus.Collector {
+	return &spoolCollector{s: s}
+}
+
+func (c *spoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- spoolDepthDesc
+	ch <- spoolSizeDesc
+	ch <- spoolDroppedDesc
+}
+
+func (c *spoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.s.Stats()
+	ch <- prometheus.MustNewConstMetric(spoolDepthDesc, prometheus.GaugeValue, float64(s.Depth))
+	ch <- prometheus.MustNewConstMetric(spoolSizeDesc, prometheus.GaugeValue, float64(s.Size))
+	ch <- prometheus.MustNewConstMetric(spoolDroppedDesc, prometheus.CounterValue, float64(s.Dropped))
+}
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	"github.com/micro/go-micro/v2/client"
+	"github.com/micro/go-micro/v2/codec"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	"github.com/micro/go-micro/v2/errors"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// ForwardEndpoint is the handler endpoint the forwarded packet types are routed to
+const ForwardEndpoint = "Forwarder.Forward"
+
+// Forward routes the frames of a packet type to the endpoint of a go-micro service
+type Forward struct {
+	// Type is the packet type of frames
+	Type string
+	// Service and Endpoint of the go-micro service, e.g.
+	// "go.micro.srv.inventory" and "Inventory.Lookup"
+	Service  string
+	Endpoint string
+	// Timeout of a call, the client default if it is 0
+	Timeout time.Duration
+	// Retries of a failed call
+	Retries int
+	// Fallback is the reply frame when the call fails,
+	// the error frame is answered if it is empty
+	Fallback []byte
+	// ContentType of the call, the frame elements are sent as a json object
+	// by default and "application/octet-stream" forwards the raw frame
+	ContentType string
+}
+
+// Forwarder is a handler calling go-micro services for the frames of packet types,
+// the response of service is encoded back to the device as the reply frame
+type Forwarder struct {
+	client   client.Client
+	forwards map[string]Forward
+}
+
+// NewForwarder creates a forwarder calling services through a go-micro client
+func NewForwarder(c client.Client, forwards ...Forward) *Forwarder {
+	f := &Forwarder{
+		client:   c,
+		forwards: make(map[string]Forward),
+	}
+	for _, fw := range forwards {
+		f.forwards[fw.Type] = fw
+	}
+	return f
+}
+
+// Routes returns the routing table of forwarded packet types
+func (f *Forwarder) Routes() map[string]string {
+	routes := make(map[string]string)
+	for t := range f.forwards {
+		routes[t] = ForwardEndpoint
+	}
+	return routes
+}
+
+// Forward calls the service of the packet type of a frame
+func (f *Forwarder) Forward(ctx context.Context, req *codec.Message, rsp *codec.Message) error {
+	packetType := req.Header["TYPE"]
+	fw, ok := f.forwards[packetType]
+	if !ok {
+		return errors.NotFound("node.forwarder", "no forward of packet type %q", packetType)
+	}
+
+	body, err := f.call(ctx, fw, req.Body)
+	if err != nil {
+		if len(fw.Fallback) == 0 {
+			return err
+		}
+		log.Errorf("forward %s to %s.%s: %v", packetType, fw.Service, fw.Endpoint, err)
+		body = fw.Fallback
+	}
+	rsp.Body = body
+	return nil
+}
+
+func (f *Forwarder) call(ctx context.Context, fw Forward, frame []byte) ([]byte, error) {
+	var opts []client.CallOption
+	if fw.Timeout > 0 {
+		opts = append(opts, client.WithRequestTimeout(fw.Timeout))
+	}
+	if fw.Retries > 0 {
+		opts = append(opts, client.WithRetries(fw.Retries))
+	}
+
+	if fw.ContentType == "application/octet-stream" {
+		rsp := new(raw.Frame)
+		req := f.client.NewRequest(fw.Service, fw.Endpoint, &raw.Frame{Data: frame},
+			client.WithContentType(fw.ContentType))
+		if err := f.client.Call(ctx, req, rsp, opts...); err != nil {
+			return nil, err
+		}
+		return rsp.Data, nil
+	}
+
+	elems, err := xmlc.ToMap(frame)
+	if err != nil {
+		return nil, errors.BadRequest("node.forwarder", "invalid frame: %v", err)
+	}
+	b, err := json.Marshal(elems)
+	if err != nil {
+		return nil, err
+	}
+
+	var rsp json.RawMessage
+	req := f.client.NewRequest(fw.Service, fw.Endpoint, json.RawMessage(b),
+		client.WithContentType("application/json"))
+	if err := f.client.Call(ctx, req, &rsp, opts...); err != nil {
+		return nil, err
+	}
+
+	reply := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(rsp))
+	dec.UseNumber()
+	if err := dec.Decode(&reply); err != nil {
+		return nil, fmt.Errorf("invalid response of %s.%s: %v", fw.Service, fw.Endpoint, err)
+	}
+	// the reply is of the packet type it answers
+	for k := range reply {
+		if strings.EqualFold(k, "TYPE") {
+			return xmlc.FromMap("PROTOCOL", reply)
+		}
+	}
+	reply["TYPE"] = fw.Type
+	return xmlc.FromMap("PROTOCOL", reply)
+}
@@ -0,0 +1,126 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2/client"
+	"github.com/micro/go-micro/v2/codec"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+)
+
+// stubClient answers the calls of services without a network
+type stubClient struct {
+	client.Client
+	calls  int
+	req    client.Request
+	opts   client.CallOptions
+	answer func(req client.Request, rsp interface{}) error
+}
+
+func (c *stubClient) Call(ctx context.Context, req client.Request, rsp interface{}, opts ...client.CallOption) error {
+	c.calls++
+	c.req = req
+	for _, o := range opts {
+		o(&c.opts)
+	}
+	return c.answer(req, rsp)
+}
+
+const queryFrame = `<?xml version="1.0" encoding="gb2312"?>
+<PROTOCOL><NAME>meter-42</NAME><TYPE>QUERY</TYPE><SKU>4711</SKU></PROTOCOL>`
+
+func queryMessage() *codec.Message {
+	return &codec.Message{
+		Header: map[string]string{"TYPE": "QUERY", "NAME": "meter-42"},
+		Body:   []byte(queryFrame),
+	}
+}
+
+func TestForwardJSON(t *testing.T) {
+	c := &stubClient{Client: client.NewClient()}
+	c.answer = func(req client.Request, rsp interface{}) error {
+		var in map[string]string
+		if err := json.Unmarshal(req.Body().(json.RawMessage), &in); err != nil {
+			return err
+		}
+		if in["SKU"] != "4711" || in["NAME"] != "meter-42" {
+			t.Fatalf("Unexpected request %v", in)
+		}
+		*rsp.(*json.RawMessage) = json.RawMessage(`{"sku":"4711","stock":12}`)
+		return nil
+	}
+
+	f := NewForwarder(c, Forward{
+		Type:     "QUERY",
+		Service:  "go.micro.srv.inventory",
+		Endpoint: "Inventory.Lookup",
+		Timeout:  time.Second,
+		Retries:  2,
+	})
+	if r := f.Routes(); r["QUERY"] != ForwardEndpoint {
+		t.Fatalf("Expected route of QUERY to %s, got %v", ForwardEndpoint, r)
+	}
+
+	rsp := new(codec.Message)
+	if err := f.Forward(context.Background(), queryMessage(), rsp); err != nil {
+		t.Fatal(err)
+	}
+	if c.req.Service() != "go.micro.srv.inventory" || c.req.Endpoint() != "Inventory.Lookup" {
+		t.Fatalf("Unexpected call of %s.%s", c.req.Service(), c.req.Endpoint())
+	}
+	if c.opts.RequestTimeout != time.Second || c.opts.Retries != 2 {
+		t.Fatalf("Unexpected call options %+v", c.opts)
+	}
+
+	expected := "<PROTOCOL><SKU>4711</SKU><STOCK>12</STOCK><TYPE>QUERY</TYPE></PROTOCOL>"
+	if !strings.HasSuffix(string(rsp.Body), expected) {
+		t.Fatalf("Expected reply %s, got %s", expected, rsp.Body)
+	}
+}
+
+func TestForwardRaw(t *testing.T) {
+	c := &stubClient{Client: client.NewClient()}
+	c.answer = func(req client.Request, rsp interface{}) error {
+		if string(req.Body().(*raw.Frame).Data) != queryFrame {
+			t.Fatalf("Expected the raw frame, got %v", req.Body())
+		}
+		rsp.(*raw.Frame).Data = []byte("<PROTOCOL><TYPE>QUERY</TYPE></PROTOCOL>")
+		return nil
+	}
+
+	f := NewForwarder(c, Forward{Type: "QUERY", Service: "inventory", Endpoint: "Inventory.Raw", ContentType: "application/octet-stream"})
+	rsp := new(codec.Message)
+	if err := f.Forward(context.Background(), queryMessage(), rsp); err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp.Body) != "<PROTOCOL><TYPE>QUERY</TYPE></PROTOCOL>" {
+		t.Fatalf("Unexpected reply %s", rsp.Body)
+	}
+}
+
+func TestForwardFallback(t *testing.T) {
+	c := &stubClient{Client: client.NewClient()}
+	c.answer = func(req client.Request, rsp interface{}) error {
+		return errors.New("service unavailable")
+	}
+
+	f := NewForwarder(c, Forward{Type: "QUERY", Service: "inventory", Endpoint: "Inventory.Lookup"})
+	if err := f.Forward(context.Background(), queryMessage(), new(codec.Message)); err == nil {
+		t.Fatal("Expected error without fallback")
+	}
+
+	fallback := "<PROTOCOL><TYPE>QUERY</TYPE><ERR>unavailable</ERR></PROTOCOL>"
+	f = NewForwarder(c, Forward{Type: "QUERY", Service: "inventory", Endpoint: "Inventory.Lookup", Fallback: []byte(fallback)})
+	rsp := new(codec.Message)
+	if err := f.Forward(context.Background(), queryMessage(), rsp); err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp.Body) != fallback {
+		t.Fatalf("Expected fallback %s, got %s", fallback, rsp.Body)
+	}
+}
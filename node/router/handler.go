@@ -7,22 +7,36 @@ import (
 
 	"github.com/micro/go-micro/v2/codec"
 	log "github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/server"
 
 	eventbroker "github.com/micro-community/x-edge/broker"
+	config "github.com/micro-community/x-edge/cmd"
+	"github.com/micro-community/x-edge/node/mapping"
+	nserver "github.com/micro-community/x-edge/node/server"
 
 	protocol "github.com/micro-community/x-edge/proto/protocol"
 )
 
 //ProtocolPackage means a private protocol package
 type ProtocolPackage struct {
-	Version string `xml:"VER"`
-	Name    string `xml:"NAME"`
-	Gender  string `xml:"GENDER"`
-	Type    string `xml:"TYPE"`
-	Addr    string `xml:"ADDR"`
-	Phone   string `xml:"PHONE"`
-	Company string `xml:"COMPANY"`
-	Time    string `xml:"TIME"`
+	Version string `xml:"VER" map:"Ver,trim"`
+	Name    string `xml:"NAME" map:"Name,trim"`
+	Gender  string `xml:"GENDER" map:"Gender,trim"`
+	Type    string `xml:"TYPE" map:"Type,trim"`
+	Addr    string `xml:"ADDR" map:"Addr,trim"`
+	Phone   string `xml:"PHONE" map:"Phone,trim"`
+	Company string `xml:"COMPANY" map:"Company,trim"`
+	Time    string `xml:"TIME" map:"Time,trim"`
+}
+
+// protocolMapping maps ProtocolPackage to protocol.Message, it is validated
+// at startup so a wiring mistake never reaches a device
+var protocolMapping = mapping.Must(mapping.FromTags(ProtocolPackage{}, protocol.Message{}))
+
+func init() {
+	if src, dst := protocolMapping.Unmapped(); len(src) > 0 || len(dst) > 0 {
+		log.Warnf("unmapped fields of ProtocolPackage %v and of protocol.Message %v", src, dst)
+	}
 }
 
 type ProtocolServer struct {
@@ -55,14 +69,8 @@ func (e *ProtocolServer) makepackage(protocolInfo ProtocolPackage) (buf []byte,
 }
 
 func (e *ProtocolServer) createProtocMsg(protocolInfo ProtocolPackage) (msg protocol.Message) {
-	msg = protocol.Message{
-		Ver:     protocolInfo.Version,
-		Name:    protocolInfo.Name,
-		Gender:  protocolInfo.Gender,
-		Type:    protocolInfo.Addr,
-		Addr:    protocolInfo.Phone,
-		Phone:   protocolInfo.Company,
-		Company: protocolInfo.Time,
+	if err := protocolMapping.Map(&protocolInfo, &msg); err != nil {
+		log.Errorf("createProtocMsg error:%v", err)
 	}
 	return msg
 }
@@ -84,13 +92,31 @@ func (e *ProtocolServer) Event(ctx context.Context, req *codec.Message, resp *co
 
 	resp.Body = replybuf
 
-	//make the protocol.message
-	buf := e.createProtocMsg(ProtocolPackage)
-	//publish protocol.message
-	err = eventbroker.PublishEventMessage(&buf)
+	// the event is published by the bridge once it is handled, see EventRule
+	return nil
+}
 
+//EventEndpoint is the handler endpoint of events
+const EventEndpoint = "PROTOCOLSERVER.Event"
+
+//EventPayload maps the frame of an event to the protocol.Message published,
+//it is the Payload of a bridge rule
+func EventPayload(req server.Request) (interface{}, error) {
+	var e ProtocolServer
+	info, err := e.unpackage(nserver.FrameOf(req))
 	if err != nil {
-		log.Errorf("eventbroker.Event PublishEventMessage error:%v", err)
+		return nil, err
+	}
+	msg := e.createProtocMsg(info)
+	return &msg, nil
+}
+
+//EventRule bridges the events to the topic the event subscribers listen on,
+//it is the bridge of edge unless bridge rules are configured
+func EventRule() eventbroker.BridgeRule {
+	return eventbroker.BridgeRule{
+		Endpoint: EventEndpoint,
+		Topic:    config.EventSubscriberName,
+		Payload:  EventPayload,
 	}
-	return nil
 }
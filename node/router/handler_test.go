@@ -0,0 +1,82 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	eventbroker "github.com/micro-community/x-edge/broker"
+	config "github.com/micro-community/x-edge/cmd"
+	protocol "github.com/micro-community/x-edge/proto/protocol"
+	"github.com/micro/go-micro/v2/broker"
+	"github.com/micro/go-micro/v2/broker/memory"
+	"github.com/micro/go-micro/v2/client"
+	"github.com/micro/go-micro/v2/codec"
+	"github.com/micro/go-micro/v2/codec/proto"
+	"github.com/micro/go-micro/v2/server"
+)
+
+const eventFrame = `<?xml version="1.0" encoding="gb2312"?>
+<PROTOCOL><VER>1.0</VER><NAME>meter-42</NAME><TYPE>Event</TYPE></PROTOCOL>`
+
+// eventRequest is the request of an event handled by ProtocolServer
+type eventRequest struct{}
+
+func (eventRequest) Service() string       { return "PROTOCOLSERVER" }
+func (eventRequest) Method() string        { return "Event" }
+func (eventRequest) Endpoint() string      { return EventEndpoint }
+func (eventRequest) ContentType() string   { return "application/xml" }
+func (eventRequest) Read() ([]byte, error) { return []byte(eventFrame), nil }
+func (eventRequest) Codec() codec.Reader   { return nil }
+func (eventRequest) Stream() bool          { return false }
+func (eventRequest) Header() map[string]string {
+	return map[string]string{"NAME": "meter-42", "TYPE": "Event"}
+}
+func (eventRequest) Body() interface{} {
+	return &codec.Message{Body: []byte(eventFrame)}
+}
+
+func TestEventRule(t *testing.T) {
+	b := memory.NewBroker()
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	msgs := make(chan *broker.Message, 10)
+	if _, err := b.Subscribe(config.EventSubscriberName, func(e broker.Event) error {
+		msgs <- e.Message()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event is published once by the bridge, never by the handler
+	bridge := eventbroker.NewBridge(client.NewClient(client.Broker(b)), EventRule())
+	fn := bridge.HandlerWrapper()(func(ctx context.Context, req server.Request, rsp interface{}) error {
+		return new(ProtocolServer).Event(ctx, req.Body().(*codec.Message), rsp.(*codec.Message))
+	})
+	rsp := new(codec.Message)
+	if err := fn(context.Background(), eventRequest{}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Body) == 0 {
+		t.Fatal("Expected reply of event")
+	}
+
+	select {
+	case m := <-msgs:
+		var msg protocol.Message
+		if err := new(proto.Marshaler).Unmarshal(m.Body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Name != "meter-42" || msg.Ver != "1.0" || msg.Type != "Event" {
+			t.Fatalf("Unexpected event %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected event published")
+	}
+	select {
+	case m := <-msgs:
+		t.Fatalf("Expected event published once, got %s", m.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
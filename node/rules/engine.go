@@ -0,0 +1,230 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro/go-micro/v2/codec"
+	"github.com/micro/go-micro/v2/config/encoder/toml"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// Engine evaluates the rules of a file, the file is reloaded once it changes
+type Engine struct {
+	path string
+
+	sync.RWMutex
+	rules   []*rule
+	dryRun  bool
+	modTime time.Time
+
+	exit chan bool
+}
+
+// Load loads the rules of a file
+func Load(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	if err := e.load(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// New creates an engine of rules, it is not backed by a file
+func New(f *File) (*Engine, error) {
+	rules, err := compile(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{rules: rules, dryRun: f.DryRun}, nil
+}
+
+func (e *Engine) load() error {
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	f := new(File)
+	if err := toml.NewEncoder().Decode(b, f); err != nil {
+		return fmt.Errorf("%s: %v", e.path, err)
+	}
+	rules, err := compile(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", e.path, err)
+	}
+
+	e.Lock()
+	e.rules = rules
+	e.dryRun = f.DryRun
+	e.modTime = fi.ModTime()
+	e.Unlock()
+	return nil
+}
+
+// Reload reloads the file if it changed, the rules in use are kept
+// if the file is invalid
+func (e *Engine) Reload() (bool, error) {
+	if len(e.path) == 0 {
+		return false, nil
+	}
+	fi, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+	e.RLock()
+	changed := !fi.ModTime().Equal(e.modTime)
+	e.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	if err := e.load(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Watch reloads the file at every interval
+func (e *Engine) Watch(interval time.Duration) {
+	e.Lock()
+	defer e.Unlock()
+	if e.exit != nil {
+		return
+	}
+	exit := make(chan bool)
+	e.exit = exit
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-exit:
+				return
+			case <-t.C:
+				reloaded, err := e.Reload()
+				if err != nil {
+					log.Errorf("unable to reload rules: %v", err)
+				} else if reloaded {
+					log.Infof("rules of %s reloaded", e.path)
+				}
+			}
+		}
+	}()
+}
+
+// Stop watching the file
+func (e *Engine) Stop() {
+	e.Lock()
+	defer e.Unlock()
+	if e.exit != nil {
+		close(e.exit)
+		e.exit = nil
+	}
+}
+
+// Filter evaluates the rules on a frame, it is a nserver.FrameFilter
+func (e *Engine) Filter(ctx context.Context, msg *codec.Message) nserver.Verdict {
+	e.RLock()
+	rules, dryRun := e.rules, e.dryRun
+	e.RUnlock()
+
+	var v nserver.Verdict
+	f := &frame{msg: msg}
+	now := time.Now()
+
+	for _, r := range rules {
+		if !r.matches(f, now) {
+			continue
+		}
+		if dryRun {
+			log.Infof("[dry-run] rule %s would %s frame of %s", r.Name, r.Action, msg.Header["Remote"])
+			continue
+		}
+		log.Debugf("rule %s %s frame of %s", r.Name, r.Action, msg.Header["Remote"])
+
+		switch r.Action {
+		case "drop":
+			v.Drop = true
+			return v
+		case "route":
+			if len(v.Route) == 0 {
+				v.Route = r.Endpoint
+			}
+		case "publish":
+			v.Topics = append(v.Topics, r.Topics...)
+		case "annotate":
+			if v.Annotations == nil {
+				v.Annotations = make(map[string]string)
+			}
+			for k, val := range r.Annotations {
+				v.Annotations[k] = val
+			}
+		}
+		if r.Final {
+			break
+		}
+	}
+	return v
+}
+
+func (r *rule) matches(f *frame, now time.Time) bool {
+	for _, c := range r.when {
+		v, ok := f.value(c)
+		if !c.match(v, ok, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// frame decodes the elements of a frame once a condition needs them
+type frame struct {
+	msg     *codec.Message
+	decoded bool
+	elems   map[string]interface{}
+}
+
+func (f *frame) value(c *condition) (string, bool) {
+	if len(c.header) > 0 {
+		v, ok := f.msg.Header[c.header]
+		return v, ok
+	}
+
+	if !f.decoded {
+		f.decoded = true
+		elems, err := xmlc.ToMap(f.msg.Body)
+		if err != nil {
+			log.Debugf("rules cannot decode frame: %v", err)
+		}
+		f.elems = elems
+	}
+
+	var v interface{} = f.elems
+	for _, p := range c.path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[p]; !ok {
+			return "", false
+		}
+		// a repeated element is tested by its first one
+		if s, ok := v.([]interface{}); ok && len(s) > 0 {
+			v = s[0]
+		}
+	}
+	s, ok := v.(string)
+	return strings.TrimSpace(s), ok
+}
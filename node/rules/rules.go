@@ -0,0 +1,213 @@
+// Package rules provides a rule engine evaluating device frames before they
+// are dispatched, the rules are declared in a toml file such as
+//
+//	dryrun = false
+//
+//	[[rules]]
+//	  name = "stale"
+//	  action = "drop"
+//	  [[rules.when]]
+//	    field = "TIME"
+//	    op = "older"
+//	    value = "10m"
+//	    layout = "20060102150405"
+//
+// A condition tests a decoded element of frame by its path, e.g. "LOC.HALL",
+// or a header by "header.NAME". The actions are drop, route, publish and annotate.
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File of rules
+type File struct {
+	// DryRun logs the rule hits without applying them
+	DryRun bool   `toml:"dryrun"`
+	Rules  []Rule `toml:"rules"`
+}
+
+// Rule applies its action to the frames matching all of its conditions
+type Rule struct {
+	Name string      `toml:"name"`
+	When []Condition `toml:"when"`
+	// Action is one of drop, route, publish and annotate
+	Action string `toml:"action"`
+	// Endpoint "Service.Method" of route
+	Endpoint string `toml:"endpoint"`
+	// Topics of publish
+	Topics []string `toml:"topics"`
+	// Annotations of annotate, they are added to the headers of frame
+	Annotations map[string]string `toml:"annotations"`
+	// Final stops evaluating the rules following it
+	Final bool `toml:"final"`
+}
+
+// Condition on a header or a decoded element of frame, the ops are
+// eq, ne, gt, ge, lt, le, exists, missing, matches, older and newer
+type Condition struct {
+	Field string `toml:"field"`
+	Op    string `toml:"op"`
+	Value string `toml:"value"`
+	// Layout of the timestamp compared by older and newer, RFC3339 by default
+	Layout string `toml:"layout"`
+}
+
+const headerPrefix = "header."
+
+type condition struct {
+	Condition
+	header string
+	path   []string
+	re     *regexp.Regexp
+	age    time.Duration
+}
+
+type rule struct {
+	Rule
+	when []*condition
+}
+
+func compile(f *File) ([]*rule, error) {
+	var errs []string
+	rules := make([]*rule, 0, len(f.Rules))
+	for i, r := range f.Rules {
+		name := r.Name
+		if len(name) == 0 {
+			name = "#" + strconv.Itoa(i+1)
+			r.Name = name
+		}
+		cr := &rule{Rule: r}
+		if err := checkAction(r); err != nil {
+			errs = append(errs, fmt.Sprintf("rule %s: %v", name, err))
+		}
+		for _, c := range r.When {
+			cc, err := compileCondition(c)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("rule %s: %v", name, err))
+				continue
+			}
+			cr.when = append(cr.when, cc)
+		}
+		rules = append(rules, cr)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid rules: %s", strings.Join(errs, "; "))
+	}
+	return rules, nil
+}
+
+func checkAction(r Rule) error {
+	switch r.Action {
+	case "drop":
+	case "route":
+		if len(strings.SplitN(r.Endpoint, ".", 2)) != 2 {
+			return fmt.Errorf("route takes an endpoint \"Service.Method\", not %q", r.Endpoint)
+		}
+	case "publish":
+		if len(r.Topics) == 0 {
+			return fmt.Errorf("publish takes topics")
+		}
+	case "annotate":
+		if len(r.Annotations) == 0 {
+			return fmt.Errorf("annotate takes annotations")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	return nil
+}
+
+func compileCondition(c Condition) (*condition, error) {
+	if len(c.Field) == 0 {
+		return nil, fmt.Errorf("condition without field")
+	}
+	cc := &condition{Condition: c}
+	if strings.HasPrefix(c.Field, headerPrefix) {
+		cc.header = strings.TrimPrefix(c.Field, headerPrefix)
+	} else {
+		cc.path = strings.Split(c.Field, ".")
+	}
+
+	switch c.Op {
+	case "eq", "ne", "gt", "ge", "lt", "le", "exists", "missing":
+	case "matches":
+		re, err := regexp.Compile(c.Value)
+		if err != nil {
+			return nil, err
+		}
+		cc.re = re
+	case "older", "newer":
+		age, err := time.ParseDuration(c.Value)
+		if err != nil {
+			return nil, err
+		}
+		cc.age = age
+		if len(cc.Layout) == 0 {
+			cc.Layout = time.RFC3339
+		}
+	default:
+		return nil, fmt.Errorf("unknown op %q of %s", c.Op, c.Field)
+	}
+	return cc, nil
+}
+
+// match tests the value of field, ok tells whether the field is there
+func (c *condition) match(v string, ok bool, now time.Time) bool {
+	switch c.Op {
+	case "exists":
+		return ok
+	case "missing":
+		return !ok
+	}
+	if !ok {
+		return false
+	}
+
+	switch c.Op {
+	case "matches":
+		return c.re.MatchString(v)
+	case "older", "newer":
+		t, err := time.Parse(c.Layout, v)
+		if err != nil {
+			return false
+		}
+		if c.Op == "older" {
+			return now.Sub(t) > c.age
+		}
+		return now.Sub(t) <= c.age
+	}
+
+	// numbers are compared as numbers, otherwise as strings
+	var cmp int
+	a, aerr := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	b, berr := strconv.ParseFloat(strings.TrimSpace(c.Value), 64)
+	switch {
+	case aerr == nil && berr == nil && a < b:
+		cmp = -1
+	case aerr == nil && berr == nil && a > b:
+		cmp = 1
+	case aerr == nil && berr == nil:
+	default:
+		cmp = strings.Compare(v, c.Value)
+	}
+
+	switch c.Op {
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	default:
+		return cmp <= 0
+	}
+}
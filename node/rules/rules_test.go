@@ -0,0 +1,165 @@
+package rules
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2/codec"
+)
+
+func frameOf(packetType, body string) *codec.Message {
+	return &codec.Message{
+		Header: map[string]string{"TYPE": packetType, "NAME": "meter-42"},
+		Body:   []byte("<PROTOCOL><NAME>meter-42</NAME><TYPE>" + packetType + "</TYPE>" + body + "</PROTOCOL>"),
+	}
+}
+
+func TestFilter(t *testing.T) {
+	e, err := New(&File{Rules: []Rule{
+		{
+			Name:   "stale",
+			Action: "drop",
+			When:   []Condition{{Field: "TIME", Op: "older", Value: "10m", Layout: "20060102150405"}},
+		},
+		{
+			Name:   "alarm",
+			Action: "publish",
+			Topics: []string{"alarms"},
+			When: []Condition{
+				{Field: "header.TYPE", Op: "eq", Value: "1"},
+				{Field: "READING.VALUE", Op: "gt", Value: "100"},
+			},
+		},
+		{
+			Name:        "tag",
+			Action:      "annotate",
+			Annotations: map[string]string{"Site": "hall"},
+			When:        []Condition{{Field: "header.NAME", Op: "matches", Value: "^meter-"}},
+			Final:       true,
+		},
+		{
+			Name:     "never",
+			Action:   "route",
+			Endpoint: "ProtocolServer.Event",
+			When:     []Condition{{Field: "NAME", Op: "exists"}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	stale := "<TIME>" + now.Add(-time.Hour).Format("20060102150405") + "</TIME>"
+	if v := e.Filter(context.Background(), frameOf("1", stale)); !v.Drop {
+		t.Fatalf("Expected stale frame dropped, got %+v", v)
+	}
+
+	fresh := "<TIME>" + now.Format("20060102150405") + "</TIME><READING><VALUE>120</VALUE></READING>"
+	v := e.Filter(context.Background(), frameOf("1", fresh))
+	if v.Drop || !reflect.DeepEqual(v.Topics, []string{"alarms"}) || v.Annotations["Site"] != "hall" || len(v.Route) > 0 {
+		t.Fatalf("Unexpected verdict %+v", v)
+	}
+
+	v = e.Filter(context.Background(), frameOf("1", "<READING><VALUE>99.5</VALUE></READING>"))
+	if len(v.Topics) > 0 {
+		t.Fatalf("Expected no alarm below threshold, got %+v", v)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	e, err := New(&File{DryRun: true, Rules: []Rule{
+		{Name: "all", Action: "drop", When: []Condition{{Field: "header.TYPE", Op: "exists"}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := e.Filter(context.Background(), frameOf("1", "")); v.Drop {
+		t.Fatal("Expected dry run not to drop the frame")
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	invalid := []Rule{
+		{Action: "explode"},
+		{Action: "route", Endpoint: "Event"},
+		{Action: "publish"},
+		{Action: "drop", When: []Condition{{Field: "TYPE", Op: "like"}}},
+		{Action: "drop", When: []Condition{{Field: "TYPE", Op: "matches", Value: "("}}},
+		{Action: "drop", When: []Condition{{Field: "TIME", Op: "older", Value: "soon"}}},
+	}
+	for _, r := range invalid {
+		if _, err := New(&File{Rules: []Rule{r}}); err == nil {
+			t.Fatalf("Expected rule %+v to be invalid", r)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.toml")
+	write := func(rules string, mod time.Time) {
+		if err := ioutil.WriteFile(path, []byte(rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mod, mod)
+	}
+
+	write(`
+[[rules]]
+  name = "drop-1"
+  action = "drop"
+  [[rules.when]]
+    field = "header.TYPE"
+    op = "eq"
+    value = "1"
+`, time.Now().Add(-time.Minute))
+
+	e, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := e.Filter(context.Background(), frameOf("1", "")); !v.Drop {
+		t.Fatal("Expected frame of type 1 dropped")
+	}
+
+	// an invalid file keeps the rules in use
+	write(`
+[[rules]]
+  action = "explode"
+`, time.Now().Add(-30*time.Second))
+	if _, err := e.Reload(); err == nil {
+		t.Fatal("Expected error of invalid rules")
+	}
+	if v := e.Filter(context.Background(), frameOf("1", "")); !v.Drop {
+		t.Fatal("Expected the rules in use to be kept")
+	}
+
+	write(`
+[[rules]]
+  name = "drop-2"
+  action = "drop"
+  [[rules.when]]
+    field = "header.TYPE"
+    op = "eq"
+    value = "2"
+`, time.Now())
+	if reloaded, err := e.Reload(); !reloaded || err != nil {
+		t.Fatalf("Expected rules reloaded, got %v", err)
+	}
+	if v := e.Filter(context.Background(), frameOf("1", "")); v.Drop {
+		t.Fatal("Expected frame of type 1 kept after reload")
+	}
+	if v := e.Filter(context.Background(), frameOf("2", "")); !v.Drop {
+		t.Fatal("Expected frame of type 2 dropped after reload")
+	}
+}
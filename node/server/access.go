@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	"github.com/micro/go-micro/v2/auth"
+	"github.com/micro/go-micro/v2/codec"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	merrors "github.com/micro/go-micro/v2/errors"
+)
+
+//Direction of a frame
+type Direction string
+
+//The directions of frames
+const (
+	// Uplink frames are sent by devices
+	Uplink Direction = "up"
+	// Downlink frames are delivered to devices
+	Downlink Direction = "down"
+)
+
+//ServiceHeader of a delivery tells the upstream service commanding the device,
+//it is the header of broker message as published, so any publisher of the topic
+//of commands may claim any service, the broker has to restrict who publishes
+const ServiceHeader = "Micro-From-Service"
+
+//Access of a frame to be authorized
+type Access struct {
+	Direction Direction
+	// Type of packet
+	Type string
+	// Device the frame is delivered to, empty for uplink frames
+	Device string
+	// Header of uplink frame, Remote address and Account of the connection
+	Header  map[string]string
+	Remote  string
+	Account *auth.Account
+	// Service commanding the device of downlink frame, as claimed by the
+	// publisher of command
+	Service string
+}
+
+//Authorizer decides whether a frame is allowed, the error of a denial
+//rejects the frame
+type Authorizer func(ctx context.Context, a Access) error
+
+// authorize an uplink frame of a connection
+func (router *Routing) authorize(ctx context.Context, msg *codec.Message) error {
+	router.mu.Lock()
+	fn := router.authorizer
+	router.mu.Unlock()
+	if fn == nil {
+		return nil
+	}
+
+	a := Access{
+		Direction: Uplink,
+		Type:      msg.Method,
+		Header:    msg.Header,
+	}
+	if c, ok := ctx.Value(connKey{}).(*deviceConn); ok {
+		a.Remote = c.Remote()
+		if c.auth != nil {
+			c.auth.Lock()
+			a.Account = c.auth.account
+			c.auth.Unlock()
+		}
+	}
+	if err := fn(ctx, a); err != nil {
+		return merrors.Forbidden("node.acl", "%v", err)
+	}
+	return nil
+}
+
+// authorizeDelivery authorizes a message delivered to a device
+func (s *nodeServer) authorizeDelivery(device string, hdr map[string]string, msg interface{}) error {
+	s.router.mu.Lock()
+	fn := s.router.authorizer
+	s.router.mu.Unlock()
+	if fn == nil {
+		return nil
+	}
+
+	a := Access{
+		Direction: Downlink,
+		Type:      hdr["TYPE"],
+		Device:    device,
+		Service:   hdr[ServiceHeader],
+	}
+	// the packet type of a raw frame is the one in it
+	if f, ok := msg.(*raw.Frame); ok && len(a.Type) == 0 {
+		if m, err := xmlc.ToMap(f.Data); err == nil {
+			a.Type, _ = m["TYPE"].(string)
+		}
+	}
+	if m, ok := msg.(*codec.Message); ok && len(a.Type) == 0 {
+		a.Type = m.Method
+	}
+	// so is the one of a packet typed, as encoded to the device
+	if len(a.Type) == 0 {
+		a.Type = typeOf(msg)
+	}
+	if err := fn(context.Background(), a); err != nil {
+		return merrors.Forbidden("node.acl", "%v", err)
+	}
+	return nil
+}
+
+// typeOf returns the packet type of a message encoded to xml, empty if none
+func typeOf(msg interface{}) string {
+	switch msg.(type) {
+	case nil, *raw.Frame, *codec.Message:
+		return ""
+	}
+	b, err := xml.Marshal(msg)
+	if err != nil {
+		return ""
+	}
+	m, err := xmlc.ToMap(b)
+	if err != nil {
+		return ""
+	}
+	t, _ := m["TYPE"].(string)
+	return t
+}
+
+func isDenied(err error) bool {
+	merr, ok := err.(*merrors.Error)
+	return ok && (merr.Code == 401 || merr.Code == 403)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+func TestAuthorize(t *testing.T) {
+	var accesses []Access
+	authorize := func(ctx context.Context, a Access) error {
+		accesses = append(accesses, a)
+		if a.Direction == Uplink && a.Type == "Event" && a.Header["NAME"] == "meter-42" {
+			return errors.New("Event frame of meter-42 denied")
+		}
+		if a.Direction == Downlink && a.Service == "billing" {
+			return errors.New("command denied")
+		}
+		return nil
+	}
+
+	ps := new(ProtocolServer)
+	srv := NewServer(Authorize(authorize)).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(ps)); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	if e := sent(t, sock); !strings.Contains(e, "denied") {
+		t.Fatalf("Expected error frame, got %s", e)
+	}
+	// a denied frame does not bind its device
+	if c := srv.Connections(); len(c) != 0 {
+		t.Fatalf("Unexpected connections %v", c)
+	}
+
+	sock.recv <- &transport.Message{Body: []byte(strings.Replace(testFrame, "meter-42", "meter-7", 1))}
+	sent(t, sock)
+
+	err := srv.Deliver("meter-7", map[string]string{ServiceHeader: "billing"}, &raw.Frame{Data: []byte("<PROTOCOL><TYPE>SET</TYPE></PROTOCOL>")})
+	if !isDenied(err) {
+		t.Fatalf("Expected delivery denied, got %v", err)
+	}
+	// the packet type of a command typed is the one encoded
+	err = srv.Deliver("meter-7", nil, &struct {
+		XMLName xml.Name `xml:"PROTOCOL"`
+		Type    string   `xml:"TYPE"`
+	}{Type: "CONFIG"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent(t, sock)
+	if err := srv.Deliver("meter-7", nil, &raw.Frame{Data: []byte("<PING/>")}); err != nil {
+		t.Fatal(err)
+	}
+	sent(t, sock)
+
+	close(sock.recv)
+	<-done
+
+	if ps.calls != 1 {
+		t.Fatalf("Expected 1 call of handler, got %d", ps.calls)
+	}
+	if a := accesses[2]; a.Direction != Downlink || a.Type != "SET" || a.Device != "meter-7" {
+		t.Fatalf("Unexpected access of delivery %+v", a)
+	}
+	if a := accesses[3]; a.Direction != Downlink || a.Type != "CONFIG" {
+		t.Fatalf("Unexpected access of typed delivery %+v", a)
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+//Admin inspects and acts on the live connections of a node server,
+//a connection is identified by "local-remote" like the PresenceEvent
+type Admin interface {
+	// LiveConns returns the connections accepted, devices identified or not
+	LiveConns() []ConnInfo
+	// LiveConn returns a connection by id
+	LiveConn(id string) (ConnInfo, bool)
+	// Disconnect closes a connection, its devices are released as disconnected
+	Disconnect(id string) error
+	// SendTo encodes a message with the codec of connection and sends it down,
+	// msg is a *raw.Frame, a *codec.Message or a value of the device codec,
+	// unlike Deliver it is not authorized as the command of a service
+	SendTo(id string, hdr map[string]string, msg interface{}) error
+	// Handlers returns the handlers registered and their methods
+	Handlers() []HandlerInfo
+}
+
+//ConnInfo of a live connection
+type ConnInfo struct {
+	ID          string    `json:"id"`
+	Local       string    `json:"local"`
+	Remote      string    `json:"remote"`
+	Transport   string    `json:"transport"`
+	Devices     []string  `json:"devices"`
+	ConnectedAt time.Time `json:"connected_at"`
+	FramesIn    uint64    `json:"frames_in"`
+	FramesOut   uint64    `json:"frames_out"`
+	BytesIn     uint64    `json:"bytes_in"`
+	BytesOut    uint64    `json:"bytes_out"`
+}
+
+//HandlerInfo of a handler registered
+type HandlerInfo struct {
+	// Name of the handler, the target frames are resolved to
+	Name string `json:"name"`
+	// Methods handling the packet types, such as "Event"
+	Methods []string `json:"methods"`
+}
+
+//ErrNoConn is returned when a connection is not live
+var ErrNoConn = errors.New("connection not found")
+
+//errDisconnected closes the connection disconnected by an operator
+var errDisconnected = errors.New("disconnected by operator")
+
+// id of the connection, the one of its presence events
+func (c *deviceConn) id() string {
+	return c.presence.local + "-" + c.presence.remote
+}
+
+// info of the connection, the caller must hold conns
+func (c *deviceConn) info(transport string) ConnInfo {
+	devices := make([]string, 0, len(c.devices))
+	for device := range c.devices {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	return ConnInfo{
+		ID:          c.id(),
+		Local:       c.presence.local,
+		Remote:      c.presence.remote,
+		Transport:   transport,
+		Devices:     devices,
+		ConnectedAt: c.presence.connectedAt,
+		FramesIn:    atomic.LoadUint64(&c.presence.framesIn),
+		FramesOut:   atomic.LoadUint64(&c.presence.framesOut),
+		BytesIn:     atomic.LoadUint64(&c.presence.bytesIn),
+		BytesOut:    atomic.LoadUint64(&c.presence.bytesOut),
+	}
+}
+
+// lookup returns a live connection by id
+func (d *deviceConns) lookup(id string) (*deviceConn, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	for c := range d.live {
+		if c.id() == id {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// transport names the transport of server
+func (s *nodeServer) transport() string {
+	s.RLock()
+	defer s.RUnlock()
+	if s.opts.Transport == nil {
+		return ""
+	}
+	return s.opts.Transport.String()
+}
+
+//LiveConns returns the connections accepted by the oldest first
+func (s *nodeServer) LiveConns() []ConnInfo {
+	transport := s.transport()
+	s.conns.RLock()
+	conns := make([]ConnInfo, 0, len(s.conns.live))
+	for c := range s.conns.live {
+		conns = append(conns, c.info(transport))
+	}
+	s.conns.RUnlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		if conns[i].ConnectedAt.Equal(conns[j].ConnectedAt) {
+			return conns[i].ID < conns[j].ID
+		}
+		return conns[i].ConnectedAt.Before(conns[j].ConnectedAt)
+	})
+	return conns
+}
+
+//LiveConn returns a connection by id
+func (s *nodeServer) LiveConn(id string) (ConnInfo, bool) {
+	transport := s.transport()
+	c, ok := s.conns.lookup(id)
+	if !ok {
+		return ConnInfo{}, false
+	}
+	s.conns.RLock()
+	defer s.conns.RUnlock()
+	return c.info(transport), true
+}
+
+//Disconnect closes a connection by id
+func (s *nodeServer) Disconnect(id string) error {
+	c, ok := s.conns.lookup(id)
+	if !ok {
+		return ErrNoConn
+	}
+	c.close(errDisconnected)
+	return nil
+}
+
+//SendTo encodes a message with the codec of connection and sends it down
+func (s *nodeServer) SendTo(id string, hdr map[string]string, msg interface{}) error {
+	c, ok := s.conns.lookup(id)
+	if !ok {
+		return ErrNoConn
+	}
+	// the codec of device may need the device, e.g. to encrypt by its key
+	s.conns.RLock()
+	info := c.info("")
+	s.conns.RUnlock()
+	device := ""
+	if len(info.Devices) > 0 {
+		device = info.Devices[0]
+	}
+	return s.send(c, device, hdr, msg)
+}
+
+//Handlers returns the handlers registered by name
+func (s *nodeServer) Handlers() []HandlerInfo {
+	return s.router.handlers()
+}
+
+// handlers registered by name and their methods
+func (router *Routing) handlers() []HandlerInfo {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	handlers := make([]HandlerInfo, 0, len(router.serviceMap))
+	for name, svc := range router.serviceMap {
+		methods := make([]string, 0, len(svc.method))
+		for method := range svc.method {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		handlers = append(handlers, HandlerInfo{Name: name, Methods: methods})
+	}
+	sort.Slice(handlers, func(i, j int) bool { return handlers[i].Name < handlers[j].Name })
+	return handlers
+}
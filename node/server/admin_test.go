@@ -0,0 +1,81 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+func TestAdmin(t *testing.T) {
+	events := make(chan PresenceEvent, 8)
+	srv := NewServer(WatchPresence(func(ev PresenceEvent) { events <- ev })).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+	var admin Admin = srv
+
+	sock := newFakeSocket()
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	sent(t, sock)
+
+	conns := admin.LiveConns()
+	if len(conns) != 1 {
+		t.Fatalf("Expected a live connection, got %v", conns)
+	}
+	c := conns[0]
+	if c.ID != "127.0.0.1:8000-127.0.0.1:9000" || c.Remote != "127.0.0.1:9000" ||
+		len(c.Devices) != 1 || c.Devices[0] != "meter-42" ||
+		c.FramesIn != 1 || c.FramesOut != 1 || c.BytesIn != uint64(len(testFrame)) {
+		t.Fatalf("Unexpected connection %+v", c)
+	}
+	if _, ok := admin.LiveConn("127.0.0.1:8000-127.0.0.1:1"); ok {
+		t.Fatal("Expected no connection of unknown id")
+	}
+
+	if err := admin.SendTo(c.ID, nil, &raw.Frame{Data: []byte("<PING/>")}); err != nil {
+		t.Fatal(err)
+	}
+	if f := sent(t, sock); f != "<PING/>" {
+		t.Fatalf("Expected raw frame sent, got %s", f)
+	}
+	if c, _ := admin.LiveConn(c.ID); c.FramesOut != 2 {
+		t.Fatalf("Expected 2 frames sent, got %+v", c)
+	}
+
+	handlers := admin.Handlers()
+	if len(handlers) != 1 || handlers[0].Name != "PROTOCOLSERVER" ||
+		strings.Join(handlers[0].Methods, ",") != "Event,EventAck" {
+		t.Fatalf("Unexpected handlers %+v", handlers)
+	}
+
+	if err := admin.Disconnect("127.0.0.1:8000-127.0.0.1:1"); err != ErrNoConn {
+		t.Fatalf("Expected %v, got %v", ErrNoConn, err)
+	}
+	if err := admin.Disconnect(c.ID); err != nil {
+		t.Fatal(err)
+	}
+	close(sock.recv)
+	<-done
+	for ev := range events {
+		if ev.Type != PresenceClosed {
+			continue
+		}
+		if ev.Reason != ReasonDisconnected {
+			t.Fatalf("Expected closed as %s, got %+v", ReasonDisconnected, ev)
+		}
+		break
+	}
+	for deadline := time.Now().Add(time.Second); len(admin.LiveConns()) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected no live connection")
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/micro/go-micro/v2/auth"
+	"github.com/micro/go-micro/v2/codec"
+	merrors "github.com/micro/go-micro/v2/errors"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+//DefaultAuthFrames is the number of frames a session of device has to authenticate
+var DefaultAuthFrames = 3
+
+//Authenticator starts the handshake authenticating a connection of device,
+//the socket tells the peer and the TLS state of connection if any
+type Authenticator func(sock transport.Socket) Handshake
+
+//Handshake authenticates a session of device by its first frames
+type Handshake interface {
+	// Challenge is sent to device once connected, nil for none
+	Challenge() []byte
+	// Verify a frame of session before it is dispatched, an error rejects the frame
+	Verify(ctx context.Context, msg *codec.Message) (AuthStep, error)
+}
+
+//AuthStep is a frame verified by a handshake
+type AuthStep struct {
+	// Account of the device authenticated, nil to wait for the next frames
+	Account *auth.Account
+	// Consumed frames belong to the handshake and are not dispatched,
+	// Reply is sent to device instead
+	Consumed bool
+	Reply    []byte
+}
+
+var (
+	// errHandshake marks a frame consumed by handshake
+	errHandshake = errors.New("frame consumed by handshake")
+	// errUnauthenticated closes the session which failed to authenticate
+	errUnauthenticated = errors.New("device not authenticated")
+	// errImpersonation rejects the frames of a session naming another device
+	errImpersonation = errors.New("device is not the one authenticated")
+)
+
+// deviceAuth is the authentication state of a connection
+type deviceAuth struct {
+	sync.Mutex
+	handshake Handshake
+	account   *auth.Account
+	// frames left to authenticate
+	frames   int
+	rejected bool
+}
+
+// authenticate verifies a frame of connection until it authenticates,
+// the frames of a connection without handshake pass
+func authenticate(ctx context.Context, msg *codec.Message) error {
+	c, ok := ctx.Value(connKey{}).(*deviceConn)
+	if !ok || c.auth == nil {
+		return nil
+	}
+
+	a := c.auth
+	a.Lock()
+	defer a.Unlock()
+	if a.account != nil {
+		return a.claim(msg)
+	}
+	if a.rejected {
+		return merrors.Unauthorized("node.auth", "%v", errUnauthenticated)
+	}
+
+	step, err := a.handshake.Verify(ctx, msg)
+	if err == nil && step.Account != nil {
+		a.account = step.Account
+		c.bind(step.Account.ID)
+	}
+	if step.Consumed {
+		if len(step.Reply) > 0 {
+			if serr := c.Send(&transport.Message{Body: step.Reply}); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	}
+	if err == nil && a.account != nil {
+		if step.Consumed {
+			return errHandshake
+		}
+		return a.claim(msg)
+	}
+
+	// the frame is rejected, the session runs out of frames to authenticate
+	a.frames--
+	if a.frames <= 0 {
+		a.rejected = true
+	}
+	if err != nil {
+		return merrors.Unauthorized("node.auth", "%v: %v", errUnauthenticated, err)
+	}
+	if step.Consumed {
+		return errHandshake
+	}
+	return merrors.Unauthorized("node.auth", "%v", errUnauthenticated)
+}
+
+// claim rejects a frame naming a device other than the account authenticated,
+// the caller must hold the lock
+func (a *deviceAuth) claim(msg *codec.Message) error {
+	if name := msg.Header["NAME"]; len(name) > 0 && name != a.account.ID {
+		return merrors.Forbidden("node.auth", "%v: %s", errImpersonation, name)
+	}
+	return nil
+}
+
+// authenticated returns the context of a request carrying the account of device
+func authenticated(ctx context.Context) context.Context {
+	c, ok := ctx.Value(connKey{}).(*deviceConn)
+	if !ok || c.auth == nil {
+		return ctx
+	}
+	c.auth.Lock()
+	acc := c.auth.account
+	c.auth.Unlock()
+	if acc == nil {
+		return ctx
+	}
+	return auth.ContextWithAccount(ctx, acc)
+}
+
+// closeRejected closes the connection which failed to authenticate
+func closeRejected(ctx context.Context) {
+	c, ok := ctx.Value(connKey{}).(*deviceConn)
+	if !ok || c.auth == nil {
+		return
+	}
+	c.auth.Lock()
+	rejected := c.auth.rejected
+	c.auth.Unlock()
+	if rejected {
+		c.close(errUnauthenticated)
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2/auth"
+	"github.com/micro/go-micro/v2/codec"
+	"github.com/micro/go-micro/v2/server"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+const loginFrame = `<PROTOCOL><NAME>meter-42</NAME><TYPE>Login</TYPE></PROTOCOL>`
+
+// loginHandshake authenticates the device of a Login frame
+type loginHandshake struct{}
+
+func (loginHandshake) Challenge() []byte { return []byte("<HELLO/>") }
+
+func (loginHandshake) Verify(ctx context.Context, msg *codec.Message) (AuthStep, error) {
+	if msg.Method != "Login" {
+		return AuthStep{}, nil
+	}
+	return AuthStep{
+		Account:  &auth.Account{ID: "meter-42", Type: "device"},
+		Consumed: true,
+		Reply:    []byte("<WELCOME/>"),
+	}, nil
+}
+
+func newAuthServer(t *testing.T, accounts chan string, events chan PresenceEvent) *nodeServer {
+	srv := NewServer(
+		Authenticate(func(sock transport.Socket) Handshake { return loginHandshake{} }, 2),
+		WatchPresence(func(ev PresenceEvent) { events <- ev }),
+		server.WrapHandler(func(fn server.HandlerFunc) server.HandlerFunc {
+			return func(ctx context.Context, req server.Request, rsp interface{}) error {
+				if acc, ok := auth.AccountFromContext(ctx); ok {
+					accounts <- acc.ID
+				} else {
+					accounts <- ""
+				}
+				return fn(ctx, req, rsp)
+			}
+		}),
+	).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func sent(t *testing.T, sock *fakeSocket) string {
+	select {
+	case m := <-sock.sent:
+		return string(m.Body)
+	case <-time.After(time.Second):
+		t.Fatal("nothing sent to device")
+	}
+	return ""
+}
+
+func TestAuthenticate(t *testing.T) {
+	accounts := make(chan string, 4)
+	events := make(chan PresenceEvent, 8)
+	srv := newAuthServer(t, accounts, events)
+
+	sock := newFakeSocket()
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+
+	if c := sent(t, sock); c != "<HELLO/>" {
+		t.Fatalf("Expected challenge, got %s", c)
+	}
+
+	// frames are rejected until the session authenticates
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	if e := sent(t, sock); !strings.Contains(e, "not authenticated") {
+		t.Fatalf("Expected error frame, got %s", e)
+	}
+
+	sock.recv <- &transport.Message{Body: []byte(loginFrame)}
+	if r := sent(t, sock); r != "<WELCOME/>" {
+		t.Fatalf("Expected reply of handshake, got %s", r)
+	}
+
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	sent(t, sock)
+	if acc := <-accounts; acc != "meter-42" {
+		t.Fatalf("Expected account meter-42 in context, got %q", acc)
+	}
+
+	close(sock.recv)
+	<-done
+	if len(accounts) != 0 {
+		t.Fatalf("Unexpected calls of handler before authentication")
+	}
+}
+
+func TestAuthenticateRejected(t *testing.T) {
+	accounts := make(chan string, 4)
+	events := make(chan PresenceEvent, 8)
+	srv := newAuthServer(t, accounts, events)
+
+	sock := newFakeSocket()
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+	sent(t, sock)
+
+	for i := 0; i < 2; i++ {
+		sock.recv <- &transport.Message{Body: []byte(testFrame)}
+		sent(t, sock)
+	}
+	// the fake socket is not closed by the server, the device goes away once it is
+	for closed := false; !closed; time.Sleep(time.Millisecond) {
+		srv.conns.RLock()
+		for c := range srv.conns.live {
+			closed = c.closeErr != nil
+		}
+		srv.conns.RUnlock()
+	}
+	close(sock.recv)
+	<-done
+
+	for ev := range events {
+		if ev.Type != PresenceClosed {
+			continue
+		}
+		if ev.Reason != ReasonUnauthorized {
+			t.Fatalf("Expected closed as %s, got %+v", ReasonUnauthorized, ev)
+		}
+		break
+	}
+	if len(accounts) != 0 {
+		t.Fatalf("Unexpected calls of handler")
+	}
+}
+
+func TestAuthenticateImpersonation(t *testing.T) {
+	accounts := make(chan string, 4)
+	events := make(chan PresenceEvent, 8)
+	srv := newAuthServer(t, accounts, events)
+
+	sock := newFakeSocket()
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+	sent(t, sock)
+	sock.recv <- &transport.Message{Body: []byte(loginFrame)}
+	sent(t, sock)
+
+	// meter-42 claims to be meter-43
+	sock.recv <- &transport.Message{Body: []byte(strings.Replace(testFrame, "meter-42", "meter-43", 1))}
+	if e := sent(t, sock); !strings.Contains(e, "not the one authenticated") {
+		t.Fatalf("Expected error frame of impersonation, got %s", e)
+	}
+	if devices := srv.Connections(); len(devices) != 1 || devices[0] != "meter-42" {
+		t.Fatalf("Expected meter-42 bound only, got %v", devices)
+	}
+	if err := srv.Deliver("meter-43", nil, &codec.Message{Body: []byte("<PING/>")}); err != ErrNotConnected {
+		t.Fatalf("Expected meter-43 not connected, got %v", err)
+	}
+
+	// the session goes on as the device authenticated
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	sent(t, sock)
+	if acc := <-accounts; acc != "meter-42" {
+		t.Fatalf("Expected account meter-42 in context, got %q", acc)
+	}
+
+	close(sock.recv)
+	<-done
+	if len(accounts) != 0 {
+		t.Fatalf("Unexpected calls of handler by the frame of meter-43")
+	}
+}
@@ -1,29 +1,36 @@
 package server
 
 import (
+	"time"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
 	"github.com/micro-community/x-edge/node/iobuffer"
 	"github.com/micro/go-micro/v2/codec"
 	raw "github.com/micro/go-micro/v2/codec/bytes"
 	"github.com/micro/go-micro/v2/errors"
 	"github.com/micro/go-micro/v2/transport"
-	"github.com/micro/go-micro/v2/util/socket"
 )
 
 type codecBuffer struct {
 	socket transport.Socket
 	codec  codec.Codec
+	errEnc ErrorEncoder
 	first  bool
 
 	req *transport.Message //buffer the req msg
 	buf *iobuffer.ReadWriteCloser
+
+	// accepted is the time the frame read was extracted and received the time it was read
+	accepted, received time.Time
 }
 
-func newBuffCodec(sock *socket.Socket, c codec.NewCodec) codec.Codec {
+func newBuffCodec(sock transport.Socket, c codec.NewCodec, errEnc ErrorEncoder) codec.Codec {
 	rwc := iobuffer.NewBuffer()
 
 	cb := &codecBuffer{
-		buf:   rwc,
-		codec: c(rwc),
+		buf:    rwc,
+		codec:  c(rwc),
+		errEnc: errEnc,
 		//		req:    req,
 		socket: sock,
 	}
@@ -38,6 +45,11 @@ func (c *codecBuffer) ReadHeader(r *codec.Message, t codec.MessageType) error {
 	if err := c.socket.Recv(&tm); err != nil {
 		return err
 	}
+	c.received = time.Now()
+	c.accepted = c.received
+	if as, ok := c.socket.(interface{ acceptedAt() time.Time }); ok {
+		c.accepted = as.acceptedAt()
+	}
 	// reset the read buffer
 	c.buf.Reset()
 
@@ -59,6 +71,10 @@ func (c *codecBuffer) ReadHeader(r *codec.Message, t codec.MessageType) error {
 	if err := c.codec.ReadHeader(&m, codec.Request); err != nil {
 		return err
 	}
+	// the raw frame is the one transformed, e.g. decrypted
+	if fc, ok := c.codec.(xmlc.FrameCodec); ok {
+		tm.Body = fc.Frame()
+	}
 
 	// fallback for 0.14 and older
 	if len(m.Endpoint) == 0 {
@@ -95,7 +111,8 @@ func (c *codecBuffer) ReadBody(b interface{}) error {
 }
 
 func (c *codecBuffer) Write(r *codec.Message, b interface{}) error {
-	c.buf.Reset()
+	// keep the read buffer, a stream may write before reading the body
+	c.buf.ResetWbuf()
 
 	// create a new message
 	m := &codec.Message{
@@ -112,29 +129,51 @@ func (c *codecBuffer) Write(r *codec.Message, b interface{}) error {
 		m.Header = map[string]string{}
 	}
 
-	if v, ok := b.(*codec.Message); ok {
+	// the frames not encoded by the codec are sealed by it at last
+	sealed := false
+	switch v := b.(type) {
+	case nil:
+		// an error frame is up to the error encoder of device codec
+		if len(m.Error) == 0 {
+			break
+		}
+		if c.errEnc == nil {
+			return nil
+		}
+		body, err := c.errEnc(m.Header, errors.Parse(m.Error))
+		if err != nil {
+			return errors.InternalServerError("node.codec", err.Error())
+		}
+		// suppressed by encoder
+		if body == nil {
+			return nil
+		}
+		m.Body = body
+	case *codec.Message:
 		m.Body = v.Body
+	case *raw.Frame:
+		m.Body = v.Data
+	default:
+		// encode the value by the codec of device
+		if err := c.codec.Write(m, b); err != nil {
+			c.buf.ResetWbuf()
+			return errors.InternalServerError("node.codec", err.Error())
+		}
+		// copy it out, the buffer is reused by the next write
+		m.Body = append([]byte(nil), c.buf.WBytes()...)
+		sealed = true
+	}
+
+	if fc, ok := c.codec.(xmlc.FrameCodec); ok && !sealed && len(m.Body) > 0 {
+		body, err := fc.Seal(m.Header, m.Body)
+		if err != nil {
+			return errors.InternalServerError("node.codec", err.Error())
+		}
+		m.Body = body
 	}
-	// is it a raw frame?
-	// if v, ok := b.(*raw.Frame); ok {
-	// 	body = v.Data
-	// 	// if we have encoded data just send it
-	// } else if len(r.Body) > 0 {
-	// 	body = r.Body
-	// 	// write the body to codec
-	// } else if err := c.codec.Write(m, b); err != nil {
-	// 	c.buf.Reset()
-	// 	// no body to write
-	// 	if err := c.codec.Write(m, nil); err != nil {
-	// 		return err
-	// 	}
-	// } else {
-	// 	// set the body
-	// 	body = c.buf.WBytes()
-	// }
 
 	// Set content type if theres content
-	if len(m.Body) > 0 {
+	if len(m.Body) > 0 && c.req != nil {
 		m.Header["Content-Type"] = c.req.Header["Content-Type"]
 	}
 
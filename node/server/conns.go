@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/micro/go-micro/v2/codec"
+	log "github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/server"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+//Server is the node server holding the connections of devices,
+//the messages from micro services are delivered down to them
+type Server interface {
+	server.Server
+	// Connections returns the ids of devices connected
+	Connections() []string
+	// Deliver encodes a message with the codec of device and sends it down,
+	// msg is a *raw.Frame, a *codec.Message or a value of the device codec
+	// the delivery denied by authorizer is a forbidden error
+	Deliver(device string, hdr map[string]string, msg interface{}) error
+}
+
+//ErrNotConnected is returned when delivering to a device without connection
+var ErrNotConnected = errors.New("device not connected")
+
+type connKey struct{}
+
+// deviceConn is the connection of a device, the sends of sessions
+// and of deliveries are serialised on it
+type deviceConn struct {
+	sync.Mutex
+	transport.Socket
+	conns       *deviceConns
+	contentType string
+	// ids of devices bound to the connection
+	devices map[string]bool
+	// presence of the connection, closeErr is the error it is closed by
+	presence *presence
+	closeErr error
+	// auth of the connection, nil without authenticator
+	auth *deviceAuth
+	// observer of the frames sent
+	observer Observer
+}
+
+func (c *deviceConn) Send(m *transport.Message) error {
+	c.Lock()
+	defer c.Unlock()
+	if err := c.Socket.Send(m); err != nil {
+		return err
+	}
+	c.presence.sent(len(m.Body))
+	c.observer.FrameOut(len(m.Body))
+	c.record(Downlink, "", "", m.Body, nil)
+	return nil
+}
+
+// close the connection by err, such as the shutdown of server
+func (c *deviceConn) close(err error) {
+	c.conns.Lock()
+	if c.closeErr == nil {
+		c.closeErr = err
+	}
+	c.conns.Unlock()
+	c.Socket.Close()
+}
+
+// bind the device id of a frame to the connection
+func (c *deviceConn) bind(device string) {
+	if len(device) == 0 {
+		return
+	}
+	c.conns.Lock()
+	if c.devices[device] {
+		c.conns.Unlock()
+		return
+	}
+	c.devices[device] = true
+	c.conns.conns[device] = c
+	hooks := c.conns.hooks
+	c.conns.Unlock()
+
+	c.presence.notify(PresenceIdentified, []string{device}, nil)
+	for _, fn := range hooks {
+		fn(device, true)
+	}
+}
+
+// release the devices of a connection closed by err
+func (c *deviceConn) release(err error) {
+	c.conns.Lock()
+	delete(c.conns.live, c)
+	if c.closeErr != nil {
+		err = c.closeErr
+	}
+	var devices, released []string
+	for device := range c.devices {
+		devices = append(devices, device)
+		// a device reconnected meanwhile keeps the new connection
+		if c.conns.conns[device] == c {
+			delete(c.conns.conns, device)
+			released = append(released, device)
+		}
+	}
+	c.devices = make(map[string]bool)
+	hooks := c.conns.hooks
+	c.conns.Unlock()
+
+	sort.Strings(devices)
+	c.presence.notify(PresenceClosed, devices, err)
+	for _, device := range released {
+		for _, fn := range hooks {
+			fn(device, false)
+		}
+	}
+}
+
+// deviceConns keeps the connections by device id
+type deviceConns struct {
+	sync.RWMutex
+	conns map[string]*deviceConn
+	hooks []DeviceHook
+	// live connections, devices identified or not
+	live          map[*deviceConn]bool
+	presenceHooks []PresenceHook
+	// authenticator of connections and the frames they have to authenticate
+	authenticator Authenticator
+	authFrames    int
+	// observer of the frames of connections
+	observer Observer
+	// frameHooks record the frames of connections
+	frameHooks []FrameHook
+}
+
+func newDeviceConns() *deviceConns {
+	return &deviceConns{
+		conns: make(map[string]*deviceConn),
+		live:  make(map[*deviceConn]bool),
+	}
+}
+
+// newConn accepts a connection
+func (d *deviceConns) newConn(sock transport.Socket, contentType string) *deviceConn {
+	d.Lock()
+	c := &deviceConn{
+		Socket:      sock,
+		conns:       d,
+		contentType: contentType,
+		devices:     make(map[string]bool),
+		observer:    observerOf(d.observer),
+		presence: &presence{
+			hooks:       d.presenceHooks,
+			local:       sock.Local(),
+			remote:      sock.Remote(),
+			connectedAt: time.Now(),
+		},
+	}
+	if d.authenticator != nil {
+		c.auth = &deviceAuth{
+			handshake: d.authenticator(sock),
+			frames:    d.authFrames,
+		}
+	}
+	d.live[c] = true
+	d.Unlock()
+
+	c.presence.notify(PresenceAccepted, nil, nil)
+
+	// the handshake may challenge the device first
+	if c.auth != nil {
+		if challenge := c.auth.handshake.Challenge(); len(challenge) > 0 {
+			if err := c.Send(&transport.Message{Body: challenge}); err != nil {
+				log.Errorf("unable to challenge %s: %v", sock.Remote(), err)
+			}
+		}
+	}
+	return c
+}
+
+// shutdown closes the live connections
+func (d *deviceConns) shutdown() {
+	d.RLock()
+	live := make([]*deviceConn, 0, len(d.live))
+	for c := range d.live {
+		live = append(live, c)
+	}
+	d.RUnlock()
+
+	for _, c := range live {
+		c.close(errShutdown)
+	}
+}
+
+func (d *deviceConns) get(device string) (*deviceConn, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	c, ok := d.conns[device]
+	return c, ok
+}
+
+func (d *deviceConns) list() []string {
+	d.RLock()
+	defer d.RUnlock()
+	devices := make([]string, 0, len(d.conns))
+	for device := range d.conns {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// bindDevice binds the device of a frame to the connection it came from,
+// a connection authenticated is bound to its account only
+func bindDevice(ctx context.Context, hdr map[string]string) {
+	if c, ok := ctx.Value(connKey{}).(*deviceConn); ok && c.auth == nil {
+		c.bind(hdr["NAME"])
+	}
+}
+
+//Connections returns the ids of devices connected
+func (s *nodeServer) Connections() []string {
+	return s.conns.list()
+}
+
+//Deliver encodes a message with the codec of device and sends it down
+func (s *nodeServer) Deliver(device string, hdr map[string]string, msg interface{}) error {
+	c, ok := s.conns.get(device)
+	if !ok {
+		return ErrNotConnected
+	}
+	if hdr == nil {
+		hdr = make(map[string]string)
+	}
+	if err := s.authorizeDelivery(device, hdr, msg); err != nil {
+		return err
+	}
+	return s.send(c, device, hdr, msg)
+}
+
+// send encodes a message with the codec of a connection and sends it down
+func (s *nodeServer) send(c *deviceConn, device string, hdr map[string]string, msg interface{}) error {
+	// the codec of device may need the device, e.g. to encrypt by its key
+	if _, ok := hdr["NAME"]; !ok && len(device) > 0 {
+		h := map[string]string{"NAME": device}
+		for k, v := range hdr {
+			h[k] = v
+		}
+		hdr = h
+	}
+	if hdr == nil {
+		hdr = make(map[string]string)
+	}
+	cc := s.newCodec(c.contentType, c)
+	return cc.Write(&codec.Message{Type: codec.Event, Header: hdr}, msg)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"encoding/xml"
+	"io"
+	"testing"
+	"time"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	"github.com/micro/go-micro/v2/codec"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	"github.com/micro/go-micro/v2/server"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+type command struct {
+	XMLName xml.Name `xml:"PROTOCOL"`
+	Name    string   `xml:"NAME"`
+	Type    string   `xml:"TYPE"`
+}
+
+func TestDeliverToDevice(t *testing.T) {
+	hooks := make(chan string, 2)
+	watch := func(device string, connected bool) {
+		if connected {
+			hooks <- "+" + device
+		} else {
+			hooks <- "-" + device
+		}
+	}
+
+	srv := NewServer(WatchDevices(watch)).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.Deliver("meter-42", nil, &raw.Frame{Data: []byte("<PING/>")}); err != ErrNotConnected {
+		t.Fatalf("Expected %v, got %v", ErrNotConnected, err)
+	}
+
+	sock := newFakeSocket()
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+
+	select {
+	case h := <-hooks:
+		if h != "+meter-42" {
+			t.Fatalf("Expected meter-42 connected, got %s", h)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("device was not bound")
+	}
+	// the reply of frame
+	<-sock.sent
+
+	if c := srv.Connections(); len(c) != 1 || c[0] != "meter-42" {
+		t.Fatalf("Expected connections [meter-42], got %v", c)
+	}
+
+	if err := srv.Deliver("meter-42", nil, &raw.Frame{Data: []byte("<PING/>")}); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-sock.sent; string(m.Body) != "<PING/>" {
+		t.Fatalf("Expected raw frame <PING/>, got %s", m.Body)
+	}
+
+	if err := srv.Deliver("meter-42", nil, &command{Name: "meter-42", Type: "Reset"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "<PROTOCOL><NAME>meter-42</NAME><TYPE>Reset</TYPE></PROTOCOL>"
+	if m := <-sock.sent; string(m.Body) != expected {
+		t.Fatalf("Expected %s, got %s", expected, m.Body)
+	}
+
+	close(sock.recv)
+	<-done
+
+	select {
+	case h := <-hooks:
+		if h != "-meter-42" {
+			t.Fatalf("Expected meter-42 disconnected, got %s", h)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("device was not released")
+	}
+	if c := srv.Connections(); len(c) != 0 {
+		t.Fatalf("Expected no connections, got %v", c)
+	}
+}
+
+// sealCodec marks the frames sealed by the device they are written to
+type sealCodec struct {
+	codec.Codec
+	frame []byte
+}
+
+func (s *sealCodec) ReadHeader(m *codec.Message, t codec.MessageType) error {
+	s.frame = m.Body
+	return s.Codec.ReadHeader(m, t)
+}
+
+func (s *sealCodec) Frame() []byte {
+	return s.frame
+}
+
+func (s *sealCodec) Seal(hdr map[string]string, frame []byte) ([]byte, error) {
+	return append([]byte("sealed:"+hdr["NAME"]+":"), frame...), nil
+}
+
+func TestDeliverSealed(t *testing.T) {
+	newCodec := func(rwc io.ReadWriteCloser) codec.Codec {
+		return &sealCodec{Codec: xmlc.NewCodec(rwc)}
+	}
+	srv := NewServer(server.Codec(xmlc.DefaultContentType, newCodec)).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+	// the reply of frame is encoded by the codec
+	<-sock.sent
+
+	// the raw frames delivered are sealed to the device
+	if err := srv.Deliver("meter-42", nil, &raw.Frame{Data: []byte("<PING/>")}); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-sock.sent; string(m.Body) != "sealed:meter-42:<PING/>" {
+		t.Fatalf("Expected frame sealed, got %s", m.Body)
+	}
+
+	close(sock.recv)
+	<-done
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/micro/go-micro/v2/codec"
+)
+
+//FrameRecord of a frame received from or sent to a device
+type FrameRecord struct {
+	Time      time.Time
+	Direction Direction
+	// Conn is the id "local-remote" of connection
+	Conn string
+	// Device of the frame, the one bound to the connection for downlink frames
+	Device string
+	// Type of packet, empty for downlink frames
+	Type  string
+	Frame []byte
+	// Error the uplink frame is served by
+	Error string
+}
+
+//FrameHook is called on the frames of devices, it must not block
+type FrameHook func(FrameRecord)
+
+// record a frame of the connection by the frame hooks
+func (c *deviceConn) record(dir Direction, device, packetType string, frame []byte, err error) {
+	c.conns.RLock()
+	hooks := c.conns.frameHooks
+	if len(device) == 0 {
+		for d := range c.devices {
+			device = d
+			break
+		}
+	}
+	c.conns.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+
+	r := FrameRecord{
+		Time:      time.Now(),
+		Direction: dir,
+		Conn:      c.id(),
+		Device:    device,
+		Type:      packetType,
+		Frame:     append([]byte(nil), frame...),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	for _, fn := range hooks {
+		fn(r)
+	}
+}
+
+// recordFrame records an uplink frame served by the error it is answered with
+func recordFrame(ctx context.Context, c codec.Reader, msg *codec.Message, err error) {
+	conn, ok := ctx.Value(connKey{}).(*deviceConn)
+	if !ok || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return
+	}
+	var device string
+	if msg != nil && msg.Header != nil {
+		device = msg.Header["NAME"]
+	}
+	// the frames dropped or consumed by handshake are served well
+	if err == errDropped || err == errHandshake {
+		err = nil
+	}
+	conn.record(Uplink, device, packetTypeOf(msg), frameRead(c), err)
+}
+
+// packetTypeOf a message decoded, the TYPE header once it is resolved to a handler
+func packetTypeOf(msg *codec.Message) string {
+	if msg == nil {
+		return ""
+	}
+	if packetType, ok := msg.Header["TYPE"]; ok {
+		return packetType
+	}
+	return msg.Method
+}
+
+// frameRead returns the frame the codec of node server read last
+func frameRead(c codec.Reader) []byte {
+	if cb, ok := c.(*codecBuffer); ok && cb.req != nil {
+		return cb.req.Body
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/micro/go-micro/v2/transport"
+)
+
+func TestWatchFrames(t *testing.T) {
+	var mu sync.Mutex
+	var records []FrameRecord
+	obs1, obs2 := new(countObserver), new(countObserver)
+	srv := NewServer(
+		Observe(obs1),
+		Observe(obs2),
+		WatchFrames(func(r FrameRecord) {
+			mu.Lock()
+			defer mu.Unlock()
+			records = append(records, r)
+		}),
+	).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	reply := sent(t, sock)
+	sock.recv <- &transport.Message{Body: []byte(strings.Replace(testFrame, "<TYPE>Event</TYPE>", "<TYPE>Unknown</TYPE>", 1))}
+	sent(t, sock)
+	close(sock.recv)
+	<-done
+
+	for _, obs := range []*countObserver{obs1, obs2} {
+		obs.Lock()
+		if obs.framesIn != 2 || len(obs.ended) != 1 {
+			t.Fatalf("Expected every observer told, got %d frames and %v", obs.framesIn, obs.ended)
+		}
+		obs.Unlock()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(records) != 4 {
+		t.Fatalf("Expected 4 frames recorded, got %+v", records)
+	}
+	var up, down []FrameRecord
+	for _, r := range records {
+		if r.Device != "meter-42" || r.Conn != "127.0.0.1:8000-127.0.0.1:9000" {
+			t.Fatalf("Unexpected frame recorded %+v", r)
+		}
+		if r.Direction == Uplink {
+			up = append(up, r)
+		} else {
+			down = append(down, r)
+		}
+	}
+	if len(up) != 2 || string(up[0].Frame) != testFrame || up[0].Type != "Event" || len(up[0].Error) > 0 {
+		t.Fatalf("Unexpected uplink frame %+v", up)
+	}
+	if up[1].Type != "Unknown" || len(up[1].Error) == 0 {
+		t.Fatalf("Expected uplink frame of packet type unknown failed, got %+v", up[1])
+	}
+	if len(down) != 2 || string(down[0].Frame) != reply {
+		t.Fatalf("Unexpected downlink frame %+v", down)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrNotListening is the error of a node server not started
+	ErrNotListening = errors.New("not listening")
+
+	errNotAccepting = errors.New("stopped accepting")
+)
+
+//Listening tells whether the node server is accepting connections, it returns
+//the error the listener of transport failed with otherwise
+func (s *nodeServer) Listening() error {
+	s.RLock()
+	defer s.RUnlock()
+	if !s.started {
+		return ErrNotListening
+	}
+	if s.acceptErr != nil {
+		return fmt.Errorf("%s on %s: %w", s.opts.Transport, s.listener.Addr(), s.acceptErr)
+	}
+	return nil
+}
+
+//Accepting tells whether the accepting loop of node server is alive, it fails
+//once the loop stopped for good, a node server not started is alive yet
+func (s *nodeServer) Accepting() error {
+	s.RLock()
+	defer s.RUnlock()
+	if s.started && s.acceptErr == errNotAccepting {
+		return fmt.Errorf("%s on %s: %w", s.opts.Transport, s.listener.Addr(), s.acceptErr)
+	}
+	return nil
+}
+
+// acceptFailed records the error of accepting loop, nil once it accepts again
+func (s *nodeServer) acceptFailed(err error) {
+	s.Lock()
+	s.acceptErr = err
+	s.Unlock()
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2/server"
+	"github.com/micro/go-micro/v2/transport"
+	"github.com/micro/go-micro/v2/transport/memory"
+)
+
+func TestListening(t *testing.T) {
+	srv := NewServer(server.Transport(memory.NewTransport()), server.Address(":0")).(*nodeServer)
+	if err := srv.Listening(); err != ErrNotListening {
+		t.Fatalf("Expected %v before start, got %v", ErrNotListening, err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Listening(); err != nil {
+		t.Fatalf("Expected listening, got %v", err)
+	}
+
+	if err := srv.Accepting(); err != nil {
+		t.Fatalf("Expected accepting, got %v", err)
+	}
+
+	// a transient error fails the readiness, the loop stopped the liveness
+	srv.acceptFailed(errors.New("too many open files"))
+	if err := srv.Listening(); err == nil {
+		t.Fatal("Expected error of accepting loop")
+	}
+	if err := srv.Accepting(); err != nil {
+		t.Fatalf("Expected accepting loop alive, got %v", err)
+	}
+	srv.acceptFailed(errNotAccepting)
+	if err := srv.Accepting(); !errors.Is(err, errNotAccepting) {
+		t.Fatalf("Expected accepting loop stopped, got %v", err)
+	}
+	srv.acceptFailed(nil)
+
+	if err := srv.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Listening(); err != ErrNotListening {
+		t.Fatalf("Expected %v after stop, got %v", ErrNotListening, err)
+	}
+}
+
+// failingTransport listens by a listener failing to accept
+type failingTransport struct {
+	transport.Transport
+}
+
+func (failingTransport) Listen(addr string, opts ...transport.ListenOption) (transport.Listener, error) {
+	return failingListener{}, nil
+}
+
+func (failingTransport) String() string {
+	return "failing"
+}
+
+type failingListener struct{}
+
+func (failingListener) Addr() string                        { return "failing:0" }
+func (failingListener) Close() error                        { return nil }
+func (failingListener) Accept(func(transport.Socket)) error { return errors.New("accept failed") }
+
+func TestListeningAcceptFailed(t *testing.T) {
+	srv := NewServer(server.Transport(failingTransport{}), server.Address(":0")).(*nodeServer)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// the error of the first accept is not reset by starting
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for srv.Listening() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected error of accepting loop")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/micro/go-micro/v2/codec"
+	merrors "github.com/micro/go-micro/v2/errors"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+//LimitAction is what is done to a frame over the rate limit
+type LimitAction string
+
+//The actions of frames over the rate limit
+const (
+	// LimitDrop drops the frame, it is neither handled nor answered
+	LimitDrop LimitAction = "drop"
+	// LimitBusy answers the frame by a busy error frame
+	LimitBusy LimitAction = "busy"
+	// LimitDelay handles the frame once the delay passed
+	LimitDelay LimitAction = "delay"
+	// LimitDisconnect closes the connection of device
+	LimitDisconnect LimitAction = "disconnect"
+)
+
+//StatusBusy is the code of busy error frame
+const StatusBusy = 429
+
+//Throttle of a frame over the rate limit
+type Throttle struct {
+	Action LimitAction
+	// Delay of the frame for LimitDelay
+	Delay time.Duration
+	// Limit exceeded, such as "device", "remote" or "type"
+	Limit string
+}
+
+//Limiter decides the throttle of an uplink frame, nil for a frame within the limits
+type Limiter func(ctx context.Context, a Access) *Throttle
+
+//errRateLimited closes the connection of a device over the rate limit
+var errRateLimited = errors.New("rate limit exceeded")
+
+// limit an uplink frame of a connection by the limiter
+func (router *Routing) limit(ctx context.Context, msg *codec.Message) error {
+	router.mu.Lock()
+	fn := router.limiter
+	router.mu.Unlock()
+	if fn == nil {
+		return nil
+	}
+
+	a := Access{
+		Direction: Uplink,
+		Type:      msg.Method,
+		Header:    msg.Header,
+	}
+	c, _ := ctx.Value(connKey{}).(*deviceConn)
+	if c != nil {
+		a.Remote = c.Remote()
+		if c.auth != nil {
+			c.auth.Lock()
+			a.Account = c.auth.account
+			c.auth.Unlock()
+		}
+	}
+	t := fn(ctx, a)
+	if t == nil {
+		return nil
+	}
+
+	switch t.Action {
+	case LimitDelay:
+		timer := time.NewTimer(t.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case LimitBusy:
+		return merrors.New("node.limit", "rate limit of "+t.Limit+" exceeded", StatusBusy)
+	case LimitDisconnect:
+		log.Infof("closing %s over rate limit of %s", a.Remote, t.Limit)
+		if c != nil {
+			c.close(errRateLimited)
+		}
+	}
+	return errDropped
+}
+
+func isBusy(err error) bool {
+	merr, ok := err.(*merrors.Error)
+	return ok && merr.Code == StatusBusy
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2/transport"
+)
+
+// limitAfter throttles the frames of a connection after the first ones
+func limitAfter(n int, action LimitAction) Limiter {
+	frames := 0
+	return func(ctx context.Context, a Access) *Throttle {
+		frames++
+		if frames <= n {
+			return nil
+		}
+		return &Throttle{Action: action, Limit: "device", Delay: 10 * time.Millisecond}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	events := make(chan PresenceEvent, 8)
+	srv := NewServer(
+		RateLimit(limitAfter(1, LimitBusy)),
+		WatchPresence(func(ev PresenceEvent) { events <- ev }),
+	).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	if r := sent(t, sock); strings.Contains(r, "<ERR>") {
+		t.Fatalf("Expected reply of frame within limit, got %s", r)
+	}
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	if e := sent(t, sock); !strings.Contains(e, "<CODE>429</CODE>") {
+		t.Fatalf("Expected busy frame, got %s", e)
+	}
+
+	// the device over the limit is disconnected
+	srv.Init(RateLimit(limitAfter(0, LimitDisconnect)))
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	for closed := false; !closed; time.Sleep(time.Millisecond) {
+		srv.conns.RLock()
+		for c := range srv.conns.live {
+			closed = c.closeErr != nil
+		}
+		srv.conns.RUnlock()
+	}
+	close(sock.recv)
+	<-done
+	if len(sock.sent) != 0 {
+		t.Fatalf("Unexpected frame sent to device disconnected")
+	}
+	for ev := range events {
+		if ev.Type != PresenceClosed {
+			continue
+		}
+		if ev.Reason != ReasonRateLimited {
+			t.Fatalf("Expected closed as %s, got %+v", ReasonRateLimited, ev)
+		}
+		break
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	srv := NewServer(RateLimit(limitAfter(0, LimitDelay))).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+
+	start := time.Now()
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	if r := sent(t, sock); strings.Contains(r, "<ERR>") {
+		t.Fatalf("Expected reply of frame delayed, got %s", r)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Fatalf("Expected frame delayed, handled in %v", d)
+	}
+	close(sock.recv)
+	<-done
+}
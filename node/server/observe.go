@@ -0,0 +1,104 @@
+package server
+
+import (
+	"time"
+)
+
+//Observer is told what the node server does, such as to count it as metrics,
+//the methods are called concurrently on the paths of frames and must not block
+type Observer interface {
+	// FrameIn of bytes received from a device
+	FrameIn(bytes int)
+	// FrameOut of bytes sent to a device
+	FrameOut(bytes int)
+	// ExtractError of the extractor unable to split the frames of a connection
+	ExtractError(err error)
+	// DecodeError of a frame the codec failed to decode
+	DecodeError(err error)
+	// RoutingMiss of a packet type neither routed nor handled
+	RoutingMiss(packetType string)
+	// HandlerStart and HandlerEnd of the handler "Service.Method" of a frame
+	HandlerStart(method string)
+	HandlerEnd(method string, took time.Duration, err error)
+	// ReplyError of a reply or error frame failed to write
+	ReplyError(method string, err error)
+}
+
+// nopObserver observes nothing
+type nopObserver struct{}
+
+func (nopObserver) FrameIn(int)                             {}
+func (nopObserver) FrameOut(int)                            {}
+func (nopObserver) ExtractError(error)                      {}
+func (nopObserver) DecodeError(error)                       {}
+func (nopObserver) RoutingMiss(string)                      {}
+func (nopObserver) HandlerStart(string)                     {}
+func (nopObserver) HandlerEnd(string, time.Duration, error) {}
+func (nopObserver) ReplyError(string, error)                {}
+
+// multiObserver tells every observer in turn
+type multiObserver []Observer
+
+func (m multiObserver) FrameIn(bytes int) {
+	for _, o := range m {
+		o.FrameIn(bytes)
+	}
+}
+
+func (m multiObserver) FrameOut(bytes int) {
+	for _, o := range m {
+		o.FrameOut(bytes)
+	}
+}
+
+func (m multiObserver) ExtractError(err error) {
+	for _, o := range m {
+		o.ExtractError(err)
+	}
+}
+
+func (m multiObserver) DecodeError(err error) {
+	for _, o := range m {
+		o.DecodeError(err)
+	}
+}
+
+func (m multiObserver) RoutingMiss(packetType string) {
+	for _, o := range m {
+		o.RoutingMiss(packetType)
+	}
+}
+
+func (m multiObserver) HandlerStart(method string) {
+	for _, o := range m {
+		o.HandlerStart(method)
+	}
+}
+
+func (m multiObserver) HandlerEnd(method string, took time.Duration, err error) {
+	for _, o := range m {
+		o.HandlerEnd(method, took, err)
+	}
+}
+
+func (m multiObserver) ReplyError(method string, err error) {
+	for _, o := range m {
+		o.ReplyError(method, err)
+	}
+}
+
+// observerOf returns the observer set, which is never nil
+func observerOf(o Observer) Observer {
+	if o == nil {
+		return nopObserver{}
+	}
+	return o
+}
+
+// methodOf names the handler of a method, such as "PROTOCOLSERVER.Event"
+func methodOf(s *service, mtype *methodType) string {
+	if s == nil || mtype == nil {
+		return ""
+	}
+	return s.name + "." + mtype.method.Name
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2/transport"
+)
+
+// countObserver counts what it is told
+type countObserver struct {
+	sync.Mutex
+	nopObserver
+	framesIn, framesOut int
+	misses              []string
+	started, ended      []string
+}
+
+func (o *countObserver) FrameIn(int) {
+	o.Lock()
+	defer o.Unlock()
+	o.framesIn++
+}
+
+func (o *countObserver) FrameOut(int) {
+	o.Lock()
+	defer o.Unlock()
+	o.framesOut++
+}
+
+func (o *countObserver) RoutingMiss(packetType string) {
+	o.Lock()
+	defer o.Unlock()
+	o.misses = append(o.misses, packetType)
+}
+
+func (o *countObserver) HandlerStart(method string) {
+	o.Lock()
+	defer o.Unlock()
+	o.started = append(o.started, method)
+}
+
+func (o *countObserver) HandlerEnd(method string, took time.Duration, err error) {
+	o.Lock()
+	defer o.Unlock()
+	o.ended = append(o.ended, method)
+}
+
+func TestObserve(t *testing.T) {
+	obs := new(countObserver)
+	srv := NewServer(Observe(obs)).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	sent(t, sock)
+	sock.recv <- &transport.Message{Body: []byte(strings.Replace(testFrame, "<TYPE>Event</TYPE>", "<TYPE>Unknown</TYPE>", 1))}
+	if e := sent(t, sock); !strings.Contains(e, "<ERR>") {
+		t.Fatalf("Expected error frame of packet type unknown, got %s", e)
+	}
+	close(sock.recv)
+	<-done
+
+	obs.Lock()
+	defer obs.Unlock()
+	if obs.framesIn != 2 || obs.framesOut != 2 {
+		t.Fatalf("Expected 2 frames in and out, got %d and %d", obs.framesIn, obs.framesOut)
+	}
+	if len(obs.misses) != 1 || obs.misses[0] != "Unknown" {
+		t.Fatalf("Expected routing miss of Unknown, got %v", obs.misses)
+	}
+	if len(obs.started) != 1 || len(obs.ended) != 1 || obs.ended[0] != "PROTOCOLSERVER.Event" {
+		t.Fatalf("Expected handler PROTOCOLSERVER.Event observed, got %v and %v", obs.started, obs.ended)
+	}
+}
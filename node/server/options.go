@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"context"
 
+	xmlc "github.com/micro-community/x-edge/node/codec"
+
 	"github.com/micro/go-micro/v2/codec"
 	"github.com/micro/go-micro/v2/server"
 	"github.com/micro/go-micro/v2/transport"
@@ -15,6 +17,49 @@ type DataExtractor = bufio.SplitFunc
 //DataExtractorFuncKey for ExtractorFunc
 type DataExtractorFuncKey struct{}
 
+type routesKey struct{}
+type fallbackKey struct{}
+type errorEncodersKey struct{}
+type oneWayKey struct{}
+type topicsKey struct{}
+type deviceHooksKey struct{}
+type frameFilterKey struct{}
+type presenceHooksKey struct{}
+type authenticatorKey struct{}
+type authorizerKey struct{}
+type limiterKey struct{}
+type observerKey struct{}
+type frameHooksKey struct{}
+
+//Verdict of a frame evaluated by a filter before it is dispatched
+type Verdict struct {
+	// Drop the frame, it is neither handled nor answered
+	Drop bool
+	// Route the frame to the endpoint "Service.Method" instead of its handler
+	Route string
+	// Topics the frame is published to besides
+	Topics []string
+	// Annotations are added to the headers of frame
+	Annotations map[string]string
+}
+
+//FrameFilter evaluates a decoded frame before it is dispatched to a handler
+type FrameFilter func(ctx context.Context, msg *codec.Message) Verdict
+
+//DeviceHook is called when a device is bound to a connection by its frames
+//and when the connection is closed
+type DeviceHook func(device string, connected bool)
+
+//ErrorEncoder turns the error of a request into a protocol specific frame
+//answered to the device, the header is the one of decoded request, no frame
+//is sent if it returns a nil body
+type ErrorEncoder func(hdr map[string]string, err error) ([]byte, error)
+
+//DefaultErrorEncoders for the codecs of device
+var DefaultErrorEncoders = map[string]ErrorEncoder{
+	xmlc.DefaultContentType: xmlc.EncodeError,
+}
+
 // type stubRouter struct {
 // 	h func(context.Context, Request, interface{}) error
 // }
@@ -73,3 +118,320 @@ func Extractor(dex DataExtractor) server.Option {
 		o.Context = context.WithValue(o.Context, DataExtractorFuncKey{}, dex)
 	}
 }
+
+// Route maps a packet type to the endpoint "Service.Method" of a handler,
+// e.g. Route("1", "ProtocolServer.Event") for the frames of <TYPE>1</TYPE>
+func Route(packetType, endpoint string) server.Option {
+	return Routes(map[string]string{packetType: endpoint})
+}
+
+// Routes adds a routing table of packet types to handler endpoints
+func Routes(table map[string]string) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		routes := make(map[string]string)
+		for k, v := range routesFromContext(o.Context) {
+			routes[k] = v
+		}
+		for k, v := range table {
+			routes[k] = v
+		}
+		o.Context = context.WithValue(o.Context, routesKey{}, routes)
+	}
+}
+
+// Fallback sets the endpoint "Service.Method" which handles
+// the packet types matched neither by a route nor by a method
+func Fallback(endpoint string) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, fallbackKey{}, endpoint)
+	}
+}
+
+func routesFromContext(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	routes, _ := ctx.Value(routesKey{}).(map[string]string)
+	return routes
+}
+
+func fallbackFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	fallback, _ := ctx.Value(fallbackKey{}).(string)
+	return fallback
+}
+
+// ErrorResponse sets the encoder of error frames for a content type,
+// a nil encoder suppresses the error frames of fire-and-forget protocols
+func ErrorResponse(contentType string, enc ErrorEncoder) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		encoders := make(map[string]ErrorEncoder)
+		for k, v := range errorEncodersFromContext(o.Context) {
+			encoders[k] = v
+		}
+		encoders[contentType] = enc
+		o.Context = context.WithValue(o.Context, errorEncodersKey{}, encoders)
+	}
+}
+
+func errorEncodersFromContext(ctx context.Context) map[string]ErrorEncoder {
+	if ctx == nil {
+		return nil
+	}
+	encoders, _ := ctx.Value(errorEncodersKey{}).(map[string]ErrorEncoder)
+	return encoders
+}
+
+// OneWay marks the methods of a handler as one-way, their replies are
+// not sent to device. A method taking no reply is one-way already.
+func OneWay(methods ...string) server.HandlerOption {
+	return func(o *server.HandlerOptions) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]map[string]string)
+		}
+		for _, m := range methods {
+			if o.Metadata[m] == nil {
+				o.Metadata[m] = make(map[string]string)
+			}
+			o.Metadata[m]["oneway"] = "true"
+		}
+	}
+}
+
+// OneWayTypes marks packet types as one-way, the frames of them
+// are handled without sending the reply back to device
+func OneWayTypes(packetTypes ...string) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		oneway := make(map[string]bool)
+		for k, v := range oneWayFromContext(o.Context) {
+			oneway[k] = v
+		}
+		for _, t := range packetTypes {
+			oneway[t] = true
+		}
+		o.Context = context.WithValue(o.Context, oneWayKey{}, oneway)
+	}
+}
+
+func oneWayFromContext(ctx context.Context) map[string]bool {
+	if ctx == nil {
+		return nil
+	}
+	oneway, _ := ctx.Value(oneWayKey{}).(map[string]bool)
+	return oneway
+}
+
+// Publish publishes the frames of a packet type to local topics,
+// the subscribers of them get the decoded payload
+func Publish(packetType string, topics ...string) server.Option {
+	return Topics(map[string][]string{packetType: topics})
+}
+
+// Topics adds a table of packet types to the local topics they are published to
+func Topics(table map[string][]string) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		topics := make(map[string][]string)
+		for k, v := range topicsFromContext(o.Context) {
+			topics[k] = v
+		}
+		for k, v := range table {
+			topics[k] = append(append([]string(nil), topics[k]...), v...)
+		}
+		o.Context = context.WithValue(o.Context, topicsKey{}, topics)
+	}
+}
+
+func topicsFromContext(ctx context.Context) map[string][]string {
+	if ctx == nil {
+		return nil
+	}
+	topics, _ := ctx.Value(topicsKey{}).(map[string][]string)
+	return topics
+}
+
+// WatchDevices adds a hook of devices connected and disconnected
+func WatchDevices(fn DeviceHook) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		hooks := append([]DeviceHook(nil), deviceHooksFromContext(o.Context)...)
+		hooks = append(hooks, fn)
+		o.Context = context.WithValue(o.Context, deviceHooksKey{}, hooks)
+	}
+}
+
+func deviceHooksFromContext(ctx context.Context) []DeviceHook {
+	if ctx == nil {
+		return nil
+	}
+	hooks, _ := ctx.Value(deviceHooksKey{}).([]DeviceHook)
+	return hooks
+}
+
+// FilterFrames sets the filter evaluating the decoded frames before dispatch
+func FilterFrames(fn FrameFilter) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, frameFilterKey{}, fn)
+	}
+}
+
+func frameFilterFromContext(ctx context.Context) FrameFilter {
+	if ctx == nil {
+		return nil
+	}
+	fn, _ := ctx.Value(frameFilterKey{}).(FrameFilter)
+	return fn
+}
+
+// WatchPresence adds a hook of the connections accepted, identified and closed
+func WatchPresence(fn PresenceHook) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		hooks := append([]PresenceHook(nil), presenceHooksFromContext(o.Context)...)
+		hooks = append(hooks, fn)
+		o.Context = context.WithValue(o.Context, presenceHooksKey{}, hooks)
+	}
+}
+
+func presenceHooksFromContext(ctx context.Context) []PresenceHook {
+	if ctx == nil {
+		return nil
+	}
+	hooks, _ := ctx.Value(presenceHooksKey{}).([]PresenceHook)
+	return hooks
+}
+
+type authOptions struct {
+	fn     Authenticator
+	frames int
+}
+
+// Authenticate sets the authenticator of device connections, the first frames
+// of a session have to authenticate, DefaultAuthFrames if frames is not positive.
+// Until then the frames are rejected and the session is closed at last.
+// Once authenticated, the connection is bound to the account and the frames
+// naming another device are forbidden.
+func Authenticate(fn Authenticator, frames int) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		if frames <= 0 {
+			frames = DefaultAuthFrames
+		}
+		o.Context = context.WithValue(o.Context, authenticatorKey{}, authOptions{fn: fn, frames: frames})
+	}
+}
+
+func authenticatorFromContext(ctx context.Context) (Authenticator, int) {
+	if ctx == nil {
+		return nil, 0
+	}
+	a, _ := ctx.Value(authenticatorKey{}).(authOptions)
+	return a.fn, a.frames
+}
+
+// Authorize sets the authorizer of the frames sent by devices and delivered to them
+func Authorize(fn Authorizer) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, authorizerKey{}, fn)
+	}
+}
+
+func authorizerFromContext(ctx context.Context) Authorizer {
+	if ctx == nil {
+		return nil
+	}
+	fn, _ := ctx.Value(authorizerKey{}).(Authorizer)
+	return fn
+}
+
+// RateLimit sets the limiter throttling the frames sent by devices
+func RateLimit(fn Limiter) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, limiterKey{}, fn)
+	}
+}
+
+func limiterFromContext(ctx context.Context) Limiter {
+	if ctx == nil {
+		return nil
+	}
+	fn, _ := ctx.Value(limiterKey{}).(Limiter)
+	return fn
+}
+
+// Observe adds an observer of frames served, such as the one of metrics
+func Observe(obs Observer) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		observers, _ := o.Context.Value(observerKey{}).(multiObserver)
+		observers = append(append(multiObserver(nil), observers...), obs)
+		o.Context = context.WithValue(o.Context, observerKey{}, observers)
+	}
+}
+
+func observerFromContext(ctx context.Context) Observer {
+	if ctx == nil {
+		return nil
+	}
+	observers, _ := ctx.Value(observerKey{}).(multiObserver)
+	switch len(observers) {
+	case 0:
+		return nil
+	case 1:
+		return observers[0]
+	}
+	return observers
+}
+
+// WatchFrames adds a hook of the frames received from and sent to devices
+func WatchFrames(fn FrameHook) server.Option {
+	return func(o *server.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		hooks := append([]FrameHook(nil), frameHooksFromContext(o.Context)...)
+		hooks = append(hooks, fn)
+		o.Context = context.WithValue(o.Context, frameHooksKey{}, hooks)
+	}
+}
+
+func frameHooksFromContext(ctx context.Context) []FrameHook {
+	if ctx == nil {
+		return nil
+	}
+	hooks, _ := ctx.Value(frameHooksKey{}).([]FrameHook)
+	return hooks
+}
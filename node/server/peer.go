@@ -0,0 +1,43 @@
+package server
+
+import (
+	"strings"
+
+	nts "github.com/micro-community/x-edge/node/transport"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+//The headers of the client certificate verified, they are added to the
+//request metadata of the frames of a mutual TLS connection
+const (
+	PeerSubjectHeader     = "Peer-Subject"
+	PeerCommonNameHeader  = "Peer-Common-Name"
+	PeerSANsHeader        = "Peer-Sans"
+	PeerFingerprintHeader = "Peer-Fingerprint"
+)
+
+// peerHeader resolves the headers of peer once, the TLS handshake
+// has been done when the first frame is received
+type peerHeader struct {
+	resolved bool
+	hdr      map[string]string
+}
+
+func (p *peerHeader) header(sock transport.Socket) map[string]string {
+	if p.resolved {
+		return p.hdr
+	}
+	p.resolved = true
+
+	id, err := nts.PeerOf(sock)
+	if err != nil {
+		return nil
+	}
+	p.hdr = map[string]string{
+		PeerSubjectHeader:     id.Subject,
+		PeerCommonNameHeader:  id.CommonName,
+		PeerSANsHeader:        strings.Join(id.SANs, ","),
+		PeerFingerprintHeader: id.Fingerprint,
+	}
+	return p.hdr
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	nts "github.com/micro-community/x-edge/node/transport"
+)
+
+//PresenceType of the lifecycle event of a connection
+type PresenceType string
+
+//The lifecycle events of a connection
+const (
+	// PresenceAccepted when the connection is accepted
+	PresenceAccepted PresenceType = "accepted"
+	// PresenceIdentified when a device is bound to the connection by its frames
+	PresenceIdentified PresenceType = "identified"
+	// PresenceClosed when the connection is closed
+	PresenceClosed PresenceType = "closed"
+)
+
+//The reasons of a connection closed
+const (
+	ReasonEOF      = "eof"
+	ReasonTimeout  = "timeout"
+	ReasonError    = "error"
+	ReasonShutdown = "shutdown"
+	// ReasonUnauthorized when the device failed to authenticate
+	ReasonUnauthorized = "unauthorized"
+	// ReasonRateLimited when the device exceeded the rate limit
+	ReasonRateLimited = "rate_limited"
+	// ReasonDisconnected when an operator disconnected the device
+	ReasonDisconnected = "disconnected"
+	// ReasonExtract when the transport failed to extract the frames of device
+	ReasonExtract = "extract"
+)
+
+//PresenceEvent tells when a device comes or goes
+type PresenceEvent struct {
+	Type PresenceType
+	// Conn is the id "local-remote" of connection
+	Conn   string
+	Local  string
+	Remote string
+	// Device identified, or the devices bound to a connection closed
+	Devices []string
+	// Reason and Error of a connection closed
+	Reason string
+	Error  string
+	// Time of the event and ConnectedAt of the connection accepted
+	Time        time.Time
+	ConnectedAt time.Time
+	// Duration, BytesIn and BytesOut of the connection so far
+	Duration time.Duration
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+//PresenceHook is called on the lifecycle events of device connections
+type PresenceHook func(PresenceEvent)
+
+//errShutdown closes the connections of a server stopped
+var errShutdown = errors.New("server shutdown")
+
+// closeReason classifies the error a connection is closed by
+func closeReason(err error) string {
+	var nerr net.Error
+	switch {
+	case err == nil, err == io.EOF:
+		return ReasonEOF
+	case err == errShutdown:
+		return ReasonShutdown
+	case err == errUnauthenticated:
+		return ReasonUnauthorized
+	case err == errRateLimited:
+		return ReasonRateLimited
+	case err == errDisconnected:
+		return ReasonDisconnected
+	case errors.Is(err, nts.ErrExtract):
+		return ReasonExtract
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return ReasonTimeout
+	default:
+		return ReasonError
+	}
+}
+
+// presence of a connection, it counts the bytes of frames
+type presence struct {
+	hooks       []PresenceHook
+	local       string
+	remote      string
+	connectedAt time.Time
+	bytesIn     uint64
+	bytesOut    uint64
+	framesIn    uint64
+	framesOut   uint64
+}
+
+func (p *presence) received(n int) {
+	atomic.AddUint64(&p.bytesIn, uint64(n))
+	atomic.AddUint64(&p.framesIn, 1)
+}
+
+func (p *presence) sent(n int) {
+	atomic.AddUint64(&p.bytesOut, uint64(n))
+	atomic.AddUint64(&p.framesOut, 1)
+}
+
+func (p *presence) notify(t PresenceType, devices []string, err error) {
+	if len(p.hooks) == 0 {
+		return
+	}
+	now := time.Now()
+	ev := PresenceEvent{
+		Type:        t,
+		Conn:        p.local + "-" + p.remote,
+		Local:       p.local,
+		Remote:      p.remote,
+		Devices:     devices,
+		Time:        now,
+		ConnectedAt: p.connectedAt,
+		Duration:    now.Sub(p.connectedAt),
+		BytesIn:     atomic.LoadUint64(&p.bytesIn),
+		BytesOut:    atomic.LoadUint64(&p.bytesOut),
+	}
+	if t == PresenceClosed {
+		ev.Reason = closeReason(err)
+		if err != nil && err != io.EOF {
+			ev.Error = err.Error()
+		}
+	}
+	for _, fn := range p.hooks {
+		fn(ev)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	nts "github.com/micro-community/x-edge/node/transport"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestCloseReason(t *testing.T) {
+	testData := []struct {
+		err    error
+		reason string
+	}{
+		{io.EOF, ReasonEOF},
+		{timeoutError{}, ReasonTimeout},
+		{errShutdown, ReasonShutdown},
+		{errors.New("extract data error"), ReasonError},
+		{fmt.Errorf("%w in tcp transport", nts.ErrExtract), ReasonExtract},
+	}
+	for _, d := range testData {
+		if r := closeReason(d.err); r != d.reason {
+			t.Fatalf("Expected reason %s of %v, got %s", d.reason, d.err, r)
+		}
+	}
+}
+
+func TestPresence(t *testing.T) {
+	events := make(chan PresenceEvent, 8)
+	srv := NewServer(WatchPresence(func(ev PresenceEvent) {
+		events <- ev
+	})).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(expected PresenceType) PresenceEvent {
+		select {
+		case ev := <-events:
+			if ev.Type != expected {
+				t.Fatalf("Expected %s event, got %+v", expected, ev)
+			}
+			return ev
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event", expected)
+		}
+		return PresenceEvent{}
+	}
+
+	sock := newFakeSocket()
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	done := make(chan bool)
+	go func() {
+		srv.ServeConn(sock)
+		close(done)
+	}()
+
+	if ev := next(PresenceAccepted); ev.Remote != "127.0.0.1:9000" || len(ev.Devices) != 0 {
+		t.Fatalf("Unexpected accepted event %+v", ev)
+	}
+	if ev := next(PresenceIdentified); len(ev.Devices) != 1 || ev.Devices[0] != "meter-42" {
+		t.Fatalf("Unexpected identified event %+v", ev)
+	}
+	// the reply of frame
+	reply := <-sock.sent
+
+	// the server is stopped while the device is connected
+	srv.conns.shutdown()
+	close(sock.recv)
+	<-done
+
+	ev := next(PresenceClosed)
+	if ev.Reason != ReasonShutdown || len(ev.Devices) != 1 || ev.Devices[0] != "meter-42" {
+		t.Fatalf("Unexpected closed event %+v", ev)
+	}
+	if ev.BytesIn != uint64(len(testFrame)) || ev.BytesOut != uint64(len(reply.Body)) {
+		t.Fatalf("Expected %d bytes in and %d out, got %+v", len(testFrame), len(reply.Body), ev)
+	}
+	if ev.Duration <= 0 || ev.Duration != ev.Time.Sub(ev.ConnectedAt) {
+		t.Fatalf("Unexpected duration of %+v", ev)
+	}
+}
+
+// extractSocket fails to extract the frames of device
+type extractSocket struct {
+	*fakeSocket
+}
+
+func (s extractSocket) Recv(m *transport.Message) error {
+	return fmt.Errorf("%w in tcp transport", nts.ErrExtract)
+}
+
+func TestPresenceExtractError(t *testing.T) {
+	events := make(chan PresenceEvent, 8)
+	srv := NewServer(WatchPresence(func(ev PresenceEvent) {
+		events <- ev
+	})).(*nodeServer)
+
+	srv.ServeConn(extractSocket{newFakeSocket()})
+	for ev := range events {
+		if ev.Type != PresenceClosed {
+			continue
+		}
+		if ev.Reason != ReasonExtract || len(ev.Error) == 0 {
+			t.Fatalf("Expected closed as %s, got %+v", ReasonExtract, ev)
+		}
+		break
+	}
+}
@@ -3,8 +3,11 @@ package server
 import (
 	"bytes"
 	"context"
+	"io"
 
 	"github.com/micro/go-micro/v2/codec"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	"github.com/micro/go-micro/v2/server"
 	"github.com/micro/go-micro/v2/transport"
 	"github.com/micro/go-micro/v2/util/buf"
 )
@@ -61,6 +64,10 @@ func (r *request) Read() ([]byte, error) {
 		r.first = false
 		return b, nil
 	}
+	// the frames following the one of a unary request are not its own
+	if !r.stream || r.socket == nil {
+		return nil, io.EOF
+	}
 
 	var msg transport.Message
 	err := r.socket.Recv(&msg)
@@ -72,6 +79,25 @@ func (r *request) Read() ([]byte, error) {
 	return msg.Body, nil
 }
 
+//FrameOf returns the frame of a request served by node server, unlike Read
+//it never reads the next frame from the socket
+func FrameOf(req server.Request) []byte {
+	switch v := req.Body().(type) {
+	case *codec.Message:
+		return v.Body
+	case *raw.Frame:
+		return v.Data
+	}
+	if r, ok := req.(*request); ok {
+		// the frame transformed by the codec once it is read
+		if cb, ok := r.codec.(*codecBuffer); ok && cb.req != nil {
+			return cb.req.Body
+		}
+		return r.body
+	}
+	return nil
+}
+
 func (r *request) Stream() bool {
 	return r.stream
 }
@@ -3,12 +3,19 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/micro/go-micro/v2/codec"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	"github.com/micro/go-micro/v2/debug/trace"
+	merrors "github.com/micro/go-micro/v2/errors"
+	log "github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/metadata"
 	"github.com/micro/go-micro/v2/server"
 )
 
@@ -21,11 +28,39 @@ type Routing struct {
 	respLock   sync.Mutex       // protects freeResp
 	freeReq    *routingRequest  //request linklist
 	freeResp   *routingResponse //response linklist
+
+	hdlrWrappers []server.HandlerWrapper
+
+	// routes maps packet types to the endpoint of handler "Service.Method"
+	routes map[string]string
+	// fallback endpoint of the packet types without handler
+	fallback string
+	// packet types which must not be answered
+	oneway map[string]bool
+	// filter evaluates the frames decoded before dispatch
+	filter FrameFilter
+	// authorizer decides the packet types a device may send
+	authorizer Authorizer
+	// limiter throttles the frames of devices over the rate limit
+	limiter Limiter
+	// observer is told the frames served
+	observer Observer
+	// tracer traces the frames served
+	tracer trace.Tracer
+
+	su          sync.RWMutex // protects the subscribers
+	subscribers map[string][]*subscriber
+	subWrappers []server.SubscriberWrapper
+	// topics maps packet types to the local topics their frames are published to
+	topics map[string][]string
+	// codecs decode the payload of subscribers by content type
+	codecs map[string]codec.NewCodec
 }
 
 type routingRequest struct {
-	msg  *codec.Message
-	next *routingRequest // for free list in Server
+	msg   *codec.Message
+	trace *frameTrace
+	next  *routingRequest // for free list in Server
 }
 
 type routingResponse struct {
@@ -83,7 +118,7 @@ func (router *Routing) freeResponse(resp *routingResponse) {
 	router.respLock.Unlock()
 }
 
-func (router *Routing) readRequest(rqst server.Request) (service *service, mtype *methodType, req *routingRequest, argv, replyv reflect.Value, keepReading bool, err error) {
+func (router *Routing) readRequest(ctx context.Context, rqst server.Request) (service *service, mtype *methodType, req *routingRequest, argv, replyv reflect.Value, keepReading bool, err error) {
 
 	codecBuffer := rqst.Codec() //codecBuffer codec
 	req = router.getRequest()
@@ -92,34 +127,33 @@ func (router *Routing) readRequest(rqst server.Request) (service *service, mtype
 	req.msg = msg
 
 	err = codecBuffer.ReadHeader(msg, msg.Type)
+	// the session is closed, there is nothing to decode
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return
+	}
+	req.trace = router.traceRead(ctx, codecBuffer, err)
 	if err != nil {
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-		}
-		err = errors.New("router cannot decode request: " + err.Error())
-
+		router.observe().DecodeError(err)
+		err = merrors.BadRequest("node.router", "router cannot decode request: %v", err)
 		return
 	}
-	serviceName := strings.ToUpper(msg.Target)
-	// Look up the request.
-	router.mu.Lock()
-	service = router.serviceMap[serviceName]
-	router.mu.Unlock()
-
-	if service == nil {
-		err = errors.New(" can't find service: " + serviceName)
+	routed := time.Now()
+	defer func() { req.trace.stage(SpanRoute, routed, err) }()
+	// the frames of a session are rejected until it authenticates
+	if err = authenticate(ctx, msg); err != nil {
 		return
 	}
-	methodName := strings.ToUpper(msg.Method)
-	// find the matched method
-	for methodNameInMap, targetMethod := range service.method {
-		if strings.HasPrefix(methodNameInMap, methodName) {
-			mtype = targetMethod
-			break
-		}
+	// the packet types a device is not allowed to send are rejected
+	if err = router.authorize(ctx, msg); err != nil {
+		return
 	}
-
-	if mtype == nil {
-		err = errors.New("can't find target method " + methodName)
+	// the frames over the rate limit are throttled before dispatch
+	if err = router.limit(ctx, msg); err != nil {
+		return
+	}
+	service, mtype, err = router.dispatch(ctx, msg)
+	if err != nil {
+		return
 	}
 
 	// is it a streaming request? then we don't read the body
@@ -144,7 +178,7 @@ func (router *Routing) readRequest(rqst server.Request) (service *service, mtype
 		argv = argv.Elem()
 	}
 
-	if !mtype.stream {
+	if !mtype.stream && mtype.ReplyType != nil {
 		replyv = reflect.New(mtype.ReplyType.Elem())
 	}
 
@@ -152,27 +186,431 @@ func (router *Routing) readRequest(rqst server.Request) (service *service, mtype
 	return
 }
 
-//ProcessMessage for Routing
-func (router *Routing) ProcessMessage(ctx context.Context, msg server.Message) error {
+// lookup resolves the handler of a frame, a packet type listed in the routing table
+// goes to its endpoint, the others go to the method named exactly as the packet type
+func (router *Routing) lookup(msg *codec.Message) (*service, *methodType, error) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	packetType := msg.Method
+
+	if endpoint, ok := router.routes[packetType]; ok {
+		svc, mtype := router.endpoint(endpoint)
+		if mtype == nil {
+			return nil, nil, merrors.InternalServerError("node.router", "packet type %q is routed to %s which has no handler", packetType, endpoint)
+		}
+		router.resolved(msg, svc, mtype)
+		return svc, mtype, nil
+	}
+
+	svc := router.serviceMap[strings.ToUpper(msg.Target)]
+	if svc != nil {
+		if mtype, ok := svc.method[packetType]; ok {
+			router.resolved(msg, svc, mtype)
+			return svc, mtype, nil
+		}
+	}
+
+	if len(router.fallback) > 0 {
+		fsvc, mtype := router.endpoint(router.fallback)
+		if mtype == nil {
+			return nil, nil, merrors.InternalServerError("node.router", "fallback %s has no handler", router.fallback)
+		}
+		router.resolved(msg, fsvc, mtype)
+		return fsvc, mtype, nil
+	}
+
+	if svc == nil {
+		return nil, nil, merrors.NotFound("node.router", "can't find service %s", msg.Target)
+	}
+	return nil, nil, merrors.NotFound("node.router", "unknown packet type %q", packetType)
+}
+
+var errDropped = errors.New("frame dropped by filter")
+
+// dispatch looks up the handler of a frame after the filter evaluated it
+func (router *Routing) dispatch(ctx context.Context, msg *codec.Message) (*service, *methodType, error) {
+	router.mu.Lock()
+	filter := router.filter
+	router.mu.Unlock()
+	if filter == nil {
+		return router.lookup(msg)
+	}
+
+	v := filter(ctx, msg)
+	if len(v.Annotations) > 0 {
+		if msg.Header == nil {
+			msg.Header = make(map[string]string)
+		}
+		for k, val := range v.Annotations {
+			msg.Header[k] = val
+		}
+	}
+	router.publishTo(ctx, msg, v.Topics)
+	if v.Drop {
+		return nil, nil, errDropped
+	}
+	if len(v.Route) == 0 {
+		return router.lookup(msg)
+	}
+
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	svc, mtype := router.endpoint(v.Route)
+	if mtype == nil {
+		return nil, nil, merrors.InternalServerError("node.router", "frame is routed to %s which has no handler", v.Route)
+	}
+	router.resolved(msg, svc, mtype)
+	return svc, mtype, nil
+}
+
+// endpoint returns the handler of "Service.Method", the caller must hold router.mu
+func (router *Routing) endpoint(endpoint string) (*service, *methodType) {
+	parts := strings.SplitN(endpoint, ".", 2)
+	if len(parts) != 2 {
+		return nil, nil
+	}
+	svc := router.serviceMap[strings.ToUpper(parts[0])]
+	if svc == nil {
+		return nil, nil
+	}
+	return svc, svc.method[parts[1]]
+}
+
+// resolved points the message at the handler it is dispatched to,
+// the packet type is still available in the TYPE header
+func (router *Routing) resolved(msg *codec.Message, svc *service, mtype *methodType) {
+	if msg.Header == nil {
+		msg.Header = make(map[string]string)
+	}
+	if _, ok := msg.Header["TYPE"]; !ok {
+		msg.Header["TYPE"] = msg.Method
+	}
+	msg.Target = svc.name
+	msg.Method = mtype.method.Name
+	msg.Endpoint = svc.name + "." + mtype.method.Name
+}
+
+// isOneWay tells if the frames of packet type must not be answered
+func (router *Routing) isOneWay(packetType string) bool {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.oneway[packetType]
+}
+
+//ProcessMessage delivers a message to the subscribers of its topic, every
+//subscriber is called in isolation and their errors are joined
+func (router *Routing) ProcessMessage(ctx context.Context, msg server.Message) (err error) {
+	router.su.RLock()
+	// get the subscribers by topic
+	subs, ok := router.subscribers[msg.Topic()]
+	subWrappers := router.subWrappers
+	// unlock since we only need to get the subs
+	router.su.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	var errResults []string
+
+	// we may have multiple subscribers for the topic
+	for _, sub := range subs {
+		// we may have multiple handlers per subscriber
+		for i := 0; i < len(sub.handlers); i++ {
+			// get the handler
+			handler := sub.handlers[i]
+
+			var isVal bool
+			var req reflect.Value
+
+			// check whether the handler is a pointer
+			if handler.reqType.Kind() == reflect.Ptr {
+				req = reflect.New(handler.reqType.Elem())
+			} else {
+				req = reflect.New(handler.reqType)
+				isVal = true
+			}
+
+			// read the body into the handler request value
+			if err := decodePayload(msg, req.Interface()); err != nil {
+				errResults = append(errResults, err.Error())
+				continue
+			}
+
+			// if its a value get the element
+			if isVal {
+				req = req.Elem()
+			}
+
+			// create the handler which will honour the SubscriberFunc type
+			fn := func(ctx context.Context, msg server.Message) (err error) {
+				// a broken subscriber must not break the others
+				defer func() {
+					if r := recover(); r != nil {
+						err = fmt.Errorf("panic recovered: %v", r)
+					}
+				}()
+
+				var vals []reflect.Value
+				if sub.typ.Kind() != reflect.Func {
+					vals = append(vals, sub.rcvr)
+				}
+				if handler.ctxType != nil {
+					vals = append(vals, reflect.ValueOf(ctx))
+				}
+
+				// values to pass the handler
+				vals = append(vals, reflect.ValueOf(msg.Payload()))
+
+				// execute the actuall call of the handler
+				returnValues := handler.method.Call(vals)
+				if rerr := returnValues[0].Interface(); rerr != nil {
+					return rerr.(error)
+				}
+				return nil
+			}
+
+			// wrap with subscriber wrappers
+			for i := len(subWrappers); i > 0; i-- {
+				fn = subWrappers[i-1](fn)
+			}
+
+			// create new rpc message
+			rpcMsg := &rpcMessage{
+				topic:       msg.Topic(),
+				contentType: msg.ContentType(),
+				payload:     req.Interface(),
+				header:      msg.Header(),
+				body:        msg.Body(),
+			}
+			if m, ok := msg.(*rpcMessage); ok {
+				rpcMsg.codec = m.codec
+			}
+
+			// execute the message handler
+			if err := fn(ctx, rpcMsg); err != nil {
+				errResults = append(errResults, err.Error())
+			}
+		}
+	}
+
+	// if no errors just return
+	if len(errResults) > 0 {
+		err = merrors.InternalServerError("node.router", "subscriber error: %v", strings.Join(errResults, "\n"))
+	}
+
+	return err
+}
+
+// decodePayload reads the body of message into the request value of subscriber
+func decodePayload(msg server.Message, v interface{}) error {
+	switch p := v.(type) {
+	case *raw.Frame:
+		p.Data = msg.Body()
+		return nil
+	case *codec.Message:
+		p.Header = msg.Header()
+		p.Body = msg.Body()
+		return nil
+	}
+
+	m, ok := msg.(*rpcMessage)
+	if !ok || m.codec == nil {
+		return merrors.InternalServerError("node.router", "no codec to decode message of topic %s", msg.Topic())
+	}
+
+	cc := msg.Codec()
+	// read the header. mostly a noop
+	if err := cc.ReadHeader(&codec.Message{}, codec.Event); err != nil {
+		return err
+	}
+	return cc.ReadBody(v)
+}
+
+// publish hands a frame over to the subscribers of the topics of its packet type,
+// they run apart from the handler so their errors never reach the device
+func (router *Routing) publish(ctx context.Context, msg *codec.Message) bool {
+	if msg == nil || msg.Header == nil {
+		return false
+	}
+
+	packetType, ok := msg.Header["TYPE"]
+	if !ok {
+		packetType = msg.Method
+	}
+
+	router.su.RLock()
+	topics := router.topics[packetType]
+	router.su.RUnlock()
+
+	return router.publishTo(ctx, msg, topics)
+}
+
+// publishTo hands a frame over to the subscribers of local topics
+func (router *Routing) publishTo(ctx context.Context, msg *codec.Message, topics []string) bool {
+	if len(topics) == 0 {
+		return false
+	}
+
+	router.su.RLock()
+	cf := router.codecs[msg.Header["Codec"]]
+	router.su.RUnlock()
+
+	hdr := make(map[string]string)
+	for k, v := range msg.Header {
+		hdr[k] = v
+	}
+	ctx = metadata.MergeContext(ctx, hdr, true)
+
+	for _, topic := range topics {
+		m := &rpcMessage{
+			topic:       topic,
+			contentType: hdr["Codec"],
+			header:      hdr,
+			body:        msg.Body,
+			codec:       cf,
+		}
+		go func() {
+			if err := router.ProcessMessage(ctx, m); err != nil {
+				log.Errorf("frame of %s published to %s: %v", hdr["Remote"], m.topic, err)
+			}
+		}()
+	}
+
+	return true
+}
+
+//Subscribe registers a subscriber of local topic
+func (router *Routing) Subscribe(s server.Subscriber) error {
+	sub, ok := s.(*subscriber)
+	if !ok {
+		return errors.New("invalid subscriber: expected *subscriber")
+	}
+	if len(sub.handlers) == 0 {
+		return errors.New("invalid subscriber: no handler functions")
+	}
+
+	if err := validateSubscriber(sub); err != nil {
+		return err
+	}
+
+	router.su.Lock()
+	defer router.su.Unlock()
+
+	if router.subscribers == nil {
+		router.subscribers = make(map[string][]*subscriber)
+	}
+	router.subscribers[sub.topic] = append(router.subscribers[sub.topic], sub)
 	return nil
 }
 
 //ServeRequest Serve requesting from controller
 func (router *Routing) ServeRequest(ctx context.Context, rqst server.Request, rsp server.Response) error {
 	sending := new(sync.Mutex)
-	service, mtype, req, argv, replyv, keepReading, err := router.readRequest(rqst)
-	//Here will receiving all request messages.
-	if err != nil {
-		if !keepReading {
-			return err
+	service, mtype, req, argv, replyv, _, err := router.readRequest(ctx, rqst)
+	defer router.freeRequest(req)
+	defer func() {
+		req.trace.finish(req.msg, err)
+		recordFrame(ctx, rqst.Codec(), req.msg, err)
+	}()
+
+	// the frame dropped by filter or consumed by handshake is neither handled nor answered
+	if err == errDropped || err == errHandshake {
+		return nil
+	}
+
+	// a frame of session not authenticated or not authorized is only answered by the error
+	if isDenied(err) {
+		if werr := router.sendError(sending, req, err, rsp.Codec()); werr != nil {
+			log.Infof("unable to write error response: %v", werr)
 		}
-		// send a response if we actually managed to read a header.
-		if req != nil {
-			router.freeRequest(req)
+		closeRejected(ctx)
+		return err
+	}
+
+	// a frame over the rate limit is answered by the busy frame only
+	if isBusy(err) {
+		if werr := router.sendError(sending, req, err, rsp.Codec()); werr != nil {
+			log.Infof("unable to write error response: %v", werr)
+			router.observe().ReplyError(methodOf(service, mtype), werr)
 		}
 		return err
 	}
-	return service.call(ctx, router, sending, mtype, req, argv, replyv, rsp.Codec())
+
+	// a decoded frame tells the device of connection
+	if req.msg != nil && req.msg.Header != nil {
+		bindDevice(ctx, req.msg.Header)
+	}
+
+	// hand the frame over to the subscribers of its topics,
+	// a frame only published has no handler to answer it
+	if router.publish(req.trace.context(ctx), req.msg) && isNotFound(err) {
+		err = nil
+		return nil
+	}
+	if isNotFound(err) && req.msg != nil {
+		router.observe().RoutingMiss(req.msg.Method)
+	}
+
+	//Here will receiving all request messages.
+	if err == nil {
+		err = service.call(authenticated(ctx), router, sending, mtype, req, rqst, argv, replyv, rsp.Codec())
+	}
+	// the session is closed, no one is there to answer
+	if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return err
+	}
+	if werr := router.sendError(sending, req, err, rsp.Codec()); werr != nil {
+		log.Infof("unable to write error response: %v", werr)
+		router.observe().ReplyError(methodOf(service, mtype), werr)
+	}
+	return err
+}
+
+// wrappers of handlers, the options may change them at any time
+func (router *Routing) wrappers() []server.HandlerWrapper {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.hdlrWrappers
+}
+
+// observe returns the observer of frames, a no-op one if none is set
+func (router *Routing) observe() Observer {
+	router.mu.Lock()
+	o := router.observer
+	router.mu.Unlock()
+	return observerOf(o)
+}
+
+func isNotFound(err error) bool {
+	merr, ok := err.(*merrors.Error)
+	return ok && merr.Code == 404
+}
+
+// sendError answers the error of a request, the codec encodes it into an
+// error frame of the device protocol or suppresses it
+func (router *Routing) sendError(sending sync.Locker, req *routingRequest, err error, cc codec.Writer) error {
+	msg := &codec.Message{
+		Type:   codec.Error,
+		Error:  err.Error(),
+		Header: make(map[string]string),
+	}
+	if req.msg != nil {
+		msg.Id = req.msg.Id
+		msg.Target = req.msg.Target
+		msg.Method = req.msg.Method
+		msg.Endpoint = req.msg.Endpoint
+		for k, v := range req.msg.Header {
+			msg.Header[k] = v
+		}
+	}
+
+	started := time.Now()
+	sending.Lock()
+	werr := cc.Write(msg, nil)
+	sending.Unlock()
+	req.trace.stage(SpanReply, started, werr)
+	return werr
 }
 
 func (router *Routing) sendResponse(sending sync.Locker, req *routingRequest, reply interface{}, cc codec.Writer, last bool) error {
@@ -182,9 +620,11 @@ func (router *Routing) sendResponse(sending sync.Locker, req *routingRequest, re
 	resp.msg = msg
 
 	resp.msg.Id = req.msg.Id
+	started := time.Now()
 	sending.Lock()
 	err := cc.Write(resp.msg, reply)
 	sending.Unlock()
+	req.trace.stage(SpanReply, started, err)
 	router.freeResponse(resp)
 	return err
 }
@@ -215,9 +655,13 @@ func (router *Routing) Handle(h server.Handler) error {
 	s.method = make(map[string]*methodType)
 
 	// Install the methods
+	md := h.Options().Metadata
 	for m := 0; m < s.typ.NumMethod(); m++ {
 		method := s.typ.Method(m)
 		if mt := prepareMethod(method); mt != nil {
+			if md[method.Name]["oneway"] == "true" && !mt.stream {
+				mt.oneway = true
+			}
 			s.method[method.Name] = mt
 		}
 	}
@@ -2,12 +2,13 @@ package server
 
 import (
 	"context"
-	"errors"
 	"reflect"
 	"sync"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/micro-community/x-edge/node/stream"
 	"github.com/micro/go-micro/v2/codec"
 	log "github.com/micro/go-micro/v2/logger"
 	"github.com/micro/go-micro/v2/server"
@@ -27,6 +28,8 @@ type methodType struct {
 	ReplyType   reflect.Type
 	ContextType reflect.Type
 	stream      bool
+	// a one-way method never answers the device
+	oneway bool
 }
 
 type service struct {
@@ -43,9 +46,7 @@ func (m *methodType) prepareContext(ctx context.Context) reflect.Value {
 	return reflect.Zero(m.ContextType)
 }
 
-func (s *service) call(ctx context.Context, router *Routing, sending *sync.Mutex, mtype *methodType, req *routingRequest, argv, replyv reflect.Value, cc codec.Writer) error {
-	defer router.freeRequest(req)
-
+func (s *service) call(ctx context.Context, router *Routing, sending *sync.Mutex, mtype *methodType, req *routingRequest, live server.Request, argv, replyv reflect.Value, cc codec.Writer) error {
 	function := mtype.method.Func
 	var returnValues []reflect.Value
 
@@ -54,7 +55,14 @@ func (s *service) call(ctx context.Context, router *Routing, sending *sync.Mutex
 		contentType: req.msg.Header["Content-Type"],
 		method:      req.msg.Method,
 		endpoint:    req.msg.Endpoint,
+		header:      req.msg.Header,
 		body:        req.msg.Body,
+		// the wrappers may read the frame and the codec of the live request
+		first: true,
+	}
+	if lr, ok := live.(*request); ok {
+		r.codec, r.socket = lr.codec, lr.socket
+		r.body = FrameOf(lr)
 	}
 
 	// only set if not nil
@@ -64,7 +72,12 @@ func (s *service) call(ctx context.Context, router *Routing, sending *sync.Mutex
 
 	if !mtype.stream {
 		fn := func(ctx context.Context, req server.Request, rsp interface{}) error {
-			returnValues = function.Call([]reflect.Value{s.rcvr, mtype.prepareContext(ctx), reflect.ValueOf(argv.Interface()), reflect.ValueOf(rsp)})
+			in := []reflect.Value{s.rcvr, mtype.prepareContext(ctx), reflect.ValueOf(argv.Interface())}
+			// a one-way method takes no reply
+			if mtype.ReplyType != nil {
+				in = append(in, reflect.ValueOf(rsp))
+			}
+			returnValues = function.Call(in)
 
 			// The return value for the method is an error.
 			if err := returnValues[0].Interface(); err != nil {
@@ -74,21 +87,74 @@ func (s *service) call(ctx context.Context, router *Routing, sending *sync.Mutex
 			return nil
 		}
 
-		// // wrap the handler
-		// for i := len(router.hdlrWrappers); i > 0; i-- {
-		// 	fn = router.hdlrWrappers[i-1](fn)
-		// }
+		// wrap the handler, the first wrapper is the outermost one
+		wrappers := router.wrappers()
+		for i := len(wrappers); i > 0; i-- {
+			fn = wrappers[i-1](fn)
+		}
+
+		var reply interface{}
+		if replyv.IsValid() {
+			reply = replyv.Interface()
+		}
 
 		// execute handler
-		if err := fn(ctx, r, replyv.Interface()); err != nil {
+		obs, method := router.observe(), methodOf(s, mtype)
+		obs.HandlerStart(method)
+		hctx, span := req.trace.start(ctx, method)
+		start := time.Now()
+		err := fn(hctx, r, reply)
+		obs.HandlerEnd(method, time.Since(start), err)
+		req.trace.end(span, err)
+		if err != nil {
 			return err
 		}
 
+		// one-way frames must not be answered
+		if mtype.oneway || router.isOneWay(req.msg.Header["TYPE"]) {
+			return nil
+		}
+
 		// send response
-		return router.sendResponse(sending, req, replyv.Interface(), cc, true)
+		if err := router.sendResponse(sending, req, reply, cc, true); err != nil {
+			obs.ReplyError(method, err)
+			return err
+		}
+		return nil
+	}
+
+	// the stream is bound to the device socket, so the frames following
+	// the first one on the same connection are delivered by stream.Recv
+	rawStream := stream.NewServerStrem(ctx, r, cc.(codec.Codec), req.msg.Id)
+
+	// Invoke the method, providing the stream as argument.
+	fn := func(ctx context.Context, req server.Request, stream interface{}) error {
+		returnValues = function.Call([]reflect.Value{s.rcvr, mtype.prepareContext(ctx), reflect.ValueOf(stream)})
+		if err := returnValues[0].Interface(); err != nil {
+			// the function returned an error, we use that
+			return err.(error)
+		}
+		return nil
+	}
+
+	// wrap the handler, the first wrapper is the outermost one
+	wrappers := router.wrappers()
+	for i := len(wrappers); i > 0; i-- {
+		fn = wrappers[i-1](fn)
 	}
 
-	return errors.New("Streaming Unsupported Now")
+	// device stream request
+	r.stream = true
+
+	// execute handler
+	obs, method := router.observe(), methodOf(s, mtype)
+	obs.HandlerStart(method)
+	hctx, span := req.trace.start(ctx, method)
+	start := time.Now()
+	err := fn(hctx, r, rawStream)
+	obs.HandlerEnd(method, time.Since(start), err)
+	req.trace.end(span, err)
+	return err
 }
 
 // Is this an exported - upper case - name?
@@ -122,10 +188,11 @@ func prepareMethod(method reflect.Method) *methodType {
 
 	switch mtype.NumIn() {
 	case 3:
-		// assuming streaming
 		argType = mtype.In(2)
 		contextType = mtype.In(1)
-		stream = true
+		// assuming streaming if it takes an interface like server.Stream,
+		// otherwise it is an one-way method without reply
+		stream = argType.Kind() == reflect.Interface
 	case 4:
 		// method that takes a context
 		argType = mtype.In(2)
@@ -144,13 +211,13 @@ func prepareMethod(method reflect.Method) *methodType {
 			return nil
 		}
 
-		if replyType.Kind() != reflect.Ptr {
+		if replyType != nil && replyType.Kind() != reflect.Ptr {
 			log.Info("method", mname, "reply type not a pointer:", replyType)
 			return nil
 		}
 
 		// Reply type must be exported.
-		if !isExportedOrBuiltinType(replyType) {
+		if replyType != nil && !isExportedOrBuiltinType(replyType) {
 			log.Info("method", mname, "reply type not exported:", replyType)
 			return nil
 		}
@@ -166,5 +233,5 @@ func prepareMethod(method reflect.Method) *methodType {
 		log.Info("method", mname, "returns", returnType.String(), "not error")
 		return nil
 	}
-	return &methodType{method: method, ArgType: argType, ReplyType: replyType, ContextType: contextType, stream: stream}
+	return &methodType{method: method, ArgType: argType, ReplyType: replyType, ContextType: contextType, stream: stream, oneway: !stream && replyType == nil}
 }
@@ -0,0 +1,557 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	"github.com/micro-community/x-edge/node/server/mock"
+	"github.com/micro/go-micro/v2/codec"
+	merrors "github.com/micro/go-micro/v2/errors"
+	"github.com/micro/go-micro/v2/server"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+var testFrame = `<?xml version="1.0" encoding="gb2312"?>
+<PROTOCOL>
+<VER>1.0</VER>
+<NAME>meter-42</NAME>
+<GENDER>MALE</GENDER>
+<TYPE>Event</TYPE>
+</PROTOCOL>`
+
+// ProtocolServer is named after the target the xml codec resolves frames to
+type ProtocolServer struct {
+	calls int
+	acks  int
+}
+
+func (p *ProtocolServer) Event(ctx context.Context, req *codec.Message, rsp *codec.Message) error {
+	p.calls++
+	rsp.Body = []byte("<ACK/>")
+	return nil
+}
+
+func (p *ProtocolServer) EventAck(ctx context.Context, req *codec.Message, rsp *codec.Message) error {
+	p.acks++
+	return nil
+}
+
+// fakeSocket is a transport socket fed by a channel of frames
+type fakeSocket struct {
+	recv chan *transport.Message
+	sent chan *transport.Message
+}
+
+func newFakeSocket() *fakeSocket {
+	return &fakeSocket{
+		recv: make(chan *transport.Message, 8),
+		sent: make(chan *transport.Message, 8),
+	}
+}
+
+func (f *fakeSocket) Recv(m *transport.Message) error {
+	msg, ok := <-f.recv
+	if !ok {
+		return io.EOF
+	}
+	*m = *msg
+	return nil
+}
+
+func (f *fakeSocket) Send(m *transport.Message) error {
+	f.sent <- m
+	return nil
+}
+
+func (f *fakeSocket) Close() error   { return nil }
+func (f *fakeSocket) Local() string  { return "127.0.0.1:8000" }
+func (f *fakeSocket) Remote() string { return "127.0.0.1:9000" }
+
+// newTestRequest loads a frame into a pseudo socket the way ServeConn does
+func newTestRequest(frame string) (*request, *response, *pseudoSocket) {
+	psock := newPseudoSocket("test", "127.0.0.1:8000", "127.0.0.1:9000")
+	psock.Accept(&transport.Message{
+		Header: map[string]string{
+			"Local":  psock.Local(),
+			"Remote": psock.Remote(),
+			"Codec":  xmlc.DefaultContentType,
+		},
+		Body: []byte(frame),
+	})
+
+	cdc := newBuffCodec(psock, xmlc.NewCodec, xmlc.EncodeError)
+	rqst := &request{contentType: xmlc.DefaultContentType, codec: cdc, socket: psock}
+	resp := &response{header: make(map[string]string), socket: psock, codec: cdc}
+	return rqst, resp, psock
+}
+
+func newTestRouter(t *testing.T, hdlr interface{}, wrappers ...server.HandlerWrapper) *Routing {
+	router := DefaultRouter()
+	router.hdlrWrappers = wrappers
+	if err := router.Handle(router.NewHandler(hdlr)); err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func TestHandlerWrapperOrder(t *testing.T) {
+	var mtx sync.Mutex
+	var trace []string
+
+	record := func(name string) server.HandlerWrapper {
+		return func(fn server.HandlerFunc) server.HandlerFunc {
+			return func(ctx context.Context, req server.Request, rsp interface{}) error {
+				mtx.Lock()
+				trace = append(trace, name+"-before")
+				mtx.Unlock()
+				err := fn(ctx, req, rsp)
+				mtx.Lock()
+				trace = append(trace, name+"-after")
+				mtx.Unlock()
+				return err
+			}
+		}
+	}
+
+	hdlr := new(ProtocolServer)
+	router := newTestRouter(t, hdlr, record("a"), record("b"))
+	rqst, resp, psock := newTestRequest(testFrame)
+
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+
+	expected := []string{"a-before", "b-before", "b-after", "a-after"}
+	if !reflect.DeepEqual(trace, expected) {
+		t.Fatalf("Expected wrapper order %v, got %v", expected, trace)
+	}
+	if hdlr.calls != 1 {
+		t.Fatalf("Expected handler to be called once, got %d", hdlr.calls)
+	}
+
+	var m transport.Message
+	if err := psock.Process(&m); err != nil {
+		t.Fatalf("Unexpected process err: %v", err)
+	}
+	if string(m.Body) != "<ACK/>" {
+		t.Fatalf("Expected reply <ACK/>, got %s", m.Body)
+	}
+}
+
+func TestHandlerWrapperShortCircuit(t *testing.T) {
+	errDenied := errors.New("denied")
+
+	var remote, name string
+	var body interface{}
+
+	deny := func(fn server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			remote = req.Header()["Remote"]
+			name = req.Header()["NAME"]
+			body = req.Body()
+			return errDenied
+		}
+	}
+
+	hdlr := new(ProtocolServer)
+	router := newTestRouter(t, hdlr, deny)
+	rqst, resp, psock := newTestRequest(testFrame)
+
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != errDenied {
+		t.Fatalf("Expected %v, got %v", errDenied, err)
+	}
+	if hdlr.calls != 0 {
+		t.Fatalf("Expected handler not to be called, got %d calls", hdlr.calls)
+	}
+	if remote != "127.0.0.1:9000" {
+		t.Fatalf("Expected remote 127.0.0.1:9000, got %q", remote)
+	}
+	if name != "meter-42" {
+		t.Fatalf("Expected NAME header meter-42, got %q", name)
+	}
+	if msg, ok := body.(*codec.Message); !ok || len(msg.Body) == 0 {
+		t.Fatalf("Expected decoded request body, got %#v", body)
+	}
+
+	// the device gets an error frame instead of the reply of handler
+	var m transport.Message
+	if err := psock.Process(&m); err != nil {
+		t.Fatalf("Unexpected process err: %v", err)
+	}
+	if !strings.Contains(string(m.Body), "<DETAIL>denied</DETAIL>") {
+		t.Fatalf("Expected an error frame, got %s", m.Body)
+	}
+}
+
+func TestHandlerWrapperReadsRequest(t *testing.T) {
+	var frame []byte
+	var readErr, nextErr error
+	var cdc codec.Reader
+
+	read := func(fn server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			cdc = req.Codec()
+			frame, readErr = req.Read()
+			_, nextErr = req.Read()
+			return fn(ctx, req, rsp)
+		}
+	}
+
+	hdlr := new(ProtocolServer)
+	router := newTestRouter(t, hdlr, read)
+	rqst, resp, psock := newTestRequest(testFrame)
+
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	if cdc == nil || cdc != rqst.Codec() {
+		t.Fatalf("Expected codec of the live request, got %v", cdc)
+	}
+	if readErr != nil || string(frame) != testFrame {
+		t.Fatalf("Expected frame read, got %s %v", frame, readErr)
+	}
+	// a unary request never reads the next frame of socket
+	if nextErr != io.EOF {
+		t.Fatalf("Expected %v reading on, got %v", io.EOF, nextErr)
+	}
+	if hdlr.calls != 1 {
+		t.Fatalf("Expected handler to be called once, got %d", hdlr.calls)
+	}
+	var m transport.Message
+	if err := psock.Process(&m); err != nil || string(m.Body) != "<ACK/>" {
+		t.Fatalf("Expected reply <ACK/>, got %s %v", m.Body, err)
+	}
+}
+
+func TestServerHonorsWrapHandler(t *testing.T) {
+	called := make(chan string, 1)
+
+	wrapper := func(fn server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			called <- req.Header()["Remote"]
+			return fn(ctx, req, rsp)
+		}
+	}
+
+	srv := NewServer()
+	srv.Init(server.WrapHandler(wrapper))
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	go srv.(*nodeServer).ServeConn(sock)
+
+	select {
+	case remote := <-called:
+		if remote != sock.Remote() {
+			t.Fatalf("Expected remote %s, got %s", sock.Remote(), remote)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wrapper was not called")
+	}
+
+	select {
+	case m := <-sock.sent:
+		if string(m.Body) != "<ACK/>" {
+			t.Fatalf("Expected reply <ACK/>, got %s", m.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reply was not sent")
+	}
+	close(sock.recv)
+}
+
+func typeFrame(packetType string) string {
+	return fmt.Sprintf("<PROTOCOL><NAME>meter-42</NAME><TYPE>%s</TYPE></PROTOCOL>", packetType)
+}
+
+func TestRoutingExactMatch(t *testing.T) {
+	hdlr := new(ProtocolServer)
+	router := newTestRouter(t, hdlr)
+
+	for i := 0; i < 20; i++ {
+		rqst, resp, _ := newTestRequest(typeFrame("EventAck"))
+		if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+			t.Fatalf("Unexpected serve err: %v", err)
+		}
+	}
+	if hdlr.calls != 0 || hdlr.acks != 20 {
+		t.Fatalf("Expected 20 calls of EventAck only, got Event %d EventAck %d", hdlr.calls, hdlr.acks)
+	}
+
+	// a different case is not the same method
+	rqst, resp, _ := newTestRequest(typeFrame("EVENT"))
+	err := router.ServeRequest(context.Background(), rqst, resp)
+	if merr := merrors.Parse(err.Error()); merr.Code != 404 {
+		t.Fatalf("Expected not found error, got %v", err)
+	}
+}
+
+func TestRoutingTable(t *testing.T) {
+	hdlr := new(ProtocolServer)
+	router := newTestRouter(t, hdlr)
+	router.routes = map[string]string{
+		"1":        "ProtocolServer.Event",
+		"2":        "ProtocolServer.Missing",
+		"EventAck": "ProtocolServer.Event",
+	}
+
+	var header map[string]string
+	router.hdlrWrappers = []server.HandlerWrapper{func(fn server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			header = req.Header()
+			return fn(ctx, req, rsp)
+		}
+	}}
+
+	rqst, resp, _ := newTestRequest(typeFrame("1"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	if hdlr.calls != 1 {
+		t.Fatalf("Expected packet type 1 to be routed to Event, got %d calls", hdlr.calls)
+	}
+	if header["TYPE"] != "1" {
+		t.Fatalf("Expected TYPE header 1, got %q", header["TYPE"])
+	}
+
+	// the table takes precedence over method names
+	rqst, resp, _ = newTestRequest(typeFrame("EventAck"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	if hdlr.calls != 2 || hdlr.acks != 0 {
+		t.Fatalf("Expected EventAck to be routed to Event, got Event %d EventAck %d", hdlr.calls, hdlr.acks)
+	}
+
+	rqst, resp, _ = newTestRequest(typeFrame("2"))
+	err := router.ServeRequest(context.Background(), rqst, resp)
+	if merr := merrors.Parse(err.Error()); merr.Code != 500 {
+		t.Fatalf("Expected internal error of a route without handler, got %v", err)
+	}
+}
+
+func TestRoutingFallback(t *testing.T) {
+	hdlr := new(ProtocolServer)
+	router := newTestRouter(t, hdlr)
+
+	rqst, resp, _ := newTestRequest(typeFrame("99"))
+	err := router.ServeRequest(context.Background(), rqst, resp)
+	if merr := merrors.Parse(err.Error()); merr.Code != 404 {
+		t.Fatalf("Expected not found error, got %v", err)
+	}
+
+	router.fallback = "ProtocolServer.EventAck"
+	rqst, resp, _ = newTestRequest(typeFrame("99"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	if hdlr.acks != 1 {
+		t.Fatalf("Expected packet type 99 to fall back to EventAck, got %d calls", hdlr.acks)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	sock.recv <- &transport.Message{Body: []byte(typeFrame("9"))}
+	go srv.(*nodeServer).ServeConn(sock)
+	defer close(sock.recv)
+
+	select {
+	case m := <-sock.sent:
+		pkg := xmlc.ErrorPackage{}
+		if err := (xmlc.Marshaler{}).Unmarshal(m.Body, &pkg); err != nil {
+			t.Fatal(err)
+		}
+		if pkg.Type != "9" || pkg.Err.Code != 404 {
+			t.Fatalf("Expected not found error frame of packet type 9, got %s", m.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error frame was not sent")
+	}
+}
+
+func TestErrorResponseSuppressed(t *testing.T) {
+	var encoded error
+	encoder := func(hdr map[string]string, err error) ([]byte, error) {
+		encoded = err
+		return nil, nil
+	}
+
+	srv := NewServer(ErrorResponse(xmlc.DefaultContentType, encoder))
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	sock.recv <- &transport.Message{Body: []byte(typeFrame("9"))}
+	sock.recv <- &transport.Message{Body: []byte(typeFrame("Event"))}
+	go srv.(*nodeServer).ServeConn(sock)
+	defer close(sock.recv)
+
+	// the only frame is the reply of Event
+	select {
+	case m := <-sock.sent:
+		if string(m.Body) != "<ACK/>" {
+			t.Fatalf("Expected reply <ACK/>, got %s", m.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reply was not sent")
+	}
+	if merr, ok := encoded.(*merrors.Error); !ok || merr.Code != 404 {
+		t.Fatalf("Expected encoder to get the not found error, got %v", encoded)
+	}
+}
+
+type telemetry struct {
+	samples int
+}
+
+func (t *telemetry) Sample(ctx context.Context, req *codec.Message) error {
+	t.samples++
+	return nil
+}
+
+func (t *telemetry) Report(ctx context.Context, req *codec.Message, rsp *codec.Message) error {
+	rsp.Body = []byte("<ACK/>")
+	return nil
+}
+
+func (t *telemetry) Fail(ctx context.Context, req *codec.Message) error {
+	return errors.New("broken sample")
+}
+
+// expectNoReply closes the socket and checks nothing was sent to device
+func expectNoReply(t *testing.T, psock *pseudoSocket) {
+	psock.Close()
+	var m transport.Message
+	if err := psock.Process(&m); err != io.EOF {
+		t.Fatalf("Expected no reply to be written, got %s", m.Body)
+	}
+}
+
+func TestOneWayHandlers(t *testing.T) {
+	hdlr := new(telemetry)
+	router := DefaultRouter()
+	h := &mock.MockHandler{Id: "ProtocolServer", Hdlr: hdlr}
+	OneWay("Report")(&h.Opts)
+	if err := router.Handle(h); err != nil {
+		t.Fatal(err)
+	}
+
+	// a method without reply
+	rqst, resp, psock := newTestRequest(typeFrame("Sample"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	if hdlr.samples != 1 {
+		t.Fatalf("Expected Sample to be called once, got %d", hdlr.samples)
+	}
+	expectNoReply(t, psock)
+
+	// a method marked by handler option
+	rqst, resp, psock = newTestRequest(typeFrame("Report"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	expectNoReply(t, psock)
+
+	// errors still go through the error encoder
+	rqst, resp, psock = newTestRequest(typeFrame("Fail"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err == nil {
+		t.Fatal("Expected error of Fail")
+	}
+	var m transport.Message
+	if err := psock.Process(&m); err != nil || !strings.Contains(string(m.Body), "broken sample") {
+		t.Fatalf("Expected an error frame, got %s", m.Body)
+	}
+}
+
+func TestOneWayTypes(t *testing.T) {
+	hdlr := new(ProtocolServer)
+	router := newTestRouter(t, hdlr)
+	router.routes = map[string]string{"7": "ProtocolServer.Event"}
+	router.oneway = map[string]bool{"7": true}
+
+	rqst, resp, psock := newTestRequest(typeFrame("7"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	if hdlr.calls != 1 {
+		t.Fatalf("Expected Event to be called once, got %d", hdlr.calls)
+	}
+	expectNoReply(t, psock)
+
+	// the other packet types of the same handler are answered
+	rqst, resp, psock = newTestRequest(typeFrame("Event"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	var m transport.Message
+	if err := psock.Process(&m); err != nil || string(m.Body) != "<ACK/>" {
+		t.Fatalf("Expected reply <ACK/>, got %s", m.Body)
+	}
+}
+
+func TestFrameFilter(t *testing.T) {
+	hdlr := new(ProtocolServer)
+	router := newTestRouter(t, hdlr)
+	router.filter = func(ctx context.Context, msg *codec.Message) Verdict {
+		switch msg.Header["TYPE"] {
+		case "Stale":
+			return Verdict{Drop: true}
+		case "Alarm":
+			return Verdict{Route: "ProtocolServer.EventAck", Annotations: map[string]string{"Priority": "high"}}
+		}
+		return Verdict{}
+	}
+
+	var header map[string]string
+	router.hdlrWrappers = []server.HandlerWrapper{func(fn server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			header = req.Header()
+			return fn(ctx, req, rsp)
+		}
+	}}
+
+	// a dropped frame is neither handled nor answered
+	rqst, resp, psock := newTestRequest(typeFrame("Stale"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	expectNoReply(t, psock)
+
+	rqst, resp, _ = newTestRequest(typeFrame("Alarm"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	if hdlr.acks != 1 {
+		t.Fatalf("Expected Alarm to be routed to EventAck, got %d calls", hdlr.acks)
+	}
+	if header["Priority"] != "high" {
+		t.Fatalf("Expected annotation Priority high, got %v", header)
+	}
+
+	rqst, resp, _ = newTestRequest(typeFrame("Event"))
+	if err := router.ServeRequest(context.Background(), rqst, resp); err != nil {
+		t.Fatalf("Unexpected serve err: %v", err)
+	}
+	if hdlr.calls != 1 {
+		t.Fatalf("Expected Event to be dispatched as usual, got %d calls", hdlr.calls)
+	}
+}
@@ -2,23 +2,33 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	xmlc "github.com/micro-community/x-edge/node/codec"
+	nts "github.com/micro-community/x-edge/node/transport"
 
 	"github.com/micro/go-micro/v2/codec"
 	log "github.com/micro/go-micro/v2/logger"
 	"github.com/micro/go-micro/v2/metadata"
 	"github.com/micro/go-micro/v2/server"
 	"github.com/micro/go-micro/v2/transport"
-	"github.com/micro/go-micro/v2/util/socket"
 )
 
 type nodeServer struct {
 	router   *Routing
 	opts     server.Options
 	handlers map[string]server.Handler
+	// connections of devices by device id
+	conns *deviceConns
+	// listener and exit of accepting loop once started
+	listener transport.Listener
+	accept   chan bool
+	// error of accepting loop, nil while it accepts
+	acceptErr error
 
 	exit chan chan error
 	sync.RWMutex
@@ -33,41 +43,111 @@ func NewServer(opts ...server.Option) server.Server {
 	options := newOption(opts...)
 	router := DefaultRouter()
 
-	return &nodeServer{
+	s := &nodeServer{
 		opts:     options,
 		router:   router,
 		handlers: make(map[string]server.Handler),
+		conns:    newDeviceConns(),
 		exit:     make(chan chan error),
 		wg:       wait(options.Context),
 	}
+	s.configureRouter()
+
+	return s
+}
+
+// configureRouter keeps the router up to date with options
+func (s *nodeServer) configureRouter() {
+	s.router.mu.Lock()
+	defer s.router.mu.Unlock()
+
+	s.router.hdlrWrappers = s.opts.HdlrWrappers
+	s.router.routes = routesFromContext(s.opts.Context)
+	s.router.fallback = fallbackFromContext(s.opts.Context)
+	s.router.oneway = oneWayFromContext(s.opts.Context)
+	s.router.filter = frameFilterFromContext(s.opts.Context)
+	s.router.authorizer = authorizerFromContext(s.opts.Context)
+	s.router.limiter = limiterFromContext(s.opts.Context)
+	s.router.observer = observerFromContext(s.opts.Context)
+	s.router.tracer = s.opts.Tracer
+
+	s.router.su.Lock()
+	s.router.subWrappers = s.opts.SubWrappers
+	s.router.topics = topicsFromContext(s.opts.Context)
+	s.router.codecs = make(map[string]codec.NewCodec)
+	for k, v := range xmlc.DefaultCodecs {
+		s.router.codecs[k] = v
+	}
+	for k, v := range s.opts.Codecs {
+		s.router.codecs[k] = v
+	}
+	s.router.su.Unlock()
+
+	s.conns.Lock()
+	s.conns.hooks = deviceHooksFromContext(s.opts.Context)
+	s.conns.presenceHooks = presenceHooksFromContext(s.opts.Context)
+	s.conns.authenticator, s.conns.authFrames = authenticatorFromContext(s.opts.Context)
+	s.conns.observer = observerFromContext(s.opts.Context)
+	s.conns.frameHooks = frameHooksFromContext(s.opts.Context)
+	s.conns.Unlock()
 }
 
 // ServeConn serves a single connection
 func (s *nodeServer) ServeConn(sock transport.Socket) {
+	// multiplex the streams on a single socket by Micro-Stream
+	var mtx sync.RWMutex
+	sockets := make(map[string]*pseudoSocket)
+
+	// the devices are bound to the connection by their frames
+	conn := s.conns.newConn(sock, xmlc.DefaultContentType)
+	// the error the connection is closed by
+	var closeErr error
+	// identity of the client certificate verified
+	peer := new(peerHeader)
+	obs := s.router.observe()
+
 	defer func() {
+		if r := recover(); r != nil {
+			log.Info("panic recovered: ", r)
+			closeErr = fmt.Errorf("panic: %v", r)
+		}
+
 		// close socket
 		sock.Close()
+		conn.release(closeErr)
 
-		if r := recover(); r != nil {
-			log.Info("panic recovered: ", r)
+		// release the handlers and streams still bound to the connection
+		mtx.Lock()
+		for id, psock := range sockets {
+			psock.Close()
+			delete(sockets, id)
 		}
+		mtx.Unlock()
 	}()
 
-	// multiplex the streams on a single socket by Micro-Stream
-	var mtx sync.RWMutex
-	sockets := make(map[string]*socket.Socket)
-
 	for {
 		var msg transport.Message
 		if err := sock.Recv(&msg); err != nil {
+			if errors.Is(err, nts.ErrExtract) {
+				obs.ExtractError(err)
+				s.router.traceError(context.Background(), SpanExtract, sock.Remote(), err)
+			}
+			closeErr = err
 			return
 		}
+		conn.presence.received(len(msg.Body))
+		obs.FrameIn(len(msg.Body))
 		//as a key to  represent a session.
 		id := sock.Local() + "-" + sock.Remote()
 
-		// add to wait group if "wait" is opt-in
-		if s.wg != nil {
-			s.wg.Add(1)
+		// set local/remote/codec for protocol before the message
+		// is handed over, so handlers and wrappers can see them
+		msg.Header = map[string]string{}
+		msg.Header["Local"] = sock.Local()
+		msg.Header["Remote"] = sock.Remote()
+		msg.Header["Codec"] = xmlc.DefaultContentType
+		for k, v := range peer.header(sock) {
+			msg.Header[k] = v
 		}
 
 		// check we have an existing socket
@@ -75,29 +155,26 @@ func (s *nodeServer) ServeConn(sock transport.Socket) {
 		psock, ok := sockets[id]
 		mtx.RUnlock()
 
-		// got the socket
+		// got the socket, the session serves the message in turn
 		if ok {
-			// accept the message
-			if err := psock.Accept(&msg); err != nil {
-				// delete the socket
-				mtx.Lock()
-				delete(sockets, id)
-				mtx.Unlock()
+			err := psock.Accept(&msg)
+			if err == nil {
+				continue
 			}
-
-			// done(1)
-			if s.wg != nil {
-				s.wg.Done()
+			if err == errSessionBusy {
+				log.Errorf("frame from %s dropped: %v", sock.Remote(), err)
+				continue
 			}
+			// the session just became idle, start a new one
+		}
 
-			// continue to the next message
-			continue
+		// add to wait group if "wait" is opt-in
+		if s.wg != nil {
+			s.wg.Add(1)
 		}
 
 		// no socket was found
-		psock = socket.New(id)
-		psock.SetLocal(sock.Local())
-		psock.SetRemote(sock.Remote())
+		psock = newPseudoSocket(id, sock.Local(), sock.Remote())
 
 		// load the socket
 		psock.Accept(&msg)
@@ -107,34 +184,34 @@ func (s *nodeServer) ServeConn(sock transport.Socket) {
 		sockets[id] = psock
 		mtx.Unlock()
 
+		// remove the socket of session unless a new one replaced it
+		release := func(id string, psock *pseudoSocket) {
+			mtx.Lock()
+			if sockets[id] == psock {
+				delete(sockets, id)
+			}
+			mtx.Unlock()
+		}
+
 		// process the outbound messages from the socket
-		go func(id string, psock *socket.Socket) {
+		go func(id string, psock *pseudoSocket) {
 			defer psock.Close()
 
 			for {
 				// get the message from our internal handler/stream
 				m := new(transport.Message)
 				if err := psock.Process(m); err != nil {
-					// delete the socket
-					mtx.Lock()
-					delete(sockets, id)
-					mtx.Unlock()
+					release(id, psock)
 					return
 				}
 
 				// send the message back over the socket
-				if err := sock.Send(m); err != nil {
+				if err := conn.Send(m); err != nil {
 					return
 				}
 			}
 		}(id, psock)
 
-		msg.Header = map[string]string{}
-		// set local/remote/codec for protocol
-		msg.Header["Local"] = sock.Local()
-		msg.Header["Remote"] = sock.Remote()
-		msg.Header["Codec"] = xmlc.DefaultContentType
-
 		msgCodec := s.newCodec(xmlc.DefaultContentType, psock)
 		hdr := make(map[string]string)
 		for k, v := range msg.Header {
@@ -143,6 +220,7 @@ func (s *nodeServer) ServeConn(sock transport.Socket) {
 
 		// create new context with the metadata
 		ctx := metadata.NewContext(context.Background(), hdr)
+		ctx = context.WithValue(ctx, connKey{}, conn)
 
 		// internal request
 		rqst := &request{
@@ -160,16 +238,24 @@ func (s *nodeServer) ServeConn(sock transport.Socket) {
 			codec:  msgCodec,
 		}
 
-		// serve the request in a go routine as this may be a stream
-		go func(id string, psock *socket.Socket) {
+		// serve the requests in a go routine as this may be a stream
+		go func(id string, psock *pseudoSocket) {
 			defer psock.Close()
-			// serve the actual request using the request router
-			if err := s.router.ServeRequest(ctx, rqst, resp); err != nil {
-				log.Infof("unable to write error response: %v", err)
+
+			for {
+				// serve the actual request using the request router,
+				// the error frame has been answered by the router
+				err := s.router.ServeRequest(ctx, rqst, resp)
+				if err != nil {
+					log.Infof("unable to serve request from %s: %v", psock.Remote(), err)
+				}
+				// serve the frames accepted meanwhile until the session is idle
+				if err == io.EOF || psock.CloseIfIdle() {
+					break
+				}
 			}
-			mtx.Lock()
-			delete(sockets, id)
-			mtx.Unlock()
+
+			release(id, psock)
 			// signal we're done
 			if s.wg != nil {
 				s.wg.Done()
@@ -180,13 +266,24 @@ func (s *nodeServer) ServeConn(sock transport.Socket) {
 }
 
 //newCodec return codec for message.
-func (s *nodeServer) newCodec(contentType string, socket *socket.Socket) codec.Codec {
+func (s *nodeServer) newCodec(contentType string, socket transport.Socket) codec.Codec {
+	errEnc := s.errorEncoder(contentType)
 	if cf, ok := s.opts.Codecs[contentType]; ok {
-		return newBuffCodec(socket, cf)
+		return newBuffCodec(socket, cf, errEnc)
 	}
 	log.Errorf("Unsupported Content-Type: %s", contentType)
 	//Default for xml
-	return newBuffCodec(socket, xmlc.DefaultCodecs[contentType])
+	return newBuffCodec(socket, xmlc.DefaultCodecs[contentType], errEnc)
+}
+
+//errorEncoder return the encoder of error frames for a content type
+func (s *nodeServer) errorEncoder(contentType string) ErrorEncoder {
+	s.RLock()
+	defer s.RUnlock()
+	if enc, ok := errorEncodersFromContext(s.opts.Context)[contentType]; ok {
+		return enc
+	}
+	return DefaultErrorEncoders[contentType]
 }
 
 func (s *nodeServer) Options() server.Options {
@@ -198,6 +295,7 @@ func (s *nodeServer) Init(opts ...server.Option) error {
 	for _, opt := range opts {
 		opt(&s.opts)
 	}
+	s.configureRouter()
 	s.Unlock()
 	return nil
 }
@@ -219,12 +317,14 @@ func (s *nodeServer) Handle(h server.Handler) error {
 	return nil
 }
 
+//NewSubscriber return a subscriber of local topic, which the frames of packet types are published to
 func (s *nodeServer) NewSubscriber(topic string, sb interface{}, opts ...server.SubscriberOption) server.Subscriber {
-	return nil
+	return newSubscriber(topic, sb, opts...)
 }
 
+//Subscribe a local topic
 func (s *nodeServer) Subscribe(sb server.Subscriber) error {
-	return nil
+	return s.router.Subscribe(sb)
 }
 
 //Register useless here
@@ -263,6 +363,15 @@ func (s *nodeServer) Start() error {
 
 	exit := make(chan bool)
 
+	// mark the server as started before accepting, so the errors of
+	// accepting loop are not reset after
+	s.Lock()
+	s.started = true
+	s.listener = ts
+	s.accept = exit
+	s.acceptErr = nil
+	s.Unlock()
+
 	go func() {
 		for {
 			// listen for connections
@@ -277,41 +386,42 @@ func (s *nodeServer) Start() error {
 			default:
 				if err != nil {
 					log.Infof("Accept error: %v", err)
+					s.acceptFailed(err)
 					time.Sleep(time.Second)
+					s.acceptFailed(nil)
 					continue
 				}
 			}
 
 			// no error just exit
+			s.acceptFailed(errNotAccepting)
 			return
 		}
 	}()
 
-	// mark the server as started
-	s.Lock()
-	s.started = true
-	s.Unlock()
-
 	return nil
 }
 
 func (s *nodeServer) Stop() error {
-	s.RLock()
+	s.Lock()
 	if !s.started {
-		s.RUnlock()
+		s.Unlock()
 		return nil
 	}
-	s.RUnlock()
+	ts, exit := s.listener, s.accept
+	s.started = false
+	s.listener = nil
+	s.accept = nil
+	s.Unlock()
 
-	ch := make(chan error)
-	//	s.exit <- ch
+	// stop accepting, then close the connections of devices
+	close(exit)
+	err := ts.Close()
+	s.conns.shutdown()
 
-	var err error
-	select {
-	case err = <-ch:
-		s.Lock()
-		s.started = false
-		s.Unlock()
+	// wait for the requests in flight if "wait" is opt-in
+	if s.wg != nil {
+		s.wg.Wait()
 	}
 
 	return err
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/micro/go-micro/v2/transport"
+)
+
+// pseudoSocket multiplexes a session over a device socket like the
+// go-micro util/socket, but it drains frames already accepted before
+// reporting io.EOF, so a frame right before disconnecting is not lost
+type pseudoSocket struct {
+	// guards accepting against closing
+	sync.Mutex
+	id     string
+	closed chan bool
+	local  string
+	remote string
+	send   chan *transport.Message
+	recv   chan acceptedMessage
+	// accepted is the time the message last received was accepted
+	accepted time.Time
+}
+
+// acceptedMessage is a message accepted at the time its frame was extracted
+type acceptedMessage struct {
+	msg *transport.Message
+	at  time.Time
+}
+
+func newPseudoSocket(id, local, remote string) *pseudoSocket {
+	return &pseudoSocket{
+		id:     id,
+		closed: make(chan bool),
+		local:  local,
+		remote: remote,
+		send:   make(chan *transport.Message, 128),
+		recv:   make(chan acceptedMessage, 128),
+	}
+}
+
+var errSessionBusy = errors.New("too many frames pending in session")
+
+// Accept passes a message to the socket which will be processed by the call to Recv
+func (s *pseudoSocket) Accept(m *transport.Message) error {
+	s.Lock()
+	defer s.Unlock()
+
+	select {
+	case <-s.closed:
+		return io.EOF
+	default:
+	}
+
+	select {
+	case s.recv <- acceptedMessage{msg: m, at: time.Now()}:
+		return nil
+	default:
+		return errSessionBusy
+	}
+}
+
+// CloseIfIdle closes the socket unless a message is waiting for Recv
+func (s *pseudoSocket) CloseIfIdle() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.recv) > 0 {
+		return false
+	}
+	s.close()
+	return true
+}
+
+// Process takes the next message off the send queue created by a call to Send
+func (s *pseudoSocket) Process(m *transport.Message) error {
+	select {
+	case msg := <-s.send:
+		*m = *msg
+	case <-s.closed:
+		// see if we need to drain
+		select {
+		case msg := <-s.send:
+			*m = *msg
+			return nil
+		default:
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+func (s *pseudoSocket) Remote() string {
+	return s.remote
+}
+
+func (s *pseudoSocket) Local() string {
+	return s.local
+}
+
+func (s *pseudoSocket) Send(m *transport.Message) error {
+	select {
+	case s.send <- m:
+	case <-s.closed:
+		return io.EOF
+	}
+	return nil
+}
+
+func (s *pseudoSocket) Recv(m *transport.Message) error {
+	select {
+	case am := <-s.recv:
+		*m = *am.msg
+		s.accepted = am.at
+	case <-s.closed:
+		// see if we need to drain
+		select {
+		case am := <-s.recv:
+			*m = *am.msg
+			s.accepted = am.at
+			return nil
+		default:
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+// acceptedAt returns the time the message last received was accepted,
+// only the session receiving the messages may call it
+func (s *pseudoSocket) acceptedAt() time.Time {
+	return s.accepted
+}
+
+// Close closes the socket
+func (s *pseudoSocket) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.close()
+	return nil
+}
+
+func (s *pseudoSocket) close() {
+	select {
+	case <-s.closed:
+		// no op
+	default:
+		close(s.closed)
+	}
+}
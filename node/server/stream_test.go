@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/micro-community/x-edge/node/server/mock"
+	"github.com/micro/go-micro/v2/server"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+type filePart struct {
+	Seq  int    `xml:"SEQ"`
+	Data string `xml:"DATA"`
+	Last bool   `xml:"LAST"`
+}
+
+type fileServer struct {
+	done chan error
+}
+
+func (f *fileServer) Upload(ctx context.Context, stream server.Stream) error {
+	var data string
+	for {
+		part := new(filePart)
+		if err := stream.Recv(part); err != nil {
+			f.done <- err
+			return err
+		}
+		data += part.Data
+		if part.Last {
+			break
+		}
+	}
+	f.done <- nil
+	return stream.Send(&filePart{Seq: -1, Data: data})
+}
+
+func filePartFrame(seq int, data string, last bool) *transport.Message {
+	return &transport.Message{Body: []byte(fmt.Sprintf(
+		"<PROTOCOL><NAME>meter-42</NAME><TYPE>Upload</TYPE><SEQ>%d</SEQ><DATA>%s</DATA><LAST>%v</LAST></PROTOCOL>",
+		seq, data, last))}
+}
+
+func newStreamServer(t *testing.T, hdlr *fileServer) *nodeServer {
+	srv := NewServer().(*nodeServer)
+	// the xml codec targets ProtocolServer
+	if err := srv.Handle(&mock.MockHandler{Id: "ProtocolServer", Hdlr: hdlr}); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestStreamMultiFrame(t *testing.T) {
+	hdlr := &fileServer{done: make(chan error, 1)}
+	srv := newStreamServer(t, hdlr)
+
+	sock := newFakeSocket()
+	sock.recv <- filePartFrame(0, "ab", false)
+	sock.recv <- filePartFrame(1, "cd", false)
+	sock.recv <- filePartFrame(2, "ef", true)
+	go srv.ServeConn(sock)
+	defer close(sock.recv)
+
+	select {
+	case err := <-hdlr.done:
+		if err != nil {
+			t.Fatalf("Unexpected stream err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream was not completed")
+	}
+
+	select {
+	case m := <-sock.sent:
+		expected := "<filePart><SEQ>-1</SEQ><DATA>abcdef</DATA><LAST>false</LAST></filePart>"
+		if string(m.Body) != expected {
+			t.Fatalf("Expected reply %s, got %s", expected, m.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reply was not sent")
+	}
+}
+
+func TestStreamConnectionClosed(t *testing.T) {
+	hdlr := &fileServer{done: make(chan error, 1)}
+	srv := newStreamServer(t, hdlr)
+
+	sock := newFakeSocket()
+	sock.recv <- filePartFrame(0, "ab", false)
+	go srv.ServeConn(sock)
+	close(sock.recv)
+
+	select {
+	case err := <-hdlr.done:
+		if err != io.EOF {
+			t.Fatalf("Expected %v, got %v", io.EOF, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream was not released on close")
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2/codec"
+	"github.com/micro/go-micro/v2/metadata"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+type Alarm struct {
+	Name  string `xml:"NAME"`
+	Level int    `xml:"LEVEL"`
+}
+
+type alarmWatcher struct {
+	alarms chan *Alarm
+}
+
+func (a *alarmWatcher) Handle(ctx context.Context, alarm *Alarm) error {
+	a.alarms <- alarm
+	return nil
+}
+
+func TestSubscribersOfPacketType(t *testing.T) {
+	srv := NewServer(Publish("Alarm", "alarms"), Publish("Event", "events"))
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := &alarmWatcher{alarms: make(chan *Alarm, 1)}
+	frames := make(chan *codec.Message, 2)
+	remotes := make(chan string, 1)
+
+	subs := []interface{}{
+		watcher,
+		func(ctx context.Context, msg *codec.Message) error {
+			remote, _ := metadata.Get(ctx, "Remote")
+			remotes <- remote
+			frames <- msg
+			return nil
+		},
+		// broken subscribers never affect the others or the device
+		func(ctx context.Context, msg *codec.Message) error {
+			return errors.New("broken subscriber")
+		},
+		func(ctx context.Context, msg *codec.Message) error {
+			panic("panicking subscriber")
+		},
+	}
+	for _, sub := range subs {
+		if err := srv.Subscribe(srv.NewSubscriber("alarms", sub)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := make(chan *codec.Message, 1)
+	if err := srv.Subscribe(srv.NewSubscriber("events", func(ctx context.Context, msg *codec.Message) error {
+		events <- msg
+		return nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	sock.recv <- &transport.Message{Body: []byte("<PROTOCOL><NAME>meter-42</NAME><TYPE>Alarm</TYPE><LEVEL>3</LEVEL></PROTOCOL>")}
+	sock.recv <- &transport.Message{Body: []byte(typeFrame("Event"))}
+	go srv.(*nodeServer).ServeConn(sock)
+	defer close(sock.recv)
+
+	select {
+	case alarm := <-watcher.alarms:
+		if alarm.Name != "meter-42" || alarm.Level != 3 {
+			t.Fatalf("Unexpected decoded alarm %+v", alarm)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("alarm was not delivered to subscriber struct")
+	}
+
+	select {
+	case msg := <-frames:
+		if msg.Header["TYPE"] != "Alarm" || len(msg.Body) == 0 {
+			t.Fatalf("Unexpected frame %+v", msg)
+		}
+		if remote := <-remotes; remote != sock.Remote() {
+			t.Fatalf("Expected remote %s in context, got %s", sock.Remote(), remote)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("alarm was not delivered to subscriber func")
+	}
+
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("event was not delivered to subscriber")
+	}
+
+	// the alarm has no handler, so the only frame is the reply of Event
+	select {
+	case m := <-sock.sent:
+		if string(m.Body) != "<ACK/>" {
+			t.Fatalf("Expected reply <ACK/>, got %s", m.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reply was not sent")
+	}
+	select {
+	case m := <-sock.sent:
+		t.Fatalf("Unexpected frame %s", m.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscriberValidation(t *testing.T) {
+	srv := NewServer()
+	if err := srv.Subscribe(srv.NewSubscriber("alarms", func(msg *codec.Message) {})); err == nil {
+		t.Fatal("Expected invalid subscriber to be rejected")
+	}
+}
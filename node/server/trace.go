@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/micro/go-micro/v2/codec"
+	"github.com/micro/go-micro/v2/debug/trace"
+	"github.com/micro/go-micro/v2/metadata"
+)
+
+//The spans of the stages a frame goes through, children of the frame span
+const (
+	SpanFrame   = "frame"
+	SpanExtract = "extract"
+	SpanDecode  = "decode"
+	SpanRoute   = "route"
+	SpanReply   = "reply"
+)
+
+// frameTrace traces a frame from the time it is extracted until it is answered,
+// a nil one traces nothing
+type frameTrace struct {
+	tracer trace.Tracer
+	// ctx carries the span of frame by the metadata of go-micro,
+	// so the calls and publications upstream continue its trace
+	ctx  context.Context
+	span *trace.Span
+	// bytes of the frame
+	bytes int
+}
+
+// tracing returns the tracer of frames, nil if none is set
+func (router *Routing) tracing() trace.Tracer {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	return router.tracer
+}
+
+// traceFrame starts the span of a frame extracted at a time,
+// the frame continues the trace the session context may carry
+func (router *Routing) traceFrame(ctx context.Context, extracted time.Time) *frameTrace {
+	t := router.tracing()
+	if t == nil {
+		return nil
+	}
+	fctx, span := t.Start(ctx, SpanFrame)
+	if span == nil {
+		return nil
+	}
+	span.Type = trace.SpanTypeRequestInbound
+	span.Started = extracted
+	if c, _ := ctx.Value(connKey{}).(*deviceConn); c != nil {
+		span.Metadata["remote"] = c.Remote()
+	}
+	return &frameTrace{tracer: t, ctx: fctx, span: span}
+}
+
+// traceRead starts the span of a frame read by the codec of node server
+// and traces its decoding by the error of decoder
+func (router *Routing) traceRead(ctx context.Context, c codec.Reader, err error) *frameTrace {
+	cb, ok := c.(*codecBuffer)
+	if !ok {
+		return nil
+	}
+	f := router.traceFrame(ctx, cb.accepted)
+	if f != nil && cb.req != nil {
+		f.bytes = len(cb.req.Body)
+	}
+	f.stage(SpanDecode, cb.received, err)
+	return f
+}
+
+// traceError traces an error of a stage without frame, such as extract
+func (router *Routing) traceError(ctx context.Context, name, remote string, err error) {
+	t := router.tracing()
+	if t == nil {
+		return
+	}
+	_, span := t.Start(ctx, name)
+	if span == nil {
+		return
+	}
+	span.Type = trace.SpanTypeRequestInbound
+	span.Metadata["remote"] = remote
+	span.Metadata["error"] = err.Error()
+	t.Finish(span)
+}
+
+// context returns the context of a handler carrying the span of frame
+func (f *frameTrace) context(ctx context.Context) context.Context {
+	if f == nil {
+		return ctx
+	}
+	md, _ := metadata.FromContext(f.ctx)
+	return metadata.MergeContext(ctx, md, true)
+}
+
+// start a child span of the frame
+func (f *frameTrace) start(ctx context.Context, name string) (context.Context, *trace.Span) {
+	if f == nil {
+		return ctx, nil
+	}
+	sctx, span := f.tracer.Start(f.context(ctx), name)
+	return sctx, span
+}
+
+// end a child span of the frame by the error of its stage
+func (f *frameTrace) end(span *trace.Span, err error) {
+	if f == nil || span == nil {
+		return
+	}
+	if err != nil {
+		span.Metadata["error"] = err.Error()
+	}
+	f.tracer.Finish(span)
+}
+
+// stage traces a stage of the frame started at a time
+func (f *frameTrace) stage(name string, started time.Time, err error) {
+	if f == nil {
+		return
+	}
+	_, span := f.start(f.ctx, name)
+	if span == nil {
+		return
+	}
+	span.Started = started
+	f.end(span, err)
+}
+
+// finish the span of frame by what the frame is and how it is served
+func (f *frameTrace) finish(msg *codec.Message, err error) {
+	if f == nil {
+		return
+	}
+	if msg != nil && msg.Header != nil {
+		if device, ok := msg.Header["NAME"]; ok {
+			f.span.Metadata["device"] = device
+		}
+	}
+	if packetType := packetTypeOf(msg); len(packetType) > 0 {
+		f.span.Metadata["type"] = packetType
+	}
+	f.span.Metadata["bytes"] = strconv.Itoa(f.bytes)
+	switch {
+	case err == errDropped:
+		f.span.Metadata["dropped"] = "true"
+	case err != nil:
+		f.span.Metadata["error"] = err.Error()
+	}
+	f.tracer.Finish(f.span)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ntrace "github.com/micro-community/x-edge/node/trace"
+	mtrace "github.com/micro/go-micro/v2/debug/trace"
+	"github.com/micro/go-micro/v2/metadata"
+	"github.com/micro/go-micro/v2/server"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+func TestTraceFrame(t *testing.T) {
+	mem := ntrace.NewMemory(0)
+	mds := make(chan metadata.Metadata, 1)
+	capture := func(h server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			md, _ := metadata.FromContext(ctx)
+			mds <- md
+			return h(ctx, req, rsp)
+		}
+	}
+	srv := NewServer(server.Tracer(ntrace.NewTracer(mem)), server.WrapHandler(capture)).(*nodeServer)
+	if err := srv.Handle(srv.NewHandler(new(ProtocolServer))); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := newFakeSocket()
+	go srv.ServeConn(sock)
+	defer close(sock.recv)
+
+	sock.recv <- &transport.Message{Body: []byte(testFrame)}
+	sent(t, sock)
+
+	// the frame span is finished once the frame is answered
+	var frame *mtrace.Span
+	spans := make(map[string]*mtrace.Span)
+	for deadline := time.Now().Add(time.Second); frame == nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("frame not traced")
+		}
+		all, _ := mem.Read()
+		for _, s := range all {
+			spans[s.Name] = s
+		}
+		frame = spans[SpanFrame]
+	}
+
+	if frame.Metadata["device"] != "meter-42" || frame.Metadata["type"] != "Event" || len(frame.Metadata["error"]) > 0 {
+		t.Fatalf("Unexpected frame span %+v", frame)
+	}
+	for _, name := range []string{SpanDecode, SpanRoute, "PROTOCOLSERVER.Event", SpanReply} {
+		s, ok := spans[name]
+		if !ok {
+			t.Fatalf("Expected span %s, got %v", name, spans)
+		}
+		if s.Trace != frame.Trace || s.Parent != frame.Id {
+			t.Fatalf("Expected %s child of frame %s, got %+v", name, frame.Id, s)
+		}
+	}
+
+	// the handler calls upstream by the metadata of its span
+	md := <-mds
+	handler := spans["PROTOCOLSERVER.Event"]
+	if md["Micro-Trace-Id"] != frame.Trace || md["Micro-Span-Id"] != handler.Id {
+		t.Fatalf("Expected trace %s and span %s in metadata, got %v", frame.Trace, handler.Id, md)
+	}
+}
@@ -18,19 +18,28 @@ var (
 // Implements the Streamer interface
 type streamServer struct {
 	sync.RWMutex
-	id       string
-	closed   bool
-	err      error
-	request  server.Request
-	response server.Response
-	codec    codec.Codec
-	context  context.Context
+	id      string
+	closed  bool
+	err     error
+	request server.Request
+	codec   codec.Codec
+	context context.Context
+	// the header of first frame has been read off the codec
+	// by the router, so its body is the first one to receive
+	first bool
 }
 
-//NewServerStrem return a server stream object
-func NewServerStrem(ctx context.Context, seq uint) server.Stream {
+//NewServerStrem return a server stream bound to the codec of a device socket,
+//the header of the first frame must have been read already
+func NewServerStrem(ctx context.Context, req server.Request, cc codec.Codec, id string) server.Stream {
 
-	s := &streamServer{}
+	s := &streamServer{
+		id:      id,
+		request: req,
+		codec:   cc,
+		context: ctx,
+		first:   true,
+	}
 
 	return s
 }
@@ -47,6 +56,10 @@ func (r *streamServer) Send(msg interface{}) error {
 	r.Lock()
 	defer r.Unlock()
 
+	if r.closed {
+		return errShutdown
+	}
+
 	resp := codec.Message{
 		Target:   r.request.Service(),
 		Method:   r.request.Method(),
@@ -57,6 +70,7 @@ func (r *streamServer) Send(msg interface{}) error {
 
 	if err := r.codec.Write(&resp, msg); err != nil {
 		r.err = err
+		return err
 	}
 
 	return nil
@@ -64,34 +78,53 @@ func (r *streamServer) Send(msg interface{}) error {
 
 func (r *streamServer) Recv(msg interface{}) error {
 	r.Lock()
-	defer r.Unlock()
+	if r.closed {
+		r.Unlock()
+		return errShutdown
+	}
+	first := r.first
+	r.first = false
+	r.Unlock()
 
-	req := new(codec.Message)
-	req.Type = codec.Request
+	if !first {
+		req := new(codec.Message)
+		req.Type = codec.Request
 
-	if err := r.codec.ReadHeader(req, req.Type); err != nil {
-		// discard body
-		r.codec.ReadBody(nil)
-		r.err = err
-		return err
-	}
+		// block on the next frame of device without holding the lock,
+		// so a handler is able to send while waiting for frames
+		err := r.codec.ReadHeader(req, req.Type)
 
-	// check the error
-	if len(req.Error) > 0 {
-		// Check the client closed the stream
-		switch req.Error {
-		case errorLastStreamResponse.Error():
+		r.Lock()
+		defer r.Unlock()
+
+		if err != nil {
 			// discard body
 			r.codec.ReadBody(nil)
-			r.err = io.EOF
-			return io.EOF
-		default:
-			return errors.New(req.Error)
+			r.err = err
+			return err
 		}
+
+		// check the error
+		if len(req.Error) > 0 {
+			// Check the client closed the stream
+			switch req.Error {
+			case errorLastStreamResponse.Error():
+				// discard body
+				r.codec.ReadBody(nil)
+				r.err = io.EOF
+				return io.EOF
+			default:
+				return errors.New(req.Error)
+			}
+		}
+
+		// we need to stay up to date with sequence numbers
+		r.id = req.Id
+	} else {
+		r.Lock()
+		defer r.Unlock()
 	}
 
-	// we need to stay up to date with sequence numbers
-	r.id = req.Id
 	if err := r.codec.ReadBody(msg); err != nil {
 		r.err = err
 		return err
@@ -109,6 +142,9 @@ func (r *streamServer) Error() error {
 func (r *streamServer) Close() error {
 	r.Lock()
 	defer r.Unlock()
+	if r.closed {
+		return nil
+	}
 	r.closed = true
 	return r.codec.Close()
 }
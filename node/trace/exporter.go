@@ -0,0 +1,91 @@
+package trace
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	mtrace "github.com/micro/go-micro/v2/debug/trace"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// DefaultSize of the spans Memory keeps
+var DefaultSize = 1024
+
+// Memory keeps the last spans exported, such as for tests
+type Memory struct {
+	sync.Mutex
+	size  int
+	spans []*mtrace.Span
+}
+
+// NewMemory returns the exporter keeping the last size spans, DefaultSize if not positive
+func NewMemory(size int) *Memory {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Memory{size: size}
+}
+
+// Export keeps a span, the oldest one is dropped once it is full
+func (m *Memory) Export(s *mtrace.Span) error {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.spans) >= m.size {
+		m.spans = append(m.spans[:0], m.spans[1:]...)
+	}
+	m.spans = append(m.spans, s)
+	return nil
+}
+
+// Read the spans kept, of a trace by mtrace.ReadTrace
+func (m *Memory) Read(opts ...mtrace.ReadOption) ([]*mtrace.Span, error) {
+	var options mtrace.ReadOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	spans := make([]*mtrace.Span, 0, len(m.spans))
+	for _, s := range m.spans {
+		if len(options.Trace) > 0 && s.Trace != options.Trace {
+			continue
+		}
+		spans = append(spans, s)
+	}
+	return spans, nil
+}
+
+// Reset drops the spans kept
+func (m *Memory) Reset() {
+	m.Lock()
+	defer m.Unlock()
+	m.spans = nil
+}
+
+// logExporter logs the spans
+type logExporter struct{}
+
+// Log returns the exporter logging the spans at debug level
+func Log() Exporter {
+	return logExporter{}
+}
+
+func (logExporter) Export(s *mtrace.Span) error {
+	if !log.V(log.DebugLevel, log.DefaultLogger) {
+		return nil
+	}
+	keys := make([]string, 0, len(s.Metadata))
+	for k := range s.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	md := make([]string, 0, len(keys))
+	for _, k := range keys {
+		md = append(md, k+"="+s.Metadata[k])
+	}
+	log.Debugf("span %s trace=%s id=%s parent=%s took %v %s",
+		s.Name, s.Trace, s.Id, s.Parent, s.Duration, strings.Join(md, " "))
+	return nil
+}
@@ -0,0 +1,83 @@
+// Package trace traces the frames of devices by the spans of go-micro,
+// the spans finished are handed over to pluggable exporters
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	mtrace "github.com/micro/go-micro/v2/debug/trace"
+	log "github.com/micro/go-micro/v2/logger"
+)
+
+// Exporter exports the spans finished, it must not block
+type Exporter interface {
+	Export(s *mtrace.Span) error
+}
+
+// Reader reads the spans an exporter kept, such as Memory
+type Reader interface {
+	Read(opts ...mtrace.ReadOption) ([]*mtrace.Span, error)
+}
+
+// Tracer is a mtrace.Tracer exporting the spans finished by its exporters,
+// a span continues the trace of the metadata of its context
+type Tracer struct {
+	exporters []Exporter
+}
+
+// NewTracer returns the tracer of exporters
+func NewTracer(exporters ...Exporter) *Tracer {
+	return &Tracer{exporters: exporters}
+}
+
+// Start a span, the child of the span of context if any
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *mtrace.Span) {
+	span := &mtrace.Span{
+		Name:     name,
+		Trace:    newID(16),
+		Id:       newID(8),
+		Started:  time.Now(),
+		Metadata: make(map[string]string),
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if traceID, parentID, ok := mtrace.FromContext(ctx); ok {
+		span.Trace = traceID
+		span.Parent = parentID
+	}
+	return mtrace.ToContext(ctx, span.Trace, span.Id), span
+}
+
+// Finish a span and export it
+func (t *Tracer) Finish(s *mtrace.Span) error {
+	s.Duration = time.Since(s.Started)
+	var err error
+	for _, e := range t.exporters {
+		if eerr := e.Export(s); eerr != nil {
+			log.Debugf("unable to export span %s of trace %s: %v", s.Name, s.Trace, eerr)
+			err = eerr
+		}
+	}
+	return err
+}
+
+// Read the spans of the first exporter keeping them
+func (t *Tracer) Read(opts ...mtrace.ReadOption) ([]*mtrace.Span, error) {
+	for _, e := range t.exporters {
+		if r, ok := e.(Reader); ok {
+			return r.Read(opts...)
+		}
+	}
+	return nil, nil
+}
+
+// newID returns a random id of n bytes in hex
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
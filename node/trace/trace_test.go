@@ -0,0 +1,42 @@
+package trace
+
+import (
+	"context"
+	"testing"
+
+	mtrace "github.com/micro/go-micro/v2/debug/trace"
+)
+
+func TestTracer(t *testing.T) {
+	mem := NewMemory(2)
+	tr := NewTracer(mem, Log())
+
+	ctx, parent := tr.Start(context.TODO(), "frame")
+	_, child := tr.Start(ctx, "decode")
+	if child.Trace != parent.Trace || child.Parent != parent.Id {
+		t.Fatalf("Expected child of %+v, got %+v", parent, child)
+	}
+	tr.Finish(child)
+	tr.Finish(parent)
+
+	_, other := tr.Start(nil, "frame")
+	if other.Trace == parent.Trace || len(other.Parent) > 0 {
+		t.Fatalf("Expected trace of its own, got %+v", other)
+	}
+
+	spans, _ := tr.Read(mtrace.ReadTrace(parent.Trace))
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans of trace, got %v", spans)
+	}
+
+	// the oldest span is dropped once memory is full
+	tr.Finish(other)
+	spans, _ = mem.Read()
+	if len(spans) != 2 || spans[0] != parent || spans[1] != other {
+		t.Fatalf("Expected the last 2 spans, got %v", spans)
+	}
+	mem.Reset()
+	if spans, _ = mem.Read(); len(spans) != 0 {
+		t.Fatalf("Expected no span after reset, got %v", spans)
+	}
+}
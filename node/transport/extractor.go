@@ -2,6 +2,7 @@ package transport
 
 import (
 	"bufio"
+	"errors"
 )
 
 //DataExtractor for package pasering
@@ -10,6 +11,9 @@ type DataExtractor = bufio.SplitFunc
 //DataExtractorFuncKey for DataExtractor
 type DataExtractorFuncKey struct{}
 
+//ErrExtract is wrapped by the errors of a transport unable to extract the frames of a connection
+var ErrExtract = errors.New("extract data error")
+
 var minDataPackageLength = 50
 
 //DefaultdataExtractor will returns all data
@@ -57,7 +57,7 @@ func (t *tcpTransportClient) Recv(m *transport.Message) error {
 		return nil
 	}
 
-	return errorTransportDataExtract
+	return scanError(scanner.Err())
 
 }
 
@@ -3,13 +3,13 @@ package tcp
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"net"
 	"time"
 
 	nts "github.com/micro-community/x-edge/node/transport"
 	"github.com/micro/go-micro/v2/transport"
-	"github.com/micro/go-micro/v2/util/log"
 )
 
 type tcpSocket struct {
@@ -45,11 +45,9 @@ func (t *tcpSocket) Recv(m *transport.Message) error {
 	if scanner.Scan() {
 		m.Body = scanner.Bytes()
 		return nil
-	} else {
-		log.Errorf("Scan fail ", scanner.Err().Error())
 	}
 
-	return errorTransportDataExtract
+	return scanError(scanner.Err())
 }
 
 func (t *tcpSocket) Send(m *transport.Message) error {
@@ -66,6 +64,14 @@ func (t *tcpSocket) Send(m *transport.Message) error {
 	//return err
 }
 
+// ConnectionState of a TLS connection, ok is false for a plain one
+func (t *tcpSocket) ConnectionState() (tls.ConnectionState, bool) {
+	if c, ok := t.conn.(*tls.Conn); ok {
+		return c.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
 func (t *tcpSocket) Close() error {
 	return t.conn.Close()
 }
@@ -2,16 +2,32 @@
 package tcp
 
 import (
-	"errors"
+	"fmt"
+	"io"
+	"net"
 
+	nts "github.com/micro-community/x-edge/node/transport"
 	"github.com/micro/go-micro/v2/config/cmd"
 	"github.com/micro/go-micro/v2/transport"
 )
 
 var (
-	errorTransportDataExtract = errors.New("extract data error in tcp transport")
+	errorTransportDataExtract = fmt.Errorf("%w in tcp transport", nts.ErrExtract)
 )
 
+// scanError tells why no frame was extracted, io.EOF once the peer closed
+// the connection, the error of connection such as the timeout of a deadline,
+// or the error of extractor
+func scanError(err error) error {
+	if err == nil {
+		return io.EOF
+	}
+	if _, ok := err.(net.Error); ok {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errorTransportDataExtract, err)
+}
+
 func init() {
 	cmd.DefaultTransports["tcp"] = NewTransport
 }
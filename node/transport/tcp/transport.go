@@ -72,7 +72,18 @@ func (t *tcpTransport) Listen(addr string, opts ...transport.ListenOption) (tran
 	var err error
 
 	// TODO: support use of listen options
-	if t.opts.Secure || t.opts.TLSConfig != nil {
+	if r, ok := nts.MutualTLSFromContext(t.opts.Context); ok {
+		// the client certificates are verified by the files reloaded
+		config, cerr := r.Config()
+		if cerr != nil {
+			return nil, cerr
+		}
+		fn := func(addr string) (net.Listener, error) {
+			return tls.Listen("tcp", addr, config)
+		}
+
+		l, err = mnet.Listen(addr, fn)
+	} else if t.opts.Secure || t.opts.TLSConfig != nil {
 		config := t.opts.TLSConfig
 
 		fn := func(addr string) (net.Listener, error) {
@@ -89,6 +100,7 @@ func (t *tcpTransport) Listen(addr string, opts ...transport.ListenOption) (tran
 				}
 
 				// generate a certificate
+				log.Warnf("no TLS config of %s, the certificate is self-signed and devices are not verified", addr)
 				cert, err := mls.Certificate(hosts...)
 				if err != nil {
 					return nil, err
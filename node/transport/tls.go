@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/transport"
+)
+
+//MutualTLSKey for the files of mutual TLS
+type MutualTLSKey struct{}
+
+//DefaultReloadInterval is the least interval the files of mutual TLS are checked at
+var DefaultReloadInterval = 5 * time.Second
+
+//MutualTLS requires the client certificates of devices verified by the CA bundle,
+//the certificate, key and CA files are reloaded once they change on disk
+func MutualTLS(certFile, keyFile, caFile string) transport.Option {
+	return func(o *transport.Options) {
+		if o.Context == nil {
+			o.Context = context.Background()
+		}
+		o.Context = context.WithValue(o.Context, MutualTLSKey{}, NewCertReloader(certFile, keyFile, caFile))
+	}
+}
+
+//MutualTLSFromContext returns the reloader of mutual TLS set by MutualTLS
+func MutualTLSFromContext(ctx context.Context) (*CertReloader, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	r, ok := ctx.Value(MutualTLSKey{}).(*CertReloader)
+	return r, ok
+}
+
+//CertReloader keeps the certificate and CA bundle of a listener up to date
+//with the files, the connections established keep the ones they verified by
+type CertReloader struct {
+	certFile, keyFile, caFile string
+	// Interval the files are checked at, during handshakes
+	Interval time.Duration
+
+	sync.Mutex
+	config    *tls.Config
+	modTimes  [3]time.Time
+	checkedAt time.Time
+}
+
+//NewCertReloader returns a reloader of files, they are loaded at first handshake
+func NewCertReloader(certFile, keyFile, caFile string) *CertReloader {
+	return &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		Interval: DefaultReloadInterval,
+	}
+}
+
+//Config returns the TLS config of listener, the config of every handshake
+//is the one of the files at that moment
+func (r *CertReloader) Config() (*tls.Config, error) {
+	if _, err := r.current(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current()
+		},
+	}, nil
+}
+
+// current returns the config of files, it reloads the files changed
+func (r *CertReloader) current() (*tls.Config, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	if r.config != nil && now.Sub(r.checkedAt) < r.Interval {
+		return r.config, nil
+	}
+	r.checkedAt = now
+
+	var modTimes [3]time.Time
+	for i, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return r.keep(err)
+		}
+		modTimes[i] = fi.ModTime()
+	}
+	if r.config != nil && modTimes == r.modTimes {
+		return r.config, nil
+	}
+
+	config, err := r.load()
+	if err != nil {
+		return r.keep(err)
+	}
+	if r.config != nil {
+		log.Infof("certificates of %s reloaded", r.certFile)
+	}
+	r.config = config
+	r.modTimes = modTimes
+	return config, nil
+}
+
+// keep the config loaded once the files are broken, e.g. while they are rotated
+func (r *CertReloader) keep(err error) (*tls.Config, error) {
+	if r.config == nil {
+		return nil, err
+	}
+	log.Errorf("unable to reload certificates of %s: %v", r.certFile, err)
+	return r.config, nil
+}
+
+func (r *CertReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(r.caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate in CA bundle %s", r.caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+//TLSSocket is a socket over TLS such as the one of tcp transport
+type TLSSocket interface {
+	// ConnectionState of TLS, ok is false for a plain connection
+	ConnectionState() (state tls.ConnectionState, ok bool)
+}
+
+//PeerIdentity of a device by its client certificate verified
+type PeerIdentity struct {
+	Subject    string
+	CommonName string
+	// SANs are the DNS names, IP addresses, emails and URIs of certificate
+	SANs   []string
+	Serial string
+	// Fingerprint is the SHA-256 of certificate in hex
+	Fingerprint string
+}
+
+//ErrNoPeerCertificate is returned for a connection without client certificate verified
+var ErrNoPeerCertificate = errors.New("no client certificate verified")
+
+//PeerOf returns the identity of the device of a socket, the TLS handshake
+//has been done once the first frame is received
+func PeerOf(sock transport.Socket) (*PeerIdentity, error) {
+	ts, ok := sock.(TLSSocket)
+	if !ok {
+		return nil, ErrNoPeerCertificate
+	}
+	state, ok := ts.ConnectionState()
+	if !ok || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+	return identityOf(state.PeerCertificates[0]), nil
+}
+
+func identityOf(cert *x509.Certificate) *PeerIdentity {
+	sum := sha256.Sum256(cert.Raw)
+	p := &PeerIdentity{
+		Subject:     cert.Subject.String(),
+		CommonName:  cert.Subject.CommonName,
+		Serial:      cert.SerialNumber.String(),
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+	p.SANs = append(p.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		p.SANs = append(p.SANs, ip.String())
+	}
+	p.SANs = append(p.SANs, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		p.SANs = append(p.SANs, u.String())
+	}
+	return p
+}
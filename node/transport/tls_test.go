@@ -0,0 +1,205 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/micro/go-micro/v2/transport"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) pair() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// tlsTestSocket exposes the state of a server connection
+type tlsTestSocket struct {
+	transport.Socket
+	conn *tls.Conn
+}
+
+func (s *tlsTestSocket) ConnectionState() (tls.ConnectionState, bool) {
+	return s.conn.ConnectionState(), true
+}
+
+// handshake a client with a server of config, it returns the server name the
+// client saw and the server side connection
+func handshake(t *testing.T, config *tls.Config, ca *testCert, client *tls.Certificate) (string, *tls.Conn, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	clientConfig := &tls.Config{RootCAs: pool, ServerName: "edge"}
+	if client != nil {
+		clientConfig.Certificates = []tls.Certificate{*client}
+	}
+
+	servers := make(chan *tls.Conn, 1)
+	done := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		server := tls.Server(c, config)
+		servers <- server
+		done <- server.Handshake()
+	}()
+
+	c, err := tls.Dial("tcp", l.Addr().String(), clientConfig)
+	if err != nil {
+		<-done
+		return "", nil, err
+	}
+	defer c.Close()
+	server := <-servers
+	if err := <-done; err != nil {
+		return "", server, err
+	}
+	return c.ConnectionState().PeerCertificates[0].Subject.CommonName, server, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "edge.pem")
+	keyFile := filepath.Join(dir, "edge-key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	ca := newTestCert(t, "fleet-ca", 1, nil)
+	ca.write(t, caFile, "")
+	newTestCert(t, "edge", 2, ca).write(t, certFile, keyFile)
+	device := newTestCert(t, "meter-42", 3, ca).pair()
+
+	r := NewCertReloader(certFile, keyFile, caFile)
+	r.Interval = 0
+	config, err := r.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a device without certificate is refused
+	if _, _, err := handshake(t, config, ca, nil); err == nil {
+		t.Fatal("Expected handshake refused without client certificate")
+	}
+
+	name, server, err := handshake(t, config, ca, &device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "edge" {
+		t.Fatalf("Expected server certificate edge, got %s", name)
+	}
+	id, err := PeerOf(&tlsTestSocket{conn: server})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.CommonName != "meter-42" || id.Serial != "3" || len(id.SANs) != 1 || id.SANs[0] != "meter-42" || len(id.Fingerprint) != 64 {
+		t.Fatalf("Unexpected identity %+v", id)
+	}
+
+	// the rotated certificate is served at the next handshake
+	rotated := newTestCert(t, "edge", 4, ca)
+	rotated.write(t, certFile, keyFile)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	_, server, err = handshake(t, config, ca, &device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := server.ConnectionState().PeerCertificates[0].SerialNumber; s.Int64() != 3 {
+		t.Fatalf("Unexpected peer serial %v", s)
+	}
+	if r.config.Certificates[0].Leaf != nil && r.config.Certificates[0].Leaf.SerialNumber.Int64() != 4 {
+		t.Fatal("Expected rotated certificate")
+	}
+	leaf, _ := x509.ParseCertificate(r.config.Certificates[0].Certificate[0])
+	if leaf.SerialNumber.Int64() != 4 {
+		t.Fatalf("Expected rotated certificate 4, got %v", leaf.SerialNumber)
+	}
+
+	// a broken file keeps the certificate loaded
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	future = future.Add(time.Minute)
+	os.Chtimes(keyFile, future, future)
+	if _, _, err := handshake(t, config, ca, &device); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := PeerOf(&tlsTestSocket{conn: tls.Server(nil, config)}); err != ErrNoPeerCertificate {
+		t.Fatalf("Expected %v, got %v", ErrNoPeerCertificate, err)
+	}
+}
@@ -0,0 +1,312 @@
+package udp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	nts "github.com/micro-community/x-edge/node/transport"
+	log "github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/transport"
+	maddr "github.com/micro/go-micro/v2/util/addr"
+	mls "github.com/micro/go-micro/v2/util/tls"
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/protocol"
+	"github.com/pion/dtls/v2/pkg/protocol/recordlayer"
+	pudp "github.com/pion/udp"
+)
+
+//DTLSHandshakeTimeout is the time a device has to complete the handshake
+var DTLSHandshakeTimeout = 10 * time.Second
+
+//DTLSSessionTimeout is the time an idle session is kept without transport timeout
+var DTLSSessionTimeout = 5 * time.Minute
+
+//DTLSRecvMaxLen is the largest record of application data
+const DTLSRecvMaxLen = 16384
+
+//PSKLookup returns the pre-shared key of a device by the identity it sends
+type PSKLookup func(identity string) ([]byte, error)
+
+type dtlsKey struct{}
+
+var certificateSuites = []dtls.CipherSuiteID{
+	dtls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	dtls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	dtls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	dtls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+}
+
+var pskSuites = []dtls.CipherSuiteID{
+	dtls.TLS_PSK_WITH_AES_128_GCM_SHA256,
+	dtls.TLS_PSK_WITH_AES_128_CCM,
+	dtls.TLS_PSK_WITH_AES_128_CCM_8,
+	dtls.TLS_PSK_WITH_AES_128_CBC_SHA256,
+}
+
+type dtlsOptions struct {
+	certFile, keyFile, caFile string
+	hint                      string
+	psk                       PSKLookup
+}
+
+//DTLSCertificate secures the transport by DTLS 1.2 with a certificate,
+//the client certificates of devices are verified by the CA bundle unless caFile is empty
+func DTLSCertificate(certFile, keyFile, caFile string) transport.Option {
+	return func(o *transport.Options) {
+		d := dtlsFromContext(o.Context)
+		d.certFile, d.keyFile, d.caFile = certFile, keyFile, caFile
+		o.Context = context.WithValue(contextOf(o), dtlsKey{}, d)
+	}
+}
+
+//DTLSPSK secures the transport by DTLS 1.2 with the pre-shared keys of devices,
+//the hint is sent to devices to choose their identity
+func DTLSPSK(hint string, keys PSKLookup) transport.Option {
+	return func(o *transport.Options) {
+		d := dtlsFromContext(o.Context)
+		d.hint, d.psk = hint, keys
+		o.Context = context.WithValue(contextOf(o), dtlsKey{}, d)
+	}
+}
+
+func contextOf(o *transport.Options) context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// dtlsFromContext returns a copy of the options, so the contexts derived keep theirs
+func dtlsFromContext(ctx context.Context) dtlsOptions {
+	if ctx == nil {
+		return dtlsOptions{}
+	}
+	d, _ := ctx.Value(dtlsKey{}).(dtlsOptions)
+	return d
+}
+
+//dtlsConfig returns the DTLS config of transport, nil for plaintext
+func (u *udpTransport) dtlsConfig(addr string) (*dtls.Config, error) {
+	d := dtlsFromContext(u.opts.Context)
+	config := &dtls.Config{
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+
+	switch {
+	case len(d.certFile) > 0:
+		cert, err := tls.LoadX509KeyPair(d.certFile, d.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+		if len(d.caFile) > 0 {
+			ca, err := ioutil.ReadFile(d.caFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no certificate in CA bundle %s", d.caFile)
+			}
+			config.ClientCAs = pool
+			config.ClientAuth = dtls.RequireAndVerifyClientCert
+		}
+	case u.opts.TLSConfig != nil:
+		// the client auth types of DTLS are declared in the order of TLS
+		config.Certificates = u.opts.TLSConfig.Certificates
+		config.ClientCAs = u.opts.TLSConfig.ClientCAs
+		config.ClientAuth = dtls.ClientAuthType(u.opts.TLSConfig.ClientAuth)
+	case u.opts.Secure && d.psk == nil:
+		hosts := []string{addr}
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if len(host) == 0 {
+				hosts = maddr.IPs()
+			} else {
+				hosts = []string{host}
+			}
+		}
+		log.Warnf("no DTLS config of %s, the certificate is self-signed and devices are not verified", addr)
+		cert, err := mls.Certificate(hosts...)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if d.psk != nil {
+		lookup := d.psk
+		config.PSK = func(identity []byte) ([]byte, error) {
+			return lookup(string(identity))
+		}
+		if len(d.hint) > 0 {
+			config.PSKIdentityHint = []byte(d.hint)
+		}
+		// the default suites of DTLS are the ones of certificates only
+		config.CipherSuites = append([]dtls.CipherSuiteID(nil), pskSuites...)
+		if len(config.Certificates) > 0 {
+			config.CipherSuites = append(config.CipherSuites, certificateSuites...)
+		}
+	}
+
+	if len(config.Certificates) == 0 && config.PSK == nil {
+		return nil, nil
+	}
+	return config, nil
+}
+
+//isHandshake accepts the sessions started by a handshake record only,
+//the stray datagrams of peers unknown are dropped
+func isHandshake(packet []byte) bool {
+	pkts, err := recordlayer.UnpackDatagram(packet)
+	if err != nil || len(pkts) < 1 {
+		return false
+	}
+	h := &recordlayer.Header{}
+	if err := h.Unmarshal(pkts[0]); err != nil {
+		return false
+	}
+	return h.ContentType == protocol.ContentTypeHandshake
+}
+
+//dtlsListener keeps a DTLS session per peer by remote address
+type dtlsListener struct {
+	timeout  time.Duration
+	config   *dtls.Config
+	listener net.Listener
+}
+
+func (u *udpTransport) listenDTLS(addr *net.UDPAddr, config *dtls.Config) (transport.Listener, error) {
+	lc := pudp.ListenConfig{AcceptFilter: isHandshake}
+	l, err := lc.Listen("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &dtlsListener{
+		timeout:  u.opts.Timeout,
+		config:   config,
+		listener: l,
+	}, nil
+}
+
+//Accept the sessions of peers, a socket is served once its handshake is done
+func (d *dtlsListener) Accept(fn func(transport.Socket)) error {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go d.handshake(conn, fn)
+	}
+}
+
+func (d *dtlsListener) handshake(conn net.Conn, fn func(transport.Socket)) {
+	ctx, cancel := context.WithTimeout(context.Background(), DTLSHandshakeTimeout)
+	defer cancel()
+
+	dc, err := dtls.ServerWithContext(ctx, conn, d.config)
+	if err != nil {
+		log.Errorf("DTLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	timeout := d.timeout
+	if timeout <= 0 {
+		timeout = DTLSSessionTimeout
+	}
+	fn(&dtlsSocket{
+		conn:     dc,
+		timeout:  timeout,
+		verified: d.config.ClientAuth >= dtls.VerifyClientCertIfGiven,
+	})
+}
+
+func (d *dtlsListener) Addr() string {
+	return d.listener.Addr().String()
+}
+
+func (d *dtlsListener) Close() error {
+	return d.listener.Close()
+}
+
+//dtlsSocket is the session of a peer, a record is a frame
+type dtlsSocket struct {
+	conn    *dtls.Conn
+	timeout time.Duration
+	// verified marks the client certificates verified by the CA bundle
+	verified bool
+}
+
+func (s *dtlsSocket) Local() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *dtlsSocket) Remote() string {
+	return s.conn.RemoteAddr().String()
+}
+
+func (s *dtlsSocket) Recv(m *transport.Message) error {
+	if m == nil {
+		return errors.New("message passed in is nil")
+	}
+	s.conn.SetReadDeadline(time.Now().Add(s.timeout))
+
+	buf := make([]byte, DTLSRecvMaxLen)
+	n, err := s.conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	m.Body = buf[:n]
+	return nil
+}
+
+func (s *dtlsSocket) Send(m *transport.Message) error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	_, err := s.conn.Write(m.Body)
+	return err
+}
+
+func (s *dtlsSocket) Close() error {
+	return s.conn.Close()
+}
+
+//ConnectionState maps the certificates of DTLS session to the state of TLS,
+//so the identity of client certificate verified is the one of nts.PeerOf
+func (s *dtlsSocket) ConnectionState() (tls.ConnectionState, bool) {
+	var state tls.ConnectionState
+	for _, raw := range s.conn.ConnectionState().PeerCertificates {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return state, true
+		}
+		state.PeerCertificates = append(state.PeerCertificates, cert)
+	}
+	state.HandshakeComplete = true
+	if s.verified && len(state.PeerCertificates) > 0 {
+		state.VerifiedChains = [][]*x509.Certificate{state.PeerCertificates}
+	}
+	return state, true
+}
+
+//PSKIdentity returns the identity a device is authenticated by its pre-shared key
+func (s *dtlsSocket) PSKIdentity() string {
+	return string(s.conn.ConnectionState().IdentityHint)
+}
+
+//PSKIdentityOf returns the identity of pre-shared key of the device of a socket
+func PSKIdentityOf(sock transport.Socket) (string, bool) {
+	ps, ok := sock.(interface{ PSKIdentity() string })
+	if !ok {
+		return "", false
+	}
+	id := ps.PSKIdentity()
+	return id, len(id) > 0
+}
+
+var _ nts.TLSSocket = (*dtlsSocket)(nil)
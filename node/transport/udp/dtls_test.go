@@ -0,0 +1,165 @@
+package udp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	nts "github.com/micro-community/x-edge/node/transport"
+	"github.com/micro/go-micro/v2/transport"
+	"github.com/pion/dtls/v2"
+)
+
+func newDTLSTestCert(t *testing.T, cn string, serial int64, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// echoDTLS listens by the options, a device dials with the client config and
+// gets its frame echoed, the socket served is returned
+func echoDTLS(t *testing.T, client *dtls.Config, opts ...transport.Option) (transport.Socket, error) {
+	l, err := NewTransport(opts...).Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, ok := l.(*dtlsListener); !ok {
+		t.Fatalf("Expected DTLS listener, got %T", l)
+	}
+
+	socks := make(chan transport.Socket, 1)
+	go l.Accept(func(sock transport.Socket) {
+		var m transport.Message
+		if err := sock.Recv(&m); err != nil {
+			return
+		}
+		socks <- sock
+		sock.Send(&m)
+	})
+
+	raddr, _ := net.ResolveUDPAddr("udp", l.Addr())
+	conn, err := dtls.Dial("udp", raddr, client)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	frame := []byte("<NAME>meter-42</NAME>")
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, DTLSRecvMaxLen)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != string(frame) {
+		t.Fatalf("Expected frame echoed, got %q", buf[:n])
+	}
+	return <-socks, nil
+}
+
+func TestDTLSPlaintextDefault(t *testing.T) {
+	u := NewTransport().(*udpTransport)
+	config, err := u.dtlsConfig(":0")
+	if err != nil || config != nil {
+		t.Fatalf("Expected plaintext by default, got %v %v", config, err)
+	}
+}
+
+func TestDTLSPSK(t *testing.T) {
+	keys := func(identity string) ([]byte, error) {
+		if identity != "meter-42" {
+			return nil, errors.New("unknown device")
+		}
+		return []byte{0xAB, 0xC1, 0x23}, nil
+	}
+	client := &dtls.Config{
+		PSK: func([]byte) ([]byte, error) {
+			return []byte{0xAB, 0xC1, 0x23}, nil
+		},
+		PSKIdentityHint: []byte("meter-42"),
+		CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_GCM_SHA256},
+	}
+
+	sock, err := echoDTLS(t, client, DTLSPSK("edge", keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := PSKIdentityOf(sock); !ok || id != "meter-42" {
+		t.Fatalf("Expected PSK identity meter-42, got %q", id)
+	}
+	if _, err := nts.PeerOf(sock); err != nts.ErrNoPeerCertificate {
+		t.Fatalf("Expected no peer certificate, got %v", err)
+	}
+}
+
+func TestDTLSCertificate(t *testing.T) {
+	ca := newDTLSTestCert(t, "fleet-ca", 1, nil)
+	edge := newDTLSTestCert(t, "edge", 2, &ca)
+	device := newDTLSTestCert(t, "meter-42", 3, &ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	server := &tls.Config{
+		Certificates: []tls.Certificate{edge},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	client := &dtls.Config{
+		RootCAs:              pool,
+		ServerName:           "edge",
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+
+	// a device without certificate is refused
+	if _, err := echoDTLS(t, client, transport.TLSConfig(server)); err == nil {
+		t.Fatal("Expected handshake refused without client certificate")
+	}
+
+	client.Certificates = []tls.Certificate{device}
+	sock, err := echoDTLS(t, client, transport.TLSConfig(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := nts.PeerOf(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.CommonName != "meter-42" || id.Serial != "3" {
+		t.Fatalf("Unexpected identity %+v", id)
+	}
+}
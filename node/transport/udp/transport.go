@@ -45,6 +45,15 @@ func (u *udpTransport) Listen(addr string, opts ...transport.ListenOption) (tran
 		return nil, err
 	}
 
+	// plaintext unless DTLS is configured
+	config, err := u.dtlsConfig(addr)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		return u.listenDTLS(udpAddress, config)
+	}
+
 	l, err := net.ListenUDP("udp", udpAddress)
 	//p, err := net.ListenPacket("udp", addr)
 
@@ -0,0 +1,65 @@
+package shadow
+
+import (
+	"context"
+
+	"github.com/micro/go-micro/v2/errors"
+)
+
+// GetRequest of the shadow of a device
+type GetRequest struct {
+	Device string `json:"device"`
+}
+
+// DesireRequest sets the desired state of a device
+type DesireRequest struct {
+	Device string                 `json:"device"`
+	State  map[string]interface{} `json:"state"`
+}
+
+// ListRequest of the devices with shadow
+type ListRequest struct{}
+
+// ListResponse of the devices with shadow
+type ListResponse struct {
+	Devices []string `json:"devices"`
+}
+
+// Shadow is the go-micro handler querying the shadows, e.g. "Shadow.Get"
+type Shadow struct {
+	manager *Manager
+}
+
+// NewHandler returns the go-micro handler of a manager
+func NewHandler(m *Manager) *Shadow {
+	return &Shadow{manager: m}
+}
+
+// Get returns the shadow of a device
+func (s *Shadow) Get(ctx context.Context, req *GetRequest, rsp *Document) error {
+	doc, ok := s.manager.Store().Get(req.Device)
+	if !ok {
+		return errors.NotFound("edge.shadow", "no shadow of device %q", req.Device)
+	}
+	*rsp = *doc
+	return nil
+}
+
+// Desire sets the desired state of a device and returns its shadow
+func (s *Shadow) Desire(ctx context.Context, req *DesireRequest, rsp *Document) error {
+	if len(req.Device) == 0 {
+		return errors.BadRequest("edge.shadow", "device is required")
+	}
+	doc, err := s.manager.Desire(req.Device, req.State)
+	if err != nil {
+		return errors.InternalServerError("edge.shadow", err.Error())
+	}
+	*rsp = *doc
+	return nil
+}
+
+// List returns the devices with shadow
+func (s *Shadow) List(ctx context.Context, req *ListRequest, rsp *ListResponse) error {
+	rsp.Devices = s.manager.Store().List()
+	return nil
+}
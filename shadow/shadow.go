@@ -0,0 +1,164 @@
+package shadow
+
+import (
+	"context"
+	"strings"
+
+	xmlc "github.com/micro-community/x-edge/node/codec"
+	nserver "github.com/micro-community/x-edge/node/server"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	log "github.com/micro/go-micro/v2/logger"
+	"github.com/micro/go-micro/v2/server"
+)
+
+// DefaultCommandType is the packet type of the frames delivering the delta to devices
+const DefaultCommandType = "DESIRED"
+
+// Manager keeps the shadows of the devices of a node server
+type Manager struct {
+	store   *Store
+	devices nserver.Server
+	// fields maps the keys of reported state to the paths of frame elements
+	fields map[string]string
+	// types of the frames updating reported state, all of them if it is empty
+	types map[string]bool
+	// commandType of the frames delivering the delta
+	commandType string
+}
+
+// Options of manager
+type Options struct {
+	// Fields maps the keys of reported state to the paths of frame elements, e.g. "READING.VALUE"
+	Fields map[string]string
+	// Types of the frames updating reported state, all of them if it is empty
+	Types []string
+	// CommandType of the frames delivering the delta, DefaultCommandType if it is empty
+	CommandType string
+}
+
+// NewManager creates a manager of the shadows in store for the devices of server
+func NewManager(store *Store, devices nserver.Server, opts Options) *Manager {
+	m := &Manager{
+		store:       store,
+		devices:     devices,
+		fields:      opts.Fields,
+		types:       make(map[string]bool),
+		commandType: opts.CommandType,
+	}
+	for _, t := range opts.Types {
+		m.types[t] = true
+	}
+	if len(m.commandType) == 0 {
+		m.commandType = DefaultCommandType
+	}
+	return m
+}
+
+// Store of shadows
+func (m *Manager) Store() *Store {
+	return m.store
+}
+
+// HandlerWrapper updates the reported state from the frames handled successfully
+func (m *Manager) HandlerWrapper() server.HandlerWrapper {
+	return func(fn server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			if err := fn(ctx, req, rsp); err != nil {
+				return err
+			}
+			if err := m.report(req); err != nil {
+				log.Errorf("unable to update shadow of %s: %v", req.Header()["NAME"], err)
+			}
+			return nil
+		}
+	}
+}
+
+func (m *Manager) report(req server.Request) error {
+	hdr := req.Header()
+	device := hdr["NAME"]
+	if len(device) == 0 || len(m.fields) == 0 {
+		return nil
+	}
+	if len(m.types) > 0 && !m.types[hdr["TYPE"]] {
+		return nil
+	}
+
+	elems, err := xmlc.ToMap(nserver.FrameOf(req))
+	if err != nil {
+		return err
+	}
+	state := make(map[string]interface{})
+	for key, path := range m.fields {
+		if v, ok := lookup(elems, path); ok {
+			state[key] = v
+		}
+	}
+	if len(state) == 0 {
+		return nil
+	}
+	_, err = m.store.Report(device, state)
+	return err
+}
+
+// lookup the value of an element by path
+func lookup(elems map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = elems
+	for _, p := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[p]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Watch delivers the delta to a device once it connects, it is a nserver.DeviceHook
+func (m *Manager) Watch(device string, connected bool) {
+	if !connected {
+		return
+	}
+	if err := m.Sync(device); err != nil {
+		log.Errorf("unable to sync shadow of %s: %v", device, err)
+	}
+}
+
+// Desire sets the desired state of a device, the delta is delivered
+// at once if the device is connected
+func (m *Manager) Desire(device string, state map[string]interface{}) (*Document, error) {
+	doc, err := m.store.Desire(device, state)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.deliver(doc); err != nil && err != nserver.ErrNotConnected {
+		log.Errorf("unable to sync shadow of %s: %v", device, err)
+	}
+	return doc, nil
+}
+
+// Sync delivers the delta between desired and reported state to the device
+func (m *Manager) Sync(device string) error {
+	doc, ok := m.store.Get(device)
+	if !ok {
+		return nil
+	}
+	return m.deliver(doc)
+}
+
+func (m *Manager) deliver(doc *Document) error {
+	delta := doc.Delta()
+	if len(delta) == 0 {
+		return nil
+	}
+	delta["NAME"] = doc.Device
+	delta["TYPE"] = m.commandType
+
+	frame, err := xmlc.FromMap("PROTOCOL", delta)
+	if err != nil {
+		return err
+	}
+	return m.devices.Deliver(doc.Device, nil, &raw.Frame{Data: frame})
+}
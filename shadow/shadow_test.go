@@ -0,0 +1,140 @@
+package shadow
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	nserver "github.com/micro-community/x-edge/node/server"
+	"github.com/micro/go-micro/v2/codec"
+	raw "github.com/micro/go-micro/v2/codec/bytes"
+	"github.com/micro/go-micro/v2/server"
+)
+
+type testRequest struct {
+	header map[string]string
+	body   []byte
+}
+
+func (r *testRequest) Service() string           { return "ProtocolServer" }
+func (r *testRequest) Method() string            { return "Event" }
+func (r *testRequest) Endpoint() string          { return "ProtocolServer.Event" }
+func (r *testRequest) ContentType() string       { return "application/xml" }
+func (r *testRequest) Header() map[string]string { return r.header }
+func (r *testRequest) Body() interface{}         { return &codec.Message{Body: r.body} }
+func (r *testRequest) Read() ([]byte, error)     { return r.body, nil }
+func (r *testRequest) Codec() codec.Reader       { return nil }
+func (r *testRequest) Stream() bool              { return false }
+
+// testDevices is a node server recording the deliveries
+type testDevices struct {
+	server.Server
+	connected map[string]bool
+	delivered []string
+}
+
+func (d *testDevices) Connections() []string { return nil }
+
+func (d *testDevices) Deliver(device string, hdr map[string]string, msg interface{}) error {
+	if !d.connected[device] {
+		return nserver.ErrNotConnected
+	}
+	d.delivered = append(d.delivered, string(msg.(*raw.Frame).Data))
+	return nil
+}
+
+func newManager(t *testing.T) (*Manager, *testDevices, string) {
+	dir, err := ioutil.TempDir("", "shadow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := &testDevices{connected: make(map[string]bool)}
+	m := NewManager(store, devices, Options{
+		Fields: map[string]string{"reading": "READING.VALUE", "mode": "MODE"},
+		Types:  []string{"1"},
+	})
+	return m, devices, dir
+}
+
+func report(t *testing.T, m *Manager, packetType, body string) {
+	fn := m.HandlerWrapper()(func(ctx context.Context, req server.Request, rsp interface{}) error {
+		return nil
+	})
+	req := &testRequest{
+		header: map[string]string{"NAME": "meter-42", "TYPE": packetType},
+		body:   []byte("<PROTOCOL><NAME>meter-42</NAME><TYPE>" + packetType + "</TYPE>" + body + "</PROTOCOL>"),
+	}
+	if err := fn(context.Background(), req, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReported(t *testing.T) {
+	m, _, dir := newManager(t)
+
+	report(t, m, "1", "<READING><VALUE>42.1</VALUE></READING><MODE>eco</MODE>")
+	// other packet types do not report
+	report(t, m, "2", "<MODE>boost</MODE>")
+
+	doc, ok := m.Store().Get("meter-42")
+	if !ok || doc.Reported["reading"] != "42.1" || doc.Reported["mode"] != "eco" {
+		t.Fatalf("Unexpected shadow %+v", doc)
+	}
+
+	// the shadows survive restarts
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, ok = store.Get("meter-42")
+	if !ok || doc.Reported["reading"] != "42.1" || doc.Version != 1 {
+		t.Fatalf("Unexpected shadow after reopen %+v", doc)
+	}
+}
+
+func TestDesiredDelta(t *testing.T) {
+	m, devices, _ := newManager(t)
+	report(t, m, "1", "<MODE>eco</MODE>")
+
+	// the device is offline, the delta waits for it
+	h := NewHandler(m)
+	rsp := new(Document)
+	if err := h.Desire(context.Background(), &DesireRequest{Device: "meter-42", State: map[string]interface{}{"mode": "boost", "limit": 10}}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if len(devices.delivered) != 0 {
+		t.Fatalf("Unexpected delivery %v", devices.delivered)
+	}
+
+	devices.connected["meter-42"] = true
+	m.Watch("meter-42", true)
+	expected := "<PROTOCOL><LIMIT>10</LIMIT><MODE>boost</MODE><NAME>meter-42</NAME><TYPE>DESIRED</TYPE></PROTOCOL>"
+	if len(devices.delivered) != 1 || !strings.HasSuffix(devices.delivered[0], expected) {
+		t.Fatalf("Expected delta %s, got %v", expected, devices.delivered)
+	}
+
+	// the reported state catches up, nothing is left to deliver
+	report(t, m, "1", "<MODE>boost</MODE>")
+	if err := h.Desire(context.Background(), &DesireRequest{Device: "meter-42", State: map[string]interface{}{"limit": nil}}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if len(devices.delivered) != 1 || len(rsp.Delta()) != 0 {
+		t.Fatalf("Unexpected delta %v", rsp.Delta())
+	}
+
+	list := new(ListResponse)
+	if err := h.List(context.Background(), &ListRequest{}, list); err != nil || len(list.Devices) != 1 {
+		t.Fatalf("Expected 1 device, got %v %v", list.Devices, err)
+	}
+	if err := h.Get(context.Background(), &GetRequest{Device: "meter-7"}, new(Document)); err == nil {
+		t.Fatal("Expected not found error")
+	}
+}
@@ -0,0 +1,185 @@
+// Package shadow keeps the last known state of devices on the edge, the
+// reported document of a device is updated from its frames and the desired
+// one is set by upstream services, their delta is delivered to the device
+package shadow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const docExt = ".json"
+
+// Document is the shadow of a device
+type Document struct {
+	Device     string                 `json:"device"`
+	Reported   map[string]interface{} `json:"reported"`
+	Desired    map[string]interface{} `json:"desired"`
+	Version    uint64                 `json:"version"`
+	ReportedAt time.Time              `json:"reported_at,omitempty"`
+	DesiredAt  time.Time              `json:"desired_at,omitempty"`
+}
+
+// Delta returns the desired state which differs from the reported one
+func (d *Document) Delta() map[string]interface{} {
+	delta := make(map[string]interface{})
+	for k, v := range d.Desired {
+		if r, ok := d.Reported[k]; !ok || fmt.Sprint(r) != fmt.Sprint(v) {
+			delta[k] = v
+		}
+	}
+	return delta
+}
+
+func (d *Document) copy() *Document {
+	c := *d
+	c.Reported = make(map[string]interface{}, len(d.Reported))
+	for k, v := range d.Reported {
+		c.Reported[k] = v
+	}
+	c.Desired = make(map[string]interface{}, len(d.Desired))
+	for k, v := range d.Desired {
+		c.Desired[k] = v
+	}
+	return &c
+}
+
+// Store persists one file per document in its directory
+type Store struct {
+	dir string
+
+	sync.RWMutex
+	docs map[string]*Document
+}
+
+// Open opens a store in a directory, the documents persisted are loaded
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:  dir,
+		docs: make(map[string]*Document),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), docExt) {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		doc := new(Document)
+		if err := json.Unmarshal(b, doc); err != nil {
+			return nil, fmt.Errorf("shadow %s: %v", f.Name(), err)
+		}
+		s.docs[doc.Device] = doc
+	}
+	return s, nil
+}
+
+func (s *Store) path(device string) string {
+	// device ids come from frames, keep them inside the directory
+	return filepath.Join(s.dir, url.PathEscape(device)+docExt)
+}
+
+// Get returns a copy of the document of a device
+func (s *Store) Get(device string) (*Document, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	doc, ok := s.docs[device]
+	if !ok {
+		return nil, false
+	}
+	return doc.copy(), true
+}
+
+// List returns the devices of documents
+func (s *Store) List() []string {
+	s.RLock()
+	defer s.RUnlock()
+	devices := make([]string, 0, len(s.docs))
+	for device := range s.docs {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// Report merges the state reported by a device
+func (s *Store) Report(device string, state map[string]interface{}) (*Document, error) {
+	return s.update(device, func(doc *Document) {
+		merge(doc.Reported, state)
+		doc.ReportedAt = time.Now()
+	})
+}
+
+// Desire merges the state desired by upstream services,
+// a nil value removes the key from desired state
+func (s *Store) Desire(device string, state map[string]interface{}) (*Document, error) {
+	return s.update(device, func(doc *Document) {
+		merge(doc.Desired, state)
+		doc.DesiredAt = time.Now()
+	})
+}
+
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+func (s *Store) update(device string, fn func(*Document)) (*Document, error) {
+	if len(device) == 0 {
+		return nil, fmt.Errorf("shadow without device id")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	doc, ok := s.docs[device]
+	if !ok {
+		doc = &Document{
+			Device:   device,
+			Reported: make(map[string]interface{}),
+			Desired:  make(map[string]interface{}),
+		}
+	}
+	next := doc.copy()
+	fn(next)
+	next.Version++
+
+	b, err := json.Marshal(next)
+	if err != nil {
+		return nil, err
+	}
+	// write the document aside and rename it, so a crash never leaves a partial one
+	tmp := s.path(device) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, s.path(device)); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	s.docs[device] = next
+	return next.copy(), nil
+}